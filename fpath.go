@@ -1,32 +1,143 @@
 package fpath
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/fletcharoo/fpath/internal/lexer"
 	"github.com/fletcharoo/fpath/internal/parser"
 	"github.com/fletcharoo/fpath/internal/runtime"
 )
 
+// Precedence selects how binary operators are grouped when a query is
+// compiled.
+type Precedence int
+
+const (
+	// PrecedenceLeftToRight evaluates arithmetic operators strictly
+	// left-to-right, so "2 + 3 * 4" is 20. This is the default.
+	PrecedenceLeftToRight Precedence = iota
+	// PrecedenceStandard uses conventional operator precedence, from
+	// tightest to loosest: ^, then * / // %, then + -, then |, then comparisons
+	// and in, then &&, then ||, then ??, and finally the ternary operator. "2 + 3 * 4"
+	// is 14.
+	// Operators of equal precedence, including ^, are left-associative.
+	PrecedenceStandard
+)
+
+// Option configures how a query is compiled.
+type Option func(*compileOptions)
+
+// compileOptions holds the settings applied by Options.
+type compileOptions struct {
+	precedence Precedence
+	variables  []string // nil when variables are not checked
+	now        func() time.Time
+}
+
+// WithPrecedence sets the operator precedence mode used to compile a query.
+func WithPrecedence(precedence Precedence) Option {
+	return func(o *compileOptions) {
+		o.precedence = precedence
+	}
+}
+
+// WithVariables declares the names of the variables a query may reference.
+// Compiling a query that references any other variable fails. Without this
+// option, variables are only checked when the query is evaluated.
+func WithVariables(names ...string) Option {
+	return func(o *compileOptions) {
+		if o.variables == nil {
+			o.variables = []string{}
+		}
+		o.variables = append(o.variables, names...)
+	}
+}
+
+// WithClock sets the function now() calls to read the current time, so that
+// queries depending on it can be evaluated against a fixed time. Without this
+// option, now() uses time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(o *compileOptions) {
+		o.now = now
+	}
+}
+
 // Query represents a compiled fpath expression that can be evaluated multiple times
 // with different input data. The Query type is opaque to external users.
 type Query struct {
-	expr parser.Expr
+	query     string
+	expr      parser.Expr
+	functions map[string]runtime.FunctionFunc
+	now       func() time.Time
 }
 
+// Errors wrapped by an *EvalError, identifying why evaluation failed. Use
+// errors.Is to check for them. ErrUndefinedVariable, ErrInvalidRegex and
+// ErrShadowedVariable are also wrapped by a *CompileError when the problem is
+// found while compiling.
+var (
+	ErrIncompatibleTypes    = runtime.ErrIncompatibleTypes
+	ErrDivisionByZero       = runtime.ErrDivisionByZero
+	ErrBooleanOperation     = runtime.ErrBooleanOperation
+	ErrIndexOutOfBounds     = runtime.ErrIndexOutOfBounds
+	ErrInvalidIndex         = runtime.ErrInvalidIndex
+	ErrKeyNotFound          = runtime.ErrKeyNotFound
+	ErrInvalidMapIndex      = runtime.ErrInvalidMapIndex
+	ErrUndefinedFunction    = runtime.ErrUndefinedFunction
+	ErrInvalidArgumentCount = runtime.ErrInvalidArgumentCount
+	ErrInvalidArgumentType  = runtime.ErrInvalidArgumentType
+	ErrUndefinedVariable    = runtime.ErrUndefinedVariable
+	ErrInvalidLambda        = runtime.ErrInvalidLambda
+	ErrInvalidRegex         = runtime.ErrInvalidRegex
+	ErrShadowedVariable     = runtime.ErrShadowedVariable
+	ErrDuplicateKey         = runtime.ErrDuplicateKey
+	ErrInvalidTime          = runtime.ErrInvalidTime
+)
+
 // Compile parses and validates an fpath query string, returning a Query that
 // can be evaluated multiple times with different input data.
 //
 // The query string follows the fpath expression syntax, supporting:
-// - Arithmetic operations: +, -, *, /, //, %, ^ (evaluated left-to-right)
-// - Comparison operations: ==, !=, <, <=, >, >=
-// - Logical operations: &&, ||
-// - Ternary conditional: condition ? true_expr : false_expr
-// - Indexing: list[index], map[key], string[index]
-// - Slicing: list[start:end], string[start:end]
-// - Functions: len(), filter(), contains(), abs(), min(), max(), round(), floor(), ceil()
-// - Literals: numbers, strings, booleans, lists, maps
-// - Input data reference: $
+//   - Arithmetic operations: +, -, *, /, //, %, ^ (evaluated left-to-right;
+//     see CompileWithOptions for conventional precedence)
+//   - Comparison operations: ==, !=, <, <=, >, >=
+//   - Membership: value in collection, value not in collection
+//   - Regular expression matching: value =~ pattern
+//   - Logical operations: &&, ||, !
+//   - Unary operators: -, +, ! (bind to the operand that follows them)
+//   - Ternary conditional: condition ? true_expr : false_expr
+//   - Indexing: list[index], map[key], string[index]
+//   - Field access: $.name, map.key, struct.Field (chainable with indexing)
+//   - Optional access: $.a?.b, $.list?[0] yield null for null or missing values
+//   - Null coalescing: $.discount ?? 0
+//   - Slicing: list[start:end], string[start:end]
+//   - Functions: len(), filter(), map(), reduce(), any(), all(), find(), findIndex(), count(),
+//     contains(), abs(), min(), max(), round(), floor(), ceil(), sort(),
+//     sort_desc(), sort_by(),
+//     matches(), find_all(), replace_re(), capture(),
+//     upper(), lower(), title(), trim(), trim_left(), trim_right(), split(), join(),
+//     starts_with(), ends_with(), replace(), index_of(), pad_left(), pad_right(),
+//     repeat(), reverse(),
+//     keys(), values(), entries(), from_entries(), merge(), deep_merge(), pick(),
+//     omit(), map_values(),
+//     sum(), product(), avg(), median(), mode(), variance(), stddev(), percentile(),
+//     unique(), flatten(), zip(), concat(), chunk(), range(), first(), last(),
+//     take(), drop(),
+//     group_by(), count_by(), index_by(), partition(),
+//     now(), parse_time(), format_time(), duration(), date_diff(), year(),
+//     month(), day(), hour(), minute(), second(), weekday(), unix(),
+//     plus any functions registered on an Environment
+//   - Literals: numbers, strings, booleans, null, lists, maps
+//   - Input data reference: $
+//   - Named variables: bare names such as threshold, see EvaluateWithVars
+//   - Bindings: let name = value; body
+//   - Lambdas: o => body, (acc, x) => body, as function arguments
+//   - Pipes: value | f(args...) calls f(value, args...)
 //
 // Example:
 //
@@ -36,33 +147,246 @@ type Query struct {
 //	}
 //	result, err := query.Evaluate(inputData)
 func Compile(query string) (*Query, error) {
+	return CompileWithOptions(query)
+}
+
+// CompileWithOptions is like Compile but applies the given options.
+//
+// Example:
+//
+//	query, err := CompileWithOptions("2 + 3 * 4", WithPrecedence(PrecedenceStandard))
+//	// query evaluates to 14
+func CompileWithOptions(query string, opts ...Option) (*Query, error) {
+	return compile(query, nil, opts)
+}
+
+// compile parses the query string and returns a Query that can call the given
+// host-registered functions.
+func compile(query string, functions map[string]runtime.FunctionFunc, opts []Option) (*Query, error) {
 	if query == "" {
 		return nil, fmt.Errorf("empty query string")
 	}
 
+	var options compileOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	var precedence parser.Precedence
+	switch options.precedence {
+	case PrecedenceLeftToRight:
+		precedence = parser.PrecedenceLeftToRight
+	case PrecedenceStandard:
+		precedence = parser.PrecedenceStandard
+	default:
+		return nil, fmt.Errorf("unknown precedence mode: %d", options.precedence)
+	}
+
 	// Create lexer and tokenize the input
 	l := lexer.New(query)
 
 	// Create parser and parse the tokens into an AST
-	p := parser.New(l)
-	expr, err := p.Parse()
+	p := parser.NewWithOptions(l, parser.Options{
+		Precedence: precedence,
+		Variables:  options.variables,
+	})
+	expr, err := p.ParseQuery()
 	if err != nil {
-		return nil, fmt.Errorf("failed to compile query: %w", err)
+		return nil, newCompileError(query, l, err)
 	}
 
 	return &Query{
-		expr: expr,
+		query:     query,
+		expr:      expr,
+		functions: functions,
+		now:       options.now,
 	}, nil
 }
 
+// CompileError is returned when a query cannot be lexed or parsed. It reports
+// the position of the offending token in the query:
+//
+//	var compileErr *fpath.CompileError
+//	if errors.As(err, &compileErr) {
+//		fmt.Println(compileErr.Snippet())
+//	}
+type CompileError struct {
+	// Query is the query that failed to compile.
+	Query string
+	// Line and Column are the 1-based position of the offending token.
+	// Columns count runes, with a tab counting as one.
+	Line   int
+	Column int
+	// Token is the source text of the offending token, or empty if the
+	// query ended unexpectedly.
+	Token string
+	// Err is the underlying lexer or parser error.
+	Err error
+}
+
+// newCompileError locates the error reported while parsing query with l.
+func newCompileError(query string, l *lexer.Lexer, err error) *CompileError {
+	source := []rune(query)
+
+	// Most errors are caused by the last token read
+	tok := l.LastToken()
+	start, end := tok.Offset, tok.End
+
+	var lexErr *lexer.Error
+	var tokenErr *parser.TokenError
+	if errors.As(err, &lexErr) {
+		// Lexing errors point at the rune that could not be read
+		start, end = lexErr.Offset, lexErr.Offset+1
+	} else if errors.As(err, &tokenErr) {
+		start, end = tokenErr.Token.Offset, tokenErr.Token.End
+	}
+	start = min(start, len(source))
+	end = min(max(end, start), len(source))
+
+	line, column := locate(source, start)
+	return &CompileError{
+		Query:  query,
+		Line:   line,
+		Column: column,
+		Token:  string(source[start:end]),
+		Err:    err,
+	}
+}
+
+// locate returns the 1-based line and column of the rune at offset in source.
+func locate(source []rune, offset int) (line, column int) {
+	line, column = 1, 1
+	for _, r := range source[:offset] {
+		if r == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+
+	return line, column
+}
+
+func (e *CompileError) Error() string {
+	return fmt.Sprintf("failed to compile query at line %d, column %d: %v", e.Line, e.Column, e.Err)
+}
+
+func (e *CompileError) Unwrap() error {
+	return e.Err
+}
+
+// Snippet returns the line of the query containing the error, followed by a
+// line with carets under the offending token:
+//
+//	$.items[0,
+//	         ^
+func (e *CompileError) Snippet() string {
+	return snippet(e.Query, e.Line, e.Column, e.Token)
+}
+
+// snippet returns the given line of query followed by a line with carets
+// under text, which starts at column. Only the first line of text is marked.
+func snippet(query string, lineNumber, column int, text string) string {
+	lines := strings.Split(query, "\n")
+	if lineNumber < 1 || lineNumber > len(lines) {
+		return ""
+	}
+	line := []rune(strings.TrimSuffix(lines[lineNumber-1], "\r"))
+
+	// Keep tabs in the indentation so the carets line up with the text
+	var caret strings.Builder
+	for i := 0; i < column-1 && i < len(line); i++ {
+		if line[i] == '\t' {
+			caret.WriteRune('\t')
+		} else {
+			caret.WriteRune(' ')
+		}
+	}
+	text, _, _ = strings.Cut(text, "\n")
+	caret.WriteString(strings.Repeat("^", max(utf8.RuneCountInString(text), 1)))
+
+	return string(line) + "\n" + caret.String()
+}
+
+// EvalError is returned when a query fails while it is evaluated. It reports
+// the sub-expression that failed and the types of the values involved:
+//
+//	var evalErr *fpath.EvalError
+//	if errors.As(err, &evalErr) {
+//		fmt.Println(evalErr.Snippet())
+//	}
+//
+// Use errors.Is with the Err variables of this package, such as
+// ErrKeyNotFound, to check why evaluation failed.
+type EvalError struct {
+	// Query is the query that failed to evaluate.
+	Query string
+	// Line and Column are the 1-based position of the start of the failing
+	// sub-expression. Columns count runes, with a tab counting as one.
+	Line   int
+	Column int
+	// Expression is the source text of the failing sub-expression.
+	Expression string
+	// Types names the types of the values involved, such as "number" or
+	// "list", or is empty if the failure does not depend on them.
+	Types []string
+	// Err is the underlying evaluation error.
+	Err error
+}
+
+// newEvalError locates the sub-expression of query that caused err.
+func newEvalError(query string, err *runtime.Error, wrapped error) *EvalError {
+	source := []rune(query)
+
+	pos := err.Expr.Position()
+	start := min(pos.Start, len(source))
+	end := min(max(pos.End, start), len(source))
+
+	line, column := locate(source, start)
+	return &EvalError{
+		Query:      query,
+		Line:       line,
+		Column:     column,
+		Expression: string(source[start:end]),
+		Types:      err.Types,
+		Err:        wrapped,
+	}
+}
+
+func (e *EvalError) Error() string {
+	return fmt.Sprintf("failed to evaluate query at line %d, column %d: %v", e.Line, e.Column, e.Err)
+}
+
+func (e *EvalError) Unwrap() error {
+	return e.Err
+}
+
+// Snippet returns the line of the query containing the failing
+// sub-expression, followed by a line with carets under it:
+//
+//	$.price * $.quantity
+//	^^^^^^^^^^^^^^^^^^^^
+func (e *EvalError) Snippet() string {
+	return snippet(e.Query, e.Line, e.Column, e.Expression)
+}
+
 // Evaluate executes the compiled query against the provided input data and returns the result.
 //
 // The input data can be any Go value that the fpath expression can operate on:
-// - Maps (map[string]any or struct types)
-// - Slices and arrays
-// - Primitive types (string, number, boolean)
+// - Maps (map[string]any or any other map with string or numeric keys)
+// - Structs and pointers to structs, exposed as maps keyed by field name
+// - Slices and arrays of any element type
+// - Primitive types (string, number, boolean), including named types
 // - Nested combinations of the above
 //
+// Nil values, including nil pointers, are treated as null. A null result is
+// returned as a Go nil.
+//
+// Struct field names honour `fpath:"..."` tags, then `json:"..."` tags,
+// including the "-" and "omitempty" options. Fields of embedded structs are
+// promoted into the parent.
+//
 // The $ symbol in the expression refers to the input data.
 //
 // Example:
@@ -85,7 +409,8 @@ func expressionToGoValue(expr parser.Expr) (any, error) {
 
 	// For simple types, return as-is
 	switch expr.Type() {
-	case parser.ExprType_Number, parser.ExprType_String, parser.ExprType_Boolean:
+	case parser.ExprType_Number, parser.ExprType_String, parser.ExprType_Boolean, parser.ExprType_Null,
+		parser.ExprType_Time, parser.ExprType_Duration:
 		return decoded, nil
 
 	case parser.ExprType_List:
@@ -143,13 +468,34 @@ func expressionToGoValue(expr parser.Expr) (any, error) {
 }
 
 func (q *Query) Evaluate(input any) (any, error) {
+	return q.EvaluateWithVars(input, nil)
+}
+
+// EvaluateWithVars is like Evaluate, but also makes the given named variables
+// available to the query. A variable is referenced by its bare name, and its
+// value can be any Go value accepted as input data.
+//
+// Example:
+//
+//	query, _ := Compile("$.total > threshold")
+//	result, err := query.EvaluateWithVars(order, map[string]any{"threshold": 100})
+func (q *Query) EvaluateWithVars(input any, vars map[string]any) (any, error) {
 	if q == nil {
 		return nil, fmt.Errorf("query is nil")
 	}
 
 	// Evaluate the compiled expression against the input data
-	resultExpr, err := runtime.Eval(q.expr, input)
+	resultExpr, err := runtime.EvalWithContext(q.expr, &runtime.Context{
+		Input:     input,
+		Functions: q.functions,
+		Variables: vars,
+		Now:       q.now,
+	})
 	if err != nil {
+		var runtimeErr *runtime.Error
+		if errors.As(err, &runtimeErr) {
+			return nil, newEvalError(q.query, runtimeErr, err)
+		}
 		return nil, fmt.Errorf("failed to evaluate query: %w", err)
 	}
 
@@ -161,3 +507,290 @@ func (q *Query) Evaluate(input any) (any, error) {
 
 	return result, nil
 }
+
+// Type identifies the type of a value passed to a registered Function.
+type Type int
+
+const (
+	// TypeAny accepts a value of any type.
+	TypeAny Type = iota
+	TypeNumber
+	TypeString
+	TypeBoolean
+	TypeList
+	TypeMap
+	TypeNull
+	TypeTime
+	TypeDuration
+)
+
+// String returns the name of the type as used in error messages.
+func (t Type) String() string {
+	switch t {
+	case TypeAny:
+		return "any"
+	case TypeNumber:
+		return "number"
+	case TypeString:
+		return "string"
+	case TypeBoolean:
+		return "boolean"
+	case TypeList:
+		return "list"
+	case TypeMap:
+		return "map"
+	case TypeNull:
+		return "null"
+	case TypeTime:
+		return "time"
+	case TypeDuration:
+		return "duration"
+	default:
+		return fmt.Sprintf("Type(%d)", int(t))
+	}
+}
+
+// exprTypes maps each Type to the expression type it accepts.
+var exprTypes = map[Type]int{
+	TypeNumber:   parser.ExprType_Number,
+	TypeString:   parser.ExprType_String,
+	TypeBoolean:  parser.ExprType_Boolean,
+	TypeList:     parser.ExprType_List,
+	TypeMap:      parser.ExprType_Map,
+	TypeNull:     parser.ExprType_Null,
+	TypeTime:     parser.ExprType_Time,
+	TypeDuration: parser.ExprType_Duration,
+}
+
+// accepts reports whether an evaluated expression matches the type.
+func (t Type) accepts(expr parser.Expr) bool {
+	if t == TypeAny {
+		return true
+	}
+
+	return exprTypes[t] == expr.Type()
+}
+
+// Function describes a Go function that can be called from queries compiled
+// by an Environment. Exactly one of Call and Lazy must be set.
+type Function struct {
+	// Args declares the type of each parameter. Its length is the number of
+	// arguments the function accepts.
+	Args []Type
+	// Variadic allows any number of extra arguments of the last declared
+	// type.
+	Variadic bool
+	// Call implements a function whose arguments are evaluated before the
+	// call. Arguments are checked against Args and passed as Go values:
+	// float64, string, bool, time.Time, time.Duration, []any,
+	// map[string]any or nil.
+	Call func(args []any) (any, error)
+	// Lazy implements a function whose arguments are passed unevaluated, the
+	// way filter() receives its condition. Each argument is checked against
+	// Args when it is evaluated.
+	Lazy func(args []Arg) (any, error)
+}
+
+// Arg is an unevaluated argument passed to a lazy Function.
+type Arg struct {
+	expr     parser.Expr
+	ctx      *runtime.Context
+	argType  Type
+	position int
+	name     string
+}
+
+// Evaluate evaluates the argument and returns its Go value.
+func (a Arg) Evaluate() (any, error) {
+	return a.evaluate(a.ctx)
+}
+
+// EvaluateWith evaluates the argument with `_` bound to element, the way
+// filter() evaluates its condition for each list element. If the argument is
+// a lambda, such as `o => o.total > 10`, element is bound to its parameter
+// instead.
+func (a Arg) EvaluateWith(element any) (any, error) {
+	elementExpr, err := runtime.ConvertValue(element)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert element: %w", err)
+	}
+
+	return a.check(a.ctx.Apply(a.expr, elementExpr))
+}
+
+// evaluate evaluates the argument against the context and checks its type.
+func (a Arg) evaluate(ctx *runtime.Context) (any, error) {
+	return a.check(runtime.EvalWithContext(a.expr, ctx))
+}
+
+// check checks the result of evaluating the argument against its type.
+func (a Arg) check(result parser.Expr, err error) (any, error) {
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate %s() argument %d: %w", a.name, a.position+1, err)
+	}
+
+	if !a.argType.accepts(result) {
+		return nil, fmt.Errorf("%w: %s() argument %d must be a %s, got %s", runtime.ErrInvalidArgumentType, a.name, a.position+1, a.argType, runtime.TypeName(result))
+	}
+
+	return expressionToGoValue(result)
+}
+
+// Environment holds host-registered functions and compiles queries that can
+// call them. Functions registered on one Environment are not visible to
+// queries compiled by another Environment or by Compile.
+//
+// Registering functions is not safe for concurrent use, but queries compiled
+// by an Environment are not affected by later registrations.
+type Environment struct {
+	functions map[string]Function
+}
+
+// NewEnvironment returns an Environment with no registered functions.
+func NewEnvironment() *Environment {
+	return &Environment{
+		functions: make(map[string]Function),
+	}
+}
+
+// RegisterFunction makes fn callable by name from queries compiled by the
+// Environment. Built-in functions cannot be replaced.
+//
+// Example:
+//
+//	env := NewEnvironment()
+//	err := env.RegisterFunction("double", Function{
+//		Args: []Type{TypeNumber},
+//		Call: func(args []any) (any, error) {
+//			return args[0].(float64) * 2, nil
+//		},
+//	})
+func (e *Environment) RegisterFunction(name string, fn Function) error {
+	if !isFunctionName(name) {
+		return fmt.Errorf("invalid function name: %q", name)
+	}
+
+	if runtime.IsBuiltinFunction(name) {
+		return fmt.Errorf("cannot register function %q: built-in function", name)
+	}
+
+	if _, exists := e.functions[name]; exists {
+		return fmt.Errorf("cannot register function %q: already registered", name)
+	}
+
+	if (fn.Call == nil) == (fn.Lazy == nil) {
+		return fmt.Errorf("cannot register function %q: exactly one of Call and Lazy must be set", name)
+	}
+
+	if fn.Variadic && len(fn.Args) == 0 {
+		return fmt.Errorf("cannot register function %q: variadic function must declare at least one argument", name)
+	}
+
+	fn.Args = append([]Type(nil), fn.Args...)
+	e.functions[name] = fn
+	return nil
+}
+
+// Compile is like CompileWithOptions, but the returned Query can call the
+// functions registered on the Environment.
+func (e *Environment) Compile(query string, opts ...Option) (*Query, error) {
+	functions := make(map[string]runtime.FunctionFunc, len(e.functions))
+	for name, fn := range e.functions {
+		functions[name] = fn.bind(name)
+	}
+
+	return compile(query, functions, opts)
+}
+
+// isFunctionName reports whether name lexes as a single label, so that it can
+// be called from a query.
+func isFunctionName(name string) bool {
+	l := lexer.New(name)
+	tok, err := l.GetToken()
+	if err != nil || tok.Type != lexer.TokenType_Label || tok.Value != name {
+		return false
+	}
+
+	_, err = l.GetToken()
+	return errors.Is(err, io.EOF)
+}
+
+// bind adapts the Function to the runtime's function signature, checking the
+// argument count and types and converting values between Go and expressions.
+func (fn Function) bind(name string) runtime.FunctionFunc {
+	return func(args []parser.Expr, ctx *runtime.Context) (parser.Expr, error) {
+		if err := fn.checkArgumentCount(name, len(args)); err != nil {
+			return nil, err
+		}
+
+		var result any
+		var err error
+		if fn.Lazy != nil {
+			lazyArgs := make([]Arg, len(args))
+			for i, arg := range args {
+				lazyArgs[i] = Arg{
+					expr:     arg,
+					ctx:      ctx,
+					argType:  fn.argType(i),
+					position: i,
+					name:     name,
+				}
+			}
+
+			result, err = fn.Lazy(lazyArgs)
+		} else {
+			values := make([]any, len(args))
+			for i, arg := range args {
+				values[i], err = Arg{
+					expr:     arg,
+					ctx:      ctx,
+					argType:  fn.argType(i),
+					position: i,
+					name:     name,
+				}.Evaluate()
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			result, err = fn.Call(values)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s() failed: %w", name, err)
+		}
+
+		resultExpr, err := runtime.ConvertValue(result)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert %s() result: %w", name, err)
+		}
+
+		return resultExpr, nil
+	}
+}
+
+// checkArgumentCount returns an error if the function cannot be called with
+// count arguments.
+func (fn Function) checkArgumentCount(name string, count int) error {
+	if fn.Variadic {
+		if count < len(fn.Args) {
+			return fmt.Errorf("%w: %s() expects at least %d arguments, got %d", runtime.ErrInvalidArgumentCount, name, len(fn.Args), count)
+		}
+		return nil
+	}
+
+	if count != len(fn.Args) {
+		return fmt.Errorf("%w: %s() expects exactly %d arguments, got %d", runtime.ErrInvalidArgumentCount, name, len(fn.Args), count)
+	}
+
+	return nil
+}
+
+// argType returns the declared type of the argument at index i.
+func (fn Function) argType(i int) Type {
+	if i >= len(fn.Args) {
+		// Extra variadic arguments take the type of the last declared argument
+		return fn.Args[len(fn.Args)-1]
+	}
+
+	return fn.Args[i]
+}
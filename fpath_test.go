@@ -1,9 +1,13 @@
 package fpath_test
 
 import (
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/fletcharoo/fpath"
+	"github.com/fletcharoo/fpath/internal/parser"
 	"github.com/fletcharoo/fpath/internal/runtime"
 	"github.com/stretchr/testify/require"
 )
@@ -29,12 +33,19 @@ func TestCompile(t *testing.T) {
 	})
 
 	t.Run("invalid syntax", func(t *testing.T) {
-		query, err := fpath.Compile("2 + + 3")
+		query, err := fpath.Compile("2 + * 3")
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "failed to compile query")
 		require.Nil(t, query)
 	})
 
+	t.Run("invalid escape sequence", func(t *testing.T) {
+		query, err := fpath.Compile(`"tab\q"`)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid escape sequence: \\q")
+		require.Nil(t, query)
+	})
+
 	t.Run("undefined token", func(t *testing.T) {
 		query, err := fpath.Compile("2 @ 3")
 		require.Error(t, err)
@@ -43,6 +54,263 @@ func TestCompile(t *testing.T) {
 	})
 }
 
+func TestCompileError(t *testing.T) {
+	testCases := map[string]struct {
+		query   string
+		line    int
+		column  int
+		token   string
+		snippet string
+	}{
+		"unexpected token": {
+			query:   "[1, 2 3]",
+			line:    1,
+			column:  7,
+			token:   "3",
+			snippet: "[1, 2 3]\n      ^",
+		},
+		"multi-character token": {
+			query:   "let x 10; x",
+			line:    1,
+			column:  7,
+			token:   "10",
+			snippet: "let x 10; x\n      ^^",
+		},
+		"error on a later line": {
+			query:   "filter($.items,\n  _.price > 10 &&\n  _.sku == \"A\" \"B\")",
+			line:    3,
+			column:  16,
+			token:   `"B"`,
+			snippet: "  _.sku == \"A\" \"B\")\n               ^^^",
+		},
+		"tab indentation": {
+			query:   "len(\n\t$.items 1)",
+			line:    2,
+			column:  10,
+			token:   "1",
+			snippet: "\t$.items 1)\n\t        ^",
+		},
+		"invalid rune": {
+			query:   "$.a @ 1",
+			line:    1,
+			column:  5,
+			token:   "@",
+			snippet: "$.a @ 1\n    ^",
+		},
+		"invalid escape": {
+			query:   "$.name == \"a\\qb\"",
+			line:    1,
+			column:  13,
+			token:   "\\",
+			snippet: "$.name == \"a\\qb\"\n            ^",
+		},
+		"invalid regular expression": {
+			query:   "$.sku =~ \"^[A-Z\" &&\n  matches($.name, \"(\")",
+			line:    1,
+			column:  10,
+			token:   `"^[A-Z"`,
+			snippet: "$.sku =~ \"^[A-Z\" &&\n         ^^^^^^^",
+		},
+		"invalid regular expression argument": {
+			query:   "$.sku =~ \"^[A-Z]\" &&\n  matches($.name, \"(\")",
+			line:    2,
+			column:  19,
+			token:   `"("`,
+			snippet: "  matches($.name, \"(\")\n                  ^^^",
+		},
+		"unexpected end of query": {
+			query:   "len($.items",
+			line:    1,
+			column:  12,
+			token:   "",
+			snippet: "len($.items\n           ^",
+		},
+		"assignment after expression": {
+			query:   "$.a = 1",
+			line:    1,
+			column:  5,
+			token:   "=",
+			snippet: "$.a = 1\n    ^",
+		},
+		"two expressions": {
+			query:   "1 2",
+			line:    1,
+			column:  3,
+			token:   "2",
+			snippet: "1 2\n  ^",
+		},
+		"statement after let body": {
+			query:   "let x = 1; x; 5",
+			line:    1,
+			column:  13,
+			token:   ";",
+			snippet: "let x = 1; x; 5\n            ^",
+		},
+		"unmatched closing parenthesis": {
+			query:   "(1 + 2))",
+			line:    1,
+			column:  8,
+			token:   ")",
+			snippet: "(1 + 2))\n       ^",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			query, err := fpath.Compile(tc.query)
+			require.Nil(t, query)
+
+			var compileErr *fpath.CompileError
+			require.True(t, errors.As(err, &compileErr), "Expected *fpath.CompileError, got %T", err)
+			require.Equal(t, tc.query, compileErr.Query)
+			require.Equal(t, tc.line, compileErr.Line, "line")
+			require.Equal(t, tc.column, compileErr.Column, "column")
+			require.Equal(t, tc.token, compileErr.Token, "token")
+			require.Equal(t, tc.snippet, compileErr.Snippet())
+			require.Contains(t, err.Error(), fmt.Sprintf("failed to compile query at line %d, column %d", tc.line, tc.column))
+		})
+	}
+
+	t.Run("wraps the parser error", func(t *testing.T) {
+		_, err := fpath.Compile("$.a[0,")
+		require.ErrorIs(t, err, parser.ErrExpectedToken)
+	})
+
+	t.Run("trailing tokens with standard precedence", func(t *testing.T) {
+		_, err := fpath.CompileWithOptions("1 + 2 3", fpath.WithPrecedence(fpath.PrecedenceStandard))
+
+		var compileErr *fpath.CompileError
+		require.True(t, errors.As(err, &compileErr))
+		require.ErrorIs(t, err, parser.ErrExpectedToken)
+		require.Equal(t, "3", compileErr.Token)
+	})
+
+	t.Run("wraps the regular expression error", func(t *testing.T) {
+		_, err := fpath.Compile(`replace_re($.name, "a**", "")`)
+		require.ErrorIs(t, err, fpath.ErrInvalidRegex)
+	})
+
+	t.Run("wrapped by CompileWithOptions", func(t *testing.T) {
+		_, err := fpath.CompileWithOptions("$.total > limit", fpath.WithVariables("threshold"))
+
+		var compileErr *fpath.CompileError
+		require.True(t, errors.As(err, &compileErr))
+		require.ErrorIs(t, err, parser.ErrUndefinedVariable)
+		require.Equal(t, 11, compileErr.Column)
+		require.Equal(t, "limit", compileErr.Token)
+	})
+}
+
+func TestCompileWithOptions(t *testing.T) {
+	testCases := map[string]struct {
+		query       string
+		leftToRight any
+		standard    any
+	}{
+		"multiplication after addition": {
+			query:       "2 + 3 * 4",
+			leftToRight: 20.0,
+			standard:    14.0,
+		},
+		"exponent after multiplication": {
+			query:       "2 * 3 ^ 2",
+			leftToRight: 36.0,
+			standard:    18.0,
+		},
+		"left-associative exponent": {
+			query:       "2 ^ 3 ^ 2",
+			leftToRight: 64.0,
+			standard:    64.0,
+		},
+		"modulo after subtraction": {
+			query:       "10 - 7 % 4",
+			leftToRight: 3.0,
+			standard:    7.0,
+		},
+		"unary minus": {
+			query:       "-2 + 3",
+			leftToRight: 1.0,
+			standard:    1.0,
+		},
+		"unary minus with exponent": {
+			query:       "-2 ^ 2",
+			leftToRight: 4.0,
+			standard:    -4.0,
+		},
+		"arithmetic in comparison": {
+			query:       "1 + 2 * 3 == 7",
+			leftToRight: false,
+			standard:    true,
+		},
+		"and before or": {
+			query:       "true || false && false",
+			leftToRight: true,
+			standard:    true,
+		},
+		"comparison in ternary": {
+			query:       `2 + 2 * 2 > 7 ? "big" : "small"`,
+			leftToRight: "big",
+			standard:    "small",
+		},
+		"parentheses override precedence": {
+			query:       "(2 + 3) * 4",
+			leftToRight: 20.0,
+			standard:    20.0,
+		},
+		"precedence inside function arguments": {
+			query:       "max(1 + 2 * 3, 0)",
+			leftToRight: 9.0,
+			standard:    7.0,
+		},
+		"precedence with indexing": {
+			query:       "[1, 2, 3][0] + [1, 2, 3][1] * [1, 2, 3][2]",
+			leftToRight: 9.0,
+			standard:    7.0,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			query, err := fpath.CompileWithOptions(tc.query, fpath.WithPrecedence(fpath.PrecedenceLeftToRight))
+			require.NoError(t, err)
+
+			result, err := query.Evaluate(nil)
+			require.NoError(t, err)
+			require.Equal(t, tc.leftToRight, result)
+
+			query, err = fpath.CompileWithOptions(tc.query, fpath.WithPrecedence(fpath.PrecedenceStandard))
+			require.NoError(t, err)
+
+			result, err = query.Evaluate(nil)
+			require.NoError(t, err)
+			require.Equal(t, tc.standard, result)
+		})
+	}
+
+	t.Run("default is left-to-right", func(t *testing.T) {
+		query, err := fpath.CompileWithOptions("2 + 3 * 4")
+		require.NoError(t, err)
+
+		result, err := query.Evaluate(nil)
+		require.NoError(t, err)
+		require.Equal(t, 20.0, result)
+	})
+
+	t.Run("unknown precedence mode", func(t *testing.T) {
+		query, err := fpath.CompileWithOptions("2 + 3", fpath.WithPrecedence(fpath.Precedence(99)))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unknown precedence mode")
+		require.Nil(t, query)
+	})
+
+	t.Run("invalid syntax", func(t *testing.T) {
+		query, err := fpath.CompileWithOptions("2 + * 3", fpath.WithPrecedence(fpath.PrecedenceStandard))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to compile query")
+		require.Nil(t, query)
+	})
+}
+
 func TestQueryEvaluate(t *testing.T) {
 	t.Run("simple arithmetic", func(t *testing.T) {
 		query, err := fpath.Compile("2 + 3")
@@ -63,6 +331,15 @@ func TestQueryEvaluate(t *testing.T) {
 		require.Equal(t, 20.0, result)
 	})
 
+	t.Run("result without an exact float64", func(t *testing.T) {
+		query, err := fpath.Compile("avg([1, 2, 2])")
+		require.NoError(t, err)
+
+		result, err := query.Evaluate(nil)
+		require.NoError(t, err)
+		require.Equal(t, 5.0/3, result)
+	})
+
 	t.Run("input data reference", func(t *testing.T) {
 		query, err := fpath.Compile("$")
 		require.NoError(t, err)
@@ -140,6 +417,120 @@ func TestQueryEvaluate(t *testing.T) {
 	})
 }
 
+func TestQueryEvaluateWithVars(t *testing.T) {
+	t.Run("variables and input", func(t *testing.T) {
+		query, err := fpath.Compile(`($.total > threshold) && contains(user.roles, "admin")`)
+		require.NoError(t, err)
+
+		result, err := query.EvaluateWithVars(
+			map[string]any{"total": 150},
+			map[string]any{
+				"threshold": 100,
+				"user":      map[string]any{"roles": []any{"admin"}},
+			},
+		)
+		require.NoError(t, err)
+		require.Equal(t, true, result)
+	})
+
+	t.Run("same query with different variables", func(t *testing.T) {
+		query, err := fpath.Compile("len(filter($, _ > threshold))")
+		require.NoError(t, err)
+
+		input := []any{1, 5, 10}
+		for threshold, expected := range map[int]float64{0: 3, 4: 2, 10: 0} {
+			result, err := query.EvaluateWithVars(input, map[string]any{"threshold": threshold})
+			require.NoError(t, err)
+			require.Equal(t, expected, result)
+		}
+	})
+
+	t.Run("struct variable", func(t *testing.T) {
+		type user struct {
+			Name string `json:"name"`
+		}
+
+		query, err := fpath.Compile(`"Hello " + user.name`)
+		require.NoError(t, err)
+
+		result, err := query.EvaluateWithVars(nil, map[string]any{"user": &user{Name: "Alice"}})
+		require.NoError(t, err)
+		require.Equal(t, "Hello Alice", result)
+	})
+
+	t.Run("missing variable", func(t *testing.T) {
+		query, err := fpath.Compile("threshold + 1")
+		require.NoError(t, err)
+
+		result, err := query.Evaluate(nil)
+		require.ErrorIs(t, err, runtime.ErrUndefinedVariable)
+		require.Nil(t, result)
+	})
+
+	t.Run("declared variables", func(t *testing.T) {
+		query, err := fpath.CompileWithOptions("$.total > threshold", fpath.WithVariables("threshold", "user"))
+		require.NoError(t, err)
+
+		result, err := query.EvaluateWithVars(map[string]any{"total": 5}, map[string]any{"threshold": 10})
+		require.NoError(t, err)
+		require.Equal(t, false, result)
+	})
+
+	t.Run("undeclared variable", func(t *testing.T) {
+		query, err := fpath.CompileWithOptions("$.total > limit", fpath.WithVariables("threshold"))
+		require.Error(t, err)
+		require.ErrorIs(t, err, parser.ErrUndefinedVariable)
+		require.Contains(t, err.Error(), "limit")
+		require.Nil(t, query)
+
+		query, err = fpath.CompileWithOptions("limit", fpath.WithVariables())
+		require.ErrorIs(t, err, parser.ErrUndefinedVariable)
+		require.Nil(t, query)
+	})
+
+	t.Run("reduce accumulator hides declared variable", func(t *testing.T) {
+		query, err := fpath.CompileWithOptions("reduce($, 0, acc + _)", fpath.WithVariables("acc"))
+		require.ErrorIs(t, err, fpath.ErrShadowedVariable)
+		require.Nil(t, query)
+
+		var compileErr *fpath.CompileError
+		require.True(t, errors.As(err, &compileErr))
+	})
+
+	t.Run("reduce accumulator hides let binding", func(t *testing.T) {
+		query, err := fpath.Compile("let acc = 10; reduce($, 0, acc + _)")
+		require.ErrorIs(t, err, fpath.ErrShadowedVariable)
+		require.Nil(t, query)
+	})
+
+	t.Run("reduce accumulator hides evaluation variable", func(t *testing.T) {
+		query, err := fpath.Compile("reduce($, 0, acc + _)")
+		require.NoError(t, err)
+
+		result, err := query.EvaluateWithVars([]any{1, 2}, map[string]any{"acc": 10})
+		require.ErrorIs(t, err, fpath.ErrShadowedVariable)
+		require.Nil(t, result)
+	})
+
+	t.Run("reduce lambda alongside variable named acc", func(t *testing.T) {
+		query, err := fpath.CompileWithOptions("reduce($, acc, (total, x) => total + x)", fpath.WithVariables("acc"))
+		require.NoError(t, err)
+
+		result, err := query.EvaluateWithVars([]any{1, 2}, map[string]any{"acc": 10})
+		require.NoError(t, err)
+		require.Equal(t, 13.0, result)
+	})
+
+	t.Run("nested reduce accumulators", func(t *testing.T) {
+		query, err := fpath.Compile("reduce($, 0, acc + reduce(_, 0, acc + _))")
+		require.NoError(t, err)
+
+		result, err := query.Evaluate([]any{[]any{1, 2}, []any{3}})
+		require.NoError(t, err)
+		require.Equal(t, 6.0, result)
+	})
+}
+
 func TestQueryEvaluateComplex(t *testing.T) {
 	t.Run("nested data access", func(t *testing.T) {
 		query, err := fpath.Compile(`$["user"]["profile"]["name"]`)
@@ -158,6 +549,119 @@ func TestQueryEvaluateComplex(t *testing.T) {
 		require.Equal(t, "John Doe", result)
 	})
 
+	t.Run("dot-path access", func(t *testing.T) {
+		query, err := fpath.Compile("$.items[0].price * 2")
+		require.NoError(t, err)
+
+		input := map[string]any{
+			"items": []any{
+				map[string]any{"price": 5},
+			},
+		}
+		result, err := query.Evaluate(input)
+		require.NoError(t, err)
+		require.Equal(t, 10.0, result)
+	})
+
+	t.Run("dot-path struct access", func(t *testing.T) {
+		type user struct {
+			Name string
+		}
+
+		query, err := fpath.Compile("$.Name")
+		require.NoError(t, err)
+
+		result, err := query.Evaluate(user{Name: "Alice"})
+		require.NoError(t, err)
+		require.Equal(t, "Alice", result)
+	})
+
+	t.Run("struct input with tags", func(t *testing.T) {
+		type lineItem struct {
+			SKU   string  `json:"sku"`
+			Price float64 `json:"price"`
+		}
+
+		type order struct {
+			ID    string     `fpath:"id"`
+			Items []lineItem `json:"items"`
+			Notes *string    `json:"notes,omitempty"`
+		}
+
+		query, err := fpath.Compile(`len(filter($.items, _.price > 5))`)
+		require.NoError(t, err)
+
+		input := &order{
+			ID: "A1",
+			Items: []lineItem{
+				{SKU: "X", Price: 10},
+				{SKU: "Y", Price: 2},
+			},
+		}
+		result, err := query.Evaluate(input)
+		require.NoError(t, err)
+		require.Equal(t, 1.0, result)
+
+		query, err = fpath.Compile("$")
+		require.NoError(t, err)
+
+		result, err = query.Evaluate(input)
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{
+			"id": "A1",
+			"items": []any{
+				map[string]any{"sku": "X", "price": 10.0},
+				map[string]any{"sku": "Y", "price": 2.0},
+			},
+		}, result)
+	})
+
+	t.Run("null values", func(t *testing.T) {
+		query, err := fpath.Compile("$.nickname")
+		require.NoError(t, err)
+
+		result, err := query.Evaluate(map[string]any{"nickname": nil})
+		require.NoError(t, err)
+		require.Nil(t, result)
+
+		query, err = fpath.Compile(`$.nickname == null ? "none" : $.nickname`)
+		require.NoError(t, err)
+
+		result, err = query.Evaluate(map[string]any{"nickname": nil})
+		require.NoError(t, err)
+		require.Equal(t, "none", result)
+
+		query, err = fpath.Compile("$")
+		require.NoError(t, err)
+
+		result, err = query.Evaluate([]any{1, nil})
+		require.NoError(t, err)
+		require.Equal(t, []any{1.0, nil}, result)
+	})
+
+	t.Run("let bindings", func(t *testing.T) {
+		query, err := fpath.CompileWithOptions(
+			`let big = filter($.orders, _.total > threshold); len(big) > 0 ? big[0].id : "none"`,
+			fpath.WithVariables("threshold"),
+			fpath.WithPrecedence(fpath.PrecedenceStandard),
+		)
+		require.NoError(t, err)
+
+		input := map[string]any{
+			"orders": []any{
+				map[string]any{"id": "a", "total": 50},
+				map[string]any{"id": "b", "total": 150},
+			},
+		}
+		result, err := query.EvaluateWithVars(input, map[string]any{"threshold": 100})
+		require.NoError(t, err)
+		require.Equal(t, "b", result)
+
+		result, err = query.EvaluateWithVars(input, map[string]any{"threshold": 200})
+		require.NoError(t, err)
+		require.Equal(t, "none", result)
+	})
+
 	t.Run("list slicing", func(t *testing.T) {
 		query, err := fpath.Compile("$[1:3]")
 		require.NoError(t, err)
@@ -200,6 +704,311 @@ func TestQueryEvaluateComplex(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, []any{3.0, 4.0, 5.0}, result)
 	})
+
+	t.Run("higher-order functions", func(t *testing.T) {
+		input := map[string]any{
+			"orders": []any{
+				map[string]any{"id": "a", "total": 50, "paid": true},
+				map[string]any{"id": "b", "total": 150, "paid": false},
+				map[string]any{"id": "c", "total": 200, "paid": true},
+			},
+		}
+
+		testCases := map[string]struct {
+			query    string
+			expected any
+		}{
+			"map":       {query: "map($.orders, _.id)", expected: []any{"a", "b", "c"}},
+			"reduce":    {query: "reduce($.orders, 0, acc + _.total)", expected: 400.0},
+			"any":       {query: "any($.orders, !_.paid)", expected: true},
+			"all":       {query: "all($.orders, _.total > 100)", expected: false},
+			"find":      {query: "find($.orders, _.total > 100).id", expected: "b"},
+			"findIndex": {query: "findIndex($.orders, _.id == \"c\")", expected: 2.0},
+			"count":     {query: "count($.orders, _.paid)", expected: 2.0},
+		}
+
+		for name, tc := range testCases {
+			t.Run(name, func(t *testing.T) {
+				query, err := fpath.Compile(tc.query)
+				require.NoError(t, err)
+
+				result, err := query.Evaluate(input)
+				require.NoError(t, err)
+				require.Equal(t, tc.expected, result)
+			})
+		}
+	})
+
+	t.Run("lambdas", func(t *testing.T) {
+		query, err := fpath.Compile(`map(filter($.orders, o => any(o.items, i => i.sku == "X")), o => o.id)`)
+		require.NoError(t, err)
+
+		input := map[string]any{
+			"orders": []any{
+				map[string]any{"id": "a", "items": []any{map[string]any{"sku": "X"}}},
+				map[string]any{"id": "b", "items": []any{map[string]any{"sku": "Y"}}},
+				map[string]any{"id": "c", "items": []any{map[string]any{"sku": "Y"}, map[string]any{"sku": "X"}}},
+			},
+		}
+		result, err := query.Evaluate(input)
+		require.NoError(t, err)
+		require.Equal(t, []any{"a", "c"}, result)
+	})
+
+	t.Run("string escapes", func(t *testing.T) {
+		query, err := fpath.Compile(`"say \"hi\"\n" + 'it\'s \u2713'`)
+		require.NoError(t, err)
+
+		result, err := query.Evaluate(nil)
+		require.NoError(t, err)
+		require.Equal(t, "say \"hi\"\nit's ✓", result)
+	})
+
+	t.Run("single-quoted strings", func(t *testing.T) {
+		query, err := fpath.Compile("filter($, _.name == 'Alice')[0].name")
+		require.NoError(t, err)
+
+		input := []any{map[string]any{"name": "Bob"}, map[string]any{"name": "Alice"}}
+		result, err := query.Evaluate(input)
+		require.NoError(t, err)
+		require.Equal(t, "Alice", result)
+	})
+
+	t.Run("pipe chain", func(t *testing.T) {
+		query, err := fpath.Compile("$.items | map(_.price) | filter(_ > 10) | sort()")
+		require.NoError(t, err)
+
+		input := map[string]any{
+			"items": []any{
+				map[string]any{"price": 30},
+				map[string]any{"price": 5},
+				map[string]any{"price": 12},
+			},
+		}
+		result, err := query.Evaluate(input)
+		require.NoError(t, err)
+		require.Equal(t, []any{12.0, 30.0}, result)
+	})
+
+	t.Run("optional chaining and coalescing", func(t *testing.T) {
+		query, err := fpath.Compile(`$.customer?.address?.city ?? "unknown"`)
+		require.NoError(t, err)
+
+		result, err := query.Evaluate(map[string]any{"customer": map[string]any{"name": "Alice"}})
+		require.NoError(t, err)
+		require.Equal(t, "unknown", result)
+
+		result, err = query.Evaluate(map[string]any{"customer": map[string]any{"address": map[string]any{"city": "Paris"}}})
+		require.NoError(t, err)
+		require.Equal(t, "Paris", result)
+	})
+
+	t.Run("coalesce struct field", func(t *testing.T) {
+		type order struct {
+			Discount *float64
+		}
+
+		query, err := fpath.Compile("$.Discount ?? 0")
+		require.NoError(t, err)
+
+		result, err := query.Evaluate(order{})
+		require.NoError(t, err)
+		require.Equal(t, 0.0, result)
+	})
+
+	t.Run("membership", func(t *testing.T) {
+		query, err := fpath.Compile(`$.status in ["open", "pending"] && $.owner not in $.blocked`)
+		require.NoError(t, err)
+
+		result, err := query.Evaluate(map[string]any{
+			"status":  "pending",
+			"owner":   "bob",
+			"blocked": []any{"eve"},
+		})
+		require.NoError(t, err)
+		require.Equal(t, true, result)
+	})
+
+	t.Run("membership of a field named not", func(t *testing.T) {
+		query, err := fpath.Compile(`$.not in ["x"] && $.not not in ["y"]`)
+		require.NoError(t, err)
+
+		result, err := query.Evaluate(map[string]any{"not": "x"})
+		require.NoError(t, err)
+		require.Equal(t, true, result)
+	})
+
+	t.Run("regular expressions", func(t *testing.T) {
+		query, err := fpath.Compile(`$.email =~ "@example\\.com$" ? capture($.email, "^(?P<user>[^@]+)@").user : null`)
+		require.NoError(t, err)
+
+		result, err := query.Evaluate(map[string]any{"email": "bob@example.com"})
+		require.NoError(t, err)
+		require.Equal(t, "bob", result)
+
+		result, err = query.Evaluate(map[string]any{"email": "bob@example.org"})
+		require.NoError(t, err)
+		require.Nil(t, result)
+	})
+
+	t.Run("invalid dynamic regular expression", func(t *testing.T) {
+		query, err := fpath.Compile(`matches($.name, $.pattern)`)
+		require.NoError(t, err)
+
+		_, err = query.Evaluate(map[string]any{"name": "bob", "pattern": "("})
+		var evalErr *fpath.EvalError
+		require.ErrorAs(t, err, &evalErr)
+		require.ErrorIs(t, err, fpath.ErrInvalidRegex)
+	})
+
+	t.Run("string functions", func(t *testing.T) {
+		query, err := fpath.Compile(`join(map(split($.name, " "), w => upper(w[0:1])), "") + "-" + pad_left($.id, 4, "0")`)
+		require.NoError(t, err)
+
+		result, err := query.Evaluate(map[string]any{"name": "ada lovelace", "id": "42"})
+		require.NoError(t, err)
+		require.Equal(t, "AL-0042", result)
+	})
+
+	t.Run("map functions", func(t *testing.T) {
+		query, err := fpath.Compile(`join(keys(deep_merge(omit($.defaults, ["secret"]), $.overrides)), ",")`)
+		require.NoError(t, err)
+
+		result, err := query.Evaluate(map[string]any{
+			"defaults":  map[string]any{"timeout": 30, "retries": 3, "secret": "x"},
+			"overrides": map[string]any{"verbose": true, "retries": 5},
+		})
+		require.NoError(t, err)
+		require.Equal(t, "retries,timeout,verbose", result)
+	})
+
+	t.Run("statistics functions", func(t *testing.T) {
+		query, err := fpath.Compile(`{"total": sum($.prices), "average": avg($.prices), "highest": max($.prices)}`)
+		require.NoError(t, err)
+
+		result, err := query.Evaluate(map[string]any{"prices": []any{19.75, 5.25, 11}})
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{"total": 36.0, "average": 12.0, "highest": 19.75}, result)
+	})
+
+	t.Run("list functions", func(t *testing.T) {
+		query, err := fpath.Compile(`map(chunk(unique($.a + $.b), 2), c => join(c, "-"))`)
+		require.NoError(t, err)
+
+		result, err := query.Evaluate(map[string]any{
+			"a": []any{"x", "y", "x"},
+			"b": []any{"z", "y"},
+		})
+		require.NoError(t, err)
+		require.Equal(t, []any{"x-y", "z"}, result)
+	})
+
+	t.Run("sort by multiple keys", func(t *testing.T) {
+		query, err := fpath.Compile(`map(sort_by($.users, [_.team, _.name]), _.name)`)
+		require.NoError(t, err)
+
+		result, err := query.Evaluate(map[string]any{
+			"users": []any{
+				map[string]any{"name": "eve", "team": "red"},
+				map[string]any{"name": "bob", "team": "blue"},
+				map[string]any{"name": "ann", "team": "red"},
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, []any{"bob", "ann", "eve"}, result)
+	})
+
+	t.Run("grouping functions", func(t *testing.T) {
+		query, err := fpath.Compile(`count_by($.orders, _.status)`)
+		require.NoError(t, err)
+
+		result, err := query.Evaluate(map[string]any{
+			"orders": []any{
+				map[string]any{"status": "open"},
+				map[string]any{"status": "shipped"},
+				map[string]any{"status": "open"},
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{"open": 2.0, "shipped": 1.0}, result)
+	})
+
+	t.Run("grouping by non-string keys", func(t *testing.T) {
+		query, err := fpath.Compile(`[group_by([1, 2, 3], _ % 2), group_by([1, 2, 3], _ > 1), count_by([1, null], _)]`)
+		require.NoError(t, err)
+
+		result, err := query.Evaluate(nil)
+		require.NoError(t, err)
+		require.Equal(t, []any{
+			map[string]any{"1": []any{1.0, 3.0}, "0": []any{2.0}},
+			map[string]any{"false": []any{1.0}, "true": []any{2.0, 3.0}},
+			map[string]any{"1": 1.0, "null": 1.0},
+		}, result)
+	})
+
+	t.Run("duplicate key in index_by", func(t *testing.T) {
+		query, err := fpath.Compile(`index_by($.users, _.id)`)
+		require.NoError(t, err)
+
+		_, err = query.Evaluate(map[string]any{
+			"users": []any{map[string]any{"id": "u1"}, map[string]any{"id": "u1"}},
+		})
+		require.ErrorIs(t, err, fpath.ErrDuplicateKey)
+	})
+
+	t.Run("time functions with injected clock", func(t *testing.T) {
+		now := time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC)
+		query, err := fpath.CompileWithOptions(
+			`map(filter($.users, _.created > now() - duration(30, "days")), _.name)`,
+			fpath.WithClock(func() time.Time { return now }),
+		)
+		require.NoError(t, err)
+
+		result, err := query.Evaluate(map[string]any{
+			"users": []any{
+				map[string]any{"name": "ada", "created": now.Add(-10 * 24 * time.Hour)},
+				map[string]any{"name": "bob", "created": now.Add(-45 * 24 * time.Hour)},
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, []any{"ada"}, result)
+	})
+
+	t.Run("time and duration results", func(t *testing.T) {
+		query, err := fpath.Compile(`[parse_time($.start), parse_time($.end) - parse_time($.start)]`)
+		require.NoError(t, err)
+
+		result, err := query.Evaluate(map[string]any{
+			"start": "2024-03-15T10:00:00Z",
+			"end":   "2024-03-15T12:30:00Z",
+		})
+		require.NoError(t, err)
+		require.Equal(t, []any{time.Date(2024, time.March, 15, 10, 0, 0, 0, time.UTC), 150 * time.Minute}, result)
+	})
+
+	t.Run("invalid time string", func(t *testing.T) {
+		query, err := fpath.Compile(`parse_time($.start)`)
+		require.NoError(t, err)
+
+		_, err = query.Evaluate(map[string]any{"start": "next tuesday"})
+		require.ErrorIs(t, err, fpath.ErrInvalidTime)
+	})
+
+	t.Run("filter predicate reads root input", func(t *testing.T) {
+		query, err := fpath.Compile("filter($.items, _.price > $.minPrice)")
+		require.NoError(t, err)
+
+		input := map[string]any{
+			"minPrice": 5,
+			"items": []any{
+				map[string]any{"sku": "A", "price": 3},
+				map[string]any{"sku": "B", "price": 8},
+			},
+		}
+		result, err := query.Evaluate(input)
+		require.NoError(t, err)
+		require.Equal(t, []any{map[string]any{"sku": "B", "price": 8.0}}, result)
+	})
 }
 
 func TestQueryEvaluateErrorHandling(t *testing.T) {
@@ -246,6 +1055,121 @@ func TestQueryEvaluateErrorHandling(t *testing.T) {
 	})
 }
 
+func TestEvalError(t *testing.T) {
+	testCases := map[string]struct {
+		query      string
+		input      any
+		sentinel   error
+		line       int
+		column     int
+		expression string
+		types      []string
+		snippet    string
+	}{
+		"incompatible types": {
+			query:      `$.price * "2"`,
+			input:      map[string]any{"price": 10},
+			sentinel:   fpath.ErrIncompatibleTypes,
+			line:       1,
+			column:     1,
+			expression: `$.price * "2"`,
+			types:      []string{"number", "string"},
+			snippet:    "$.price * \"2\"\n^^^^^^^^^^^^^",
+		},
+		"index into non-list on second line": {
+			query:      "$.total > 0 &&\n\t$.total[0] > 1",
+			input:      map[string]any{"total": 5},
+			sentinel:   fpath.ErrInvalidIndex,
+			line:       2,
+			column:     2,
+			expression: "$.total[0]",
+			types:      []string{"number"},
+			snippet:    "\t$.total[0] > 1\n\t^^^^^^^^^^",
+		},
+		"missing key": {
+			query:      `$.user.name == "Alice"`,
+			input:      map[string]any{"user": map[string]any{"id": 1}},
+			sentinel:   fpath.ErrKeyNotFound,
+			line:       1,
+			column:     1,
+			expression: "$.user.name",
+			snippet:    "$.user.name == \"Alice\"\n^^^^^^^^^^^",
+		},
+		"division by zero inside predicate": {
+			query:      "filter($.items, 1 / _ > 0)",
+			input:      map[string]any{"items": []any{1, 0}},
+			sentinel:   fpath.ErrDivisionByZero,
+			line:       1,
+			column:     17,
+			expression: "1 / _",
+			snippet:    "filter($.items, 1 / _ > 0)\n                ^^^^^",
+		},
+		"undefined variable": {
+			query:      "$.total > threshold",
+			input:      map[string]any{"total": 5},
+			sentinel:   fpath.ErrUndefinedVariable,
+			line:       1,
+			column:     11,
+			expression: "threshold",
+			snippet:    "$.total > threshold\n          ^^^^^^^^^",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			query, err := fpath.Compile(tc.query)
+			require.NoError(t, err)
+
+			_, err = query.Evaluate(tc.input)
+			require.ErrorIs(t, err, tc.sentinel)
+
+			var evalErr *fpath.EvalError
+			require.ErrorAs(t, err, &evalErr)
+			require.Equal(t, tc.query, evalErr.Query)
+			require.Equal(t, tc.line, evalErr.Line)
+			require.Equal(t, tc.column, evalErr.Column)
+			require.Equal(t, tc.expression, evalErr.Expression)
+			require.Equal(t, tc.types, evalErr.Types)
+			require.Equal(t, tc.snippet, evalErr.Snippet())
+			require.Contains(t, err.Error(), fmt.Sprintf("failed to evaluate query at line %d, column %d", tc.line, tc.column))
+		})
+	}
+
+	t.Run("sentinels match the runtime errors", func(t *testing.T) {
+		query, err := fpath.Compile("5 / 0")
+		require.NoError(t, err)
+
+		_, err = query.Evaluate(nil)
+		require.ErrorIs(t, err, fpath.ErrDivisionByZero)
+		require.ErrorIs(t, err, runtime.ErrDivisionByZero)
+	})
+
+	t.Run("undefined variable at compile time", func(t *testing.T) {
+		_, err := fpath.CompileWithOptions("$.total > threshold", fpath.WithVariables("limit"))
+		require.ErrorIs(t, err, fpath.ErrUndefinedVariable)
+	})
+
+	t.Run("registered function argument type", func(t *testing.T) {
+		env := fpath.NewEnvironment()
+		require.NoError(t, env.RegisterFunction("fail", fpath.Function{
+			Args: []fpath.Type{fpath.TypeNumber},
+			Call: func(args []any) (any, error) {
+				return nil, errors.New("boom")
+			},
+		}))
+
+		query, err := env.Compile("1 + fail($.n)")
+		require.NoError(t, err)
+
+		_, err = query.Evaluate(map[string]any{"n": "x"})
+		var evalErr *fpath.EvalError
+		require.ErrorAs(t, err, &evalErr)
+		require.ErrorIs(t, err, fpath.ErrInvalidArgumentType)
+		require.Equal(t, "fail($.n)", evalErr.Expression)
+		require.Contains(t, err.Error(), "must be a number, got string")
+	})
+}
+
 func TestQueryReuse(t *testing.T) {
 	// Test that a compiled query can be reused multiple times efficiently
 	query, err := fpath.Compile(`$["value"] * 2`)
@@ -324,3 +1248,264 @@ func TestExamples(t *testing.T) {
 		require.Equal(t, []any{"a", "b", "c"}, result)
 	})
 }
+
+func TestEnvironment(t *testing.T) {
+	newEnv := func(t *testing.T) *fpath.Environment {
+		env := fpath.NewEnvironment()
+
+		err := env.RegisterFunction("currency", fpath.Function{
+			Args: []fpath.Type{fpath.TypeNumber, fpath.TypeString},
+			Call: func(args []any) (any, error) {
+				return fmt.Sprintf("%.2f %s", args[0].(float64), args[1].(string)), nil
+			},
+		})
+		require.NoError(t, err)
+
+		err = env.RegisterFunction("total", fpath.Function{
+			Args:     []fpath.Type{fpath.TypeNumber},
+			Variadic: true,
+			Call: func(args []any) (any, error) {
+				var sum float64
+				for _, arg := range args {
+					sum += arg.(float64)
+				}
+				return sum, nil
+			},
+		})
+		require.NoError(t, err)
+
+		err = env.RegisterFunction("count_if", fpath.Function{
+			Args: []fpath.Type{fpath.TypeList, fpath.TypeBoolean},
+			Lazy: func(args []fpath.Arg) (any, error) {
+				list, err := args[0].Evaluate()
+				if err != nil {
+					return nil, err
+				}
+
+				count := 0
+				for _, element := range list.([]any) {
+					matched, err := args[1].EvaluateWith(element)
+					if err != nil {
+						return nil, err
+					}
+					if matched.(bool) {
+						count++
+					}
+				}
+				return count, nil
+			},
+		})
+		require.NoError(t, err)
+
+		err = env.RegisterFunction("first_or", fpath.Function{
+			Args: []fpath.Type{fpath.TypeAny, fpath.TypeAny},
+			Lazy: func(args []fpath.Arg) (any, error) {
+				value, err := args[0].Evaluate()
+				if err == nil {
+					return value, nil
+				}
+				return args[1].Evaluate()
+			},
+		})
+		require.NoError(t, err)
+
+		err = env.RegisterFunction("tier", fpath.Function{
+			Args: []fpath.Type{fpath.TypeMap},
+			Call: func(args []any) (any, error) {
+				customer := args[0].(map[string]any)
+				if customer["spend"].(float64) > 1000 {
+					return map[string]any{"name": "gold", "discount": 0.1}, nil
+				}
+				return map[string]any{"name": "standard", "discount": 0}, nil
+			},
+		})
+		require.NoError(t, err)
+
+		return env
+	}
+
+	testCases := map[string]struct {
+		query    string
+		input    any
+		expected any
+	}{
+		"eager function": {
+			query:    "currency(12.5, \"EUR\")",
+			expected: "12.50 EUR",
+		},
+		"eager function with input data": {
+			query:    "tier($.customer).name",
+			input:    map[string]any{"customer": map[string]any{"spend": 2000}},
+			expected: "gold",
+		},
+		"variadic function": {
+			query:    "total(1, 2, 3)",
+			expected: 6.0,
+		},
+		"variadic function with minimum arguments": {
+			query:    "total(4)",
+			expected: 4.0,
+		},
+		"lazy function with element binding": {
+			query:    "count_if($, _ > 2)",
+			input:    []any{1, 2, 3, 4},
+			expected: 2.0,
+		},
+		"lazy function with lambda": {
+			query:    "count_if($, n => n > 2)",
+			input:    []any{1, 2, 3, 4},
+			expected: 2.0,
+		},
+		"lambda inside lazy function reads outer lambda parameter": {
+			query:    "len(filter($, xs => count_if(xs, x => x > xs[0]) > 0))",
+			input:    []any{[]any{1, 2}, []any{3, 1}},
+			expected: 1.0,
+		},
+		"lazy function skips failing argument": {
+			query:    "first_or($.missing, \"default\")",
+			input:    map[string]any{},
+			expected: "default",
+		},
+		"custom function inside built-in": {
+			query:    "len(filter($, count_if(_, _ > 0) > 1))",
+			input:    []any{[]any{1, 2}, []any{1, -1}},
+			expected: 1.0,
+		},
+		"built-in function inside custom function": {
+			query:    "total(len($), abs(-1))",
+			input:    []any{1, 2},
+			expected: 3.0,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			query, err := newEnv(t).Compile(tc.query)
+			require.NoError(t, err)
+
+			result, err := query.Evaluate(tc.input)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, result)
+		})
+	}
+
+	t.Run("compile options", func(t *testing.T) {
+		query, err := newEnv(t).Compile("total(1, 2) + 3 * 2", fpath.WithPrecedence(fpath.PrecedenceStandard))
+		require.NoError(t, err)
+
+		result, err := query.Evaluate(nil)
+		require.NoError(t, err)
+		require.Equal(t, 9.0, result)
+	})
+
+	t.Run("functions are scoped to the environment", func(t *testing.T) {
+		query, err := fpath.Compile("total(1, 2)")
+		require.NoError(t, err)
+
+		_, err = query.Evaluate(nil)
+		require.ErrorIs(t, err, runtime.ErrUndefinedFunction)
+
+		query, err = fpath.NewEnvironment().Compile("total(1, 2)")
+		require.NoError(t, err)
+
+		_, err = query.Evaluate(nil)
+		require.ErrorIs(t, err, runtime.ErrUndefinedFunction)
+	})
+
+	t.Run("compiled queries are not affected by later registrations", func(t *testing.T) {
+		env := fpath.NewEnvironment()
+		query, err := env.Compile("later()")
+		require.NoError(t, err)
+
+		err = env.RegisterFunction("later", fpath.Function{
+			Call: func(args []any) (any, error) { return 1, nil },
+		})
+		require.NoError(t, err)
+
+		_, err = query.Evaluate(nil)
+		require.ErrorIs(t, err, runtime.ErrUndefinedFunction)
+	})
+
+	t.Run("argument errors", func(t *testing.T) {
+		errorCases := map[string]struct {
+			query     string
+			expectErr error
+		}{
+			"too few arguments":          {query: "currency(1)", expectErr: runtime.ErrInvalidArgumentCount},
+			"too many arguments":         {query: `currency(1, "EUR", 2)`, expectErr: runtime.ErrInvalidArgumentCount},
+			"too few variadic arguments": {query: "total()", expectErr: runtime.ErrInvalidArgumentCount},
+			"wrong argument type":        {query: `currency("1", "EUR")`, expectErr: runtime.ErrInvalidArgumentType},
+			"wrong variadic type":        {query: `total(1, "2")`, expectErr: runtime.ErrInvalidArgumentType},
+			"wrong lazy argument type":   {query: "count_if([1, 2], _ + 1)", expectErr: runtime.ErrInvalidArgumentType},
+			"argument evaluation error":  {query: `currency(1 / 0, "EUR")`, expectErr: runtime.ErrDivisionByZero},
+		}
+
+		for name, tc := range errorCases {
+			t.Run(name, func(t *testing.T) {
+				query, err := newEnv(t).Compile(tc.query)
+				require.NoError(t, err)
+
+				result, err := query.Evaluate(nil)
+				require.ErrorIs(t, err, tc.expectErr)
+				require.Nil(t, result)
+			})
+		}
+	})
+
+	t.Run("function error", func(t *testing.T) {
+		errUnknownCurrency := errors.New("unknown currency")
+
+		env := fpath.NewEnvironment()
+		err := env.RegisterFunction("rate", fpath.Function{
+			Args: []fpath.Type{fpath.TypeString},
+			Call: func(args []any) (any, error) {
+				return nil, errUnknownCurrency
+			},
+		})
+		require.NoError(t, err)
+
+		query, err := env.Compile(`rate("XYZ")`)
+		require.NoError(t, err)
+
+		_, err = query.Evaluate(nil)
+		require.ErrorIs(t, err, errUnknownCurrency)
+		require.Contains(t, err.Error(), "rate() failed")
+	})
+
+	t.Run("registration errors", func(t *testing.T) {
+		call := func(args []any) (any, error) { return nil, nil }
+		lazy := func(args []fpath.Arg) (any, error) { return nil, nil }
+
+		errorCases := map[string]struct {
+			name     string
+			function fpath.Function
+			contains string
+		}{
+			"empty name":          {name: "", function: fpath.Function{Call: call}, contains: "invalid function name"},
+			"invalid name":        {name: "my-func", function: fpath.Function{Call: call}, contains: "invalid function name"},
+			"keyword name":        {name: "null", function: fpath.Function{Call: call}, contains: "invalid function name"},
+			"built-in function":   {name: "len", function: fpath.Function{Call: call}, contains: "built-in function"},
+			"no implementation":   {name: "noop", function: fpath.Function{}, contains: "exactly one of Call and Lazy"},
+			"two implementations": {name: "noop", function: fpath.Function{Call: call, Lazy: lazy}, contains: "exactly one of Call and Lazy"},
+			"variadic without arguments": {
+				name:     "noop",
+				function: fpath.Function{Variadic: true, Call: call},
+				contains: "at least one argument",
+			},
+		}
+
+		for name, tc := range errorCases {
+			t.Run(name, func(t *testing.T) {
+				err := fpath.NewEnvironment().RegisterFunction(tc.name, tc.function)
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.contains)
+			})
+		}
+
+		env := fpath.NewEnvironment()
+		require.NoError(t, env.RegisterFunction("once", fpath.Function{Call: call}))
+		err := env.RegisterFunction("once", fpath.Function{Call: call})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "already registered")
+	})
+}
@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
 	"unicode"
+	"unicode/utf16"
 )
 
 const (
@@ -38,6 +40,20 @@ const (
 	TokenType_Comma
 	TokenType_Caret
 	TokenType_IntegerDivision
+	TokenType_Dot
+	TokenType_Null
+	TokenType_Not
+	TokenType_Let
+	TokenType_Assign
+	TokenType_Semicolon
+	TokenType_Arrow
+	TokenType_Pipe
+	TokenType_OptionalDot
+	TokenType_OptionalBracket
+	TokenType_Coalesce
+	TokenType_In
+	TokenType_NotIn
+	TokenType_Match
 )
 
 var (
@@ -72,14 +88,43 @@ var (
 		TokenType_Comma:              "Comma",
 		TokenType_Caret:              "Caret",
 		TokenType_IntegerDivision:    "IntegerDivision",
+		TokenType_Dot:                "Dot",
+		TokenType_Null:               "Null",
+		TokenType_Not:                "Not",
+		TokenType_Let:                "Let",
+		TokenType_Assign:             "Assign",
+		TokenType_Semicolon:          "Semicolon",
+		TokenType_Arrow:              "Arrow",
+		TokenType_Pipe:               "Pipe",
+		TokenType_OptionalDot:        "OptionalDot",
+		TokenType_OptionalBracket:    "OptionalBracket",
+		TokenType_Coalesce:           "Coalesce",
+		TokenType_In:                 "In",
+		TokenType_NotIn:              "NotIn",
+		TokenType_Match:              "Match",
 	}
 )
 
 var (
 	errUnexpectedEOF = errors.New("unexpected EOF")
 	errInvalidRune   = errors.New("invalid rune")
+	errInvalidEscape = errors.New("invalid escape sequence")
 )
 
+// escapeRunes maps the character following a backslash in a string literal
+// to the rune it represents. \u is handled separately.
+var escapeRunes = map[rune]rune{
+	'"':  '"',
+	'\'': '\'',
+	'\\': '\\',
+	'/':  '/',
+	'b':  '\b',
+	'f':  '\f',
+	'n':  '\n',
+	'r':  '\r',
+	't':  '\t',
+}
+
 // isLabelRune returns whether the provided rune is a valid label rune.
 // Valid label runes are letters, numbers, and underscores.
 func isLabelRune(r rune) bool {
@@ -89,6 +134,25 @@ func isLabelRune(r rune) bool {
 type Token struct {
 	Type  int
 	Value string
+	// Offset and End are the rune offsets of the start of the token in the
+	// input and of the rune following it.
+	Offset int
+	End    int
+}
+
+// Error is an error encountered while reading a token, with the rune offset
+// in the input where the problem was found.
+type Error struct {
+	Offset int
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
 }
 
 // String makes Token implement the Stringer interface.
@@ -113,9 +177,12 @@ func New(input string) *Lexer {
 // Lexer adds the functionality to get and peek tokens from a
 // string using a buffer.
 type Lexer struct {
-	input []rune
-	index int
-	buf   *Token
+	input  []rune
+	index  int
+	buf    *Token
+	bufErr error
+	last   Token
+	end    int
 }
 
 // getRune returns the rune at the current index of the input and increments the
@@ -144,16 +211,82 @@ func (l *Lexer) peekRune() (r rune, err error) {
 	return l.input[l.index], nil
 }
 
+// skipWord advances past word if it is the next label in the input, separated
+// from the current index by whitespace, and reports whether it did.
+func (l *Lexer) skipWord(word string) bool {
+	index := l.index
+	for index < len(l.input) && unicode.IsSpace(l.input[index]) {
+		index++
+	}
+	if index == l.index {
+		return false
+	}
+
+	end := index + len([]rune(word))
+	if end > len(l.input) || string(l.input[index:end]) != word {
+		return false
+	}
+	if end < len(l.input) && isLabelRune(l.input[end]) {
+		return false
+	}
+
+	l.index = end
+	return true
+}
+
 // getToken returns the next token in the input string.
 // If there are no more tokens to process in the string, getToken returns an
 // io.EOF error.
 func (l *Lexer) GetToken() (tok Token, err error) {
 	if l.buf != nil {
-		tok = *l.buf
-		l.buf = nil
-		return tok, nil
+		tok, err = *l.buf, l.bufErr
+		l.buf, l.bufErr = nil, nil
+	} else {
+		tok, err = l.readToken()
+	}
+
+	// The empty token read at the end of the input is not counted
+	if err == nil && tok.End > tok.Offset {
+		l.end = tok.End
+	}
+	return tok, err
+}
+
+// readToken reads the next token from the input, recording its offsets.
+func (l *Lexer) readToken() (tok Token, err error) {
+	// Skip whitespace so the token offset is that of its first rune
+	for l.index < len(l.input) && unicode.IsSpace(l.input[l.index]) {
+		l.index++
+	}
+
+	start := l.index
+	tok, err = l.scanToken()
+	tok.Offset, tok.End = start, l.index
+
+	var lexErr *Error
+	if err != nil && err != io.EOF && !errors.As(err, &lexErr) {
+		err = &Error{Offset: start, Err: err}
 	}
 
+	l.last = tok
+	return tok, err
+}
+
+// Offset returns the offset of the rune following the last token read by
+// GetToken, not counting tokens that have only been peeked.
+func (l *Lexer) Offset() int {
+	return l.end
+}
+
+// LastToken returns the token most recently read by GetToken or PeekToken.
+// At the end of the input it is an Undefined token whose offset is the length
+// of the input.
+func (l *Lexer) LastToken() Token {
+	return l.last
+}
+
+// scanToken reads the token starting at the current index of the input.
+func (l *Lexer) scanToken() (tok Token, err error) {
 	var r rune
 
 	for {
@@ -177,9 +310,9 @@ func (l *Lexer) GetToken() (tok Token, err error) {
 		}
 
 		switch r {
-		case '"':
+		case '"', '\'':
 			l.index++
-			return l.getTokenStringLiteral()
+			return l.getTokenStringLiteral(r)
 		case '$':
 			l.index++
 			return Token{
@@ -229,9 +362,28 @@ func (l *Lexer) GetToken() (tok Token, err error) {
 					Type: TokenType_Equals,
 				}, nil
 			}
-			// Single = is not supported, return error
-			err = fmt.Errorf("%w: %s", errInvalidRune, string(r))
-			return
+			// Check if this is the => lambda arrow
+			if peekErr == nil && nextRune == '>' {
+				l.index++
+				return Token{
+					Type: TokenType_Arrow,
+				}, nil
+			}
+			// Check if this is the =~ match operator
+			if peekErr == nil && nextRune == '~' {
+				l.index++
+				return Token{
+					Type: TokenType_Match,
+				}, nil
+			}
+			return Token{
+				Type: TokenType_Assign,
+			}, nil
+		case ';':
+			l.index++
+			return Token{
+				Type: TokenType_Semicolon,
+			}, nil
 		case '!':
 			l.index++
 			// Check if this is the start of != operator
@@ -242,9 +394,9 @@ func (l *Lexer) GetToken() (tok Token, err error) {
 					Type: TokenType_NotEquals,
 				}, nil
 			}
-			// Single ! is not supported, return error
-			err = fmt.Errorf("%w: %s", errInvalidRune, string(r))
-			return
+			return Token{
+				Type: TokenType_Not,
+			}, nil
 		case '&':
 			l.index++
 			// Check if this is the start of && operator
@@ -268,11 +420,32 @@ func (l *Lexer) GetToken() (tok Token, err error) {
 					Type: TokenType_Or,
 				}, nil
 			}
-			// Single | is not supported, return error
-			err = fmt.Errorf("%w: %s", errInvalidRune, string(r))
-			return
+			return Token{
+				Type: TokenType_Pipe,
+			}, nil
 		case '?':
 			l.index++
+			// Check for the ?? operator and the ?. and ?[ optional accessors,
+			// which must not be separated from the ? by whitespace
+			nextRune, peekErr := l.peekRune()
+			if peekErr == nil && nextRune == '?' {
+				l.index++
+				return Token{
+					Type: TokenType_Coalesce,
+				}, nil
+			}
+			if peekErr == nil && nextRune == '.' {
+				l.index++
+				return Token{
+					Type: TokenType_OptionalDot,
+				}, nil
+			}
+			if peekErr == nil && nextRune == '[' {
+				l.index++
+				return Token{
+					Type: TokenType_OptionalBracket,
+				}, nil
+			}
 			return Token{
 				Type: TokenType_Question,
 			}, nil
@@ -347,6 +520,11 @@ func (l *Lexer) GetToken() (tok Token, err error) {
 			return Token{
 				Type: TokenType_Caret,
 			}, nil
+		case '.':
+			l.index++
+			return Token{
+				Type: TokenType_Dot,
+			}, nil
 		default:
 			err = fmt.Errorf("%w: %s", errInvalidRune, string(r))
 			return
@@ -364,8 +542,13 @@ func (l *Lexer) PeekToken() (tok Token, err error) {
 		return tok, nil
 	}
 
-	tok, err = l.GetToken()
+	tok, err = l.readToken()
 	l.buf = &tok
+	// Reading a peeked EOF returns an Undefined token, but lexing errors
+	// are reported again
+	if err != io.EOF {
+		l.bufErr = err
+	}
 	return tok, err
 }
 
@@ -433,6 +616,27 @@ func (l *Lexer) getTokenLabel() (tok Token, err error) {
 		tok.Type = TokenType_Boolean
 	}
 
+	// Check if this is a null literal
+	if tok.Value == "null" {
+		tok.Type = TokenType_Null
+	}
+
+	// Check if this is the let keyword
+	if tok.Value == "let" {
+		tok.Type = TokenType_Let
+	}
+
+	// Check if this is the in operator, or not followed by in. A lone not is
+	// still a label, as is a not following a dot, which names a field
+	if tok.Value == "in" {
+		tok.Type = TokenType_In
+	}
+	afterDot := l.last.Type == TokenType_Dot || l.last.Type == TokenType_OptionalDot
+	if tok.Value == "not" && !afterDot && l.skipWord("in") {
+		tok.Type = TokenType_NotIn
+		tok.Value = "not in"
+	}
+
 	if err == io.EOF {
 		return tok, nil
 	}
@@ -441,10 +645,11 @@ func (l *Lexer) getTokenLabel() (tok Token, err error) {
 }
 
 // getTokenStringLiteral returns the current string literal token in the input
-// string.
+// string, ending at the next unescaped quote rune. Backslash escapes follow
+// JSON, with the addition of \' for single-quoted strings.
 // If the token reaches the end of the string, getTokenStringLiteral returns an
 // UnexpectedEOF error.
-func (l *Lexer) getTokenStringLiteral() (tok Token, err error) {
+func (l *Lexer) getTokenStringLiteral(quote rune) (tok Token, err error) {
 	tok.Type = TokenType_StringLiteral
 	var r rune
 
@@ -460,16 +665,104 @@ func (l *Lexer) getTokenStringLiteral() (tok Token, err error) {
 			return
 		}
 
-		if r == '"' {
+		if r == quote {
 			break
 		}
 
+		if r == '\\' {
+			r, err = l.getEscape()
+			if err != nil {
+				return
+			}
+		}
+
 		tok.Value += string(r)
 	}
 
 	return tok, nil
 }
 
+// getEscape returns the rune represented by the escape sequence following a
+// backslash, which has already been consumed. Errors report the position of
+// the backslash.
+func (l *Lexer) getEscape() (r rune, err error) {
+	start := l.index - 1
+
+	r, err = l.getRune()
+	if err == io.EOF {
+		err = errUnexpectedEOF
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	if r != 'u' {
+		escaped, ok := escapeRunes[r]
+		if !ok {
+			err = &Error{Offset: start, Err: fmt.Errorf("%w: \\%s", errInvalidEscape, string(r))}
+			return
+		}
+		return escaped, nil
+	}
+
+	r, err = l.getUnicodeEscape(start)
+	if err != nil {
+		return
+	}
+
+	// A high surrogate must be followed by an escaped low surrogate, as in
+	// JSON's encoding of runes outside the Basic Multilingual Plane
+	if !utf16.IsSurrogate(r) {
+		return r, nil
+	}
+
+	if r >= 0xDC00 || l.index+1 >= len(l.input) || l.input[l.index] != '\\' || l.input[l.index+1] != 'u' {
+		err = &Error{Offset: start, Err: fmt.Errorf("%w: unpaired surrogate \\u%04X", errInvalidEscape, r)}
+		return
+	}
+	l.index += 2
+
+	low, err := l.getUnicodeEscape(start)
+	if err != nil {
+		return
+	}
+
+	r = utf16.DecodeRune(r, low)
+	if r == unicode.ReplacementChar {
+		err = &Error{Offset: start, Err: fmt.Errorf("%w: unpaired surrogate", errInvalidEscape)}
+		return
+	}
+
+	return r, nil
+}
+
+// getUnicodeEscape returns the rune encoded by the four hex digits of a \u
+// escape. start is the position of the escape's backslash.
+func (l *Lexer) getUnicodeEscape(start int) (r rune, err error) {
+	for i := 0; i < 4; i++ {
+		digit, getErr := l.getRune()
+		if getErr == io.EOF {
+			err = errUnexpectedEOF
+			return
+		}
+		if getErr != nil {
+			err = getErr
+			return
+		}
+
+		value, parseErr := strconv.ParseUint(string(digit), 16, 8)
+		if parseErr != nil {
+			err = &Error{Offset: start, Err: fmt.Errorf("%w: \\u requires 4 hex digits", errInvalidEscape)}
+			return
+		}
+
+		r = r<<4 | rune(value)
+	}
+
+	return r, nil
+}
+
 // getTokenBoolean returns the current boolean literal token in the input
 // string. It recognizes "true" and "false".
 func (l *Lexer) getTokenBoolean() (tok Token, err error) {
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"testing"
 )
 
@@ -17,7 +18,7 @@ func _tokensMatch(expected, actual Token) (err error) {
 		err = fmt.Errorf("Unexpected value\nExpected: %s\nActual: %s", expected.Value, actual.Value)
 	}
 
-	return nil
+	return
 }
 
 func Test_isLabelRune(t *testing.T) {
@@ -174,6 +175,14 @@ func Test_Lexer_getToken(t *testing.T) {
 				{Type: TokenType_StringLiteral, Value: "hello world"},
 			},
 		},
+		"Single-quoted StringLiteral": {
+			input: `'say "hi"' + "it's"`,
+			expectedTokens: []Token{
+				{Type: TokenType_StringLiteral, Value: `say "hi"`},
+				{Type: TokenType_Plus},
+				{Type: TokenType_StringLiteral, Value: `it's`},
+			},
+		},
 		"Plus": {
 			input: "+",
 			expectedTokens: []Token{
@@ -192,6 +201,137 @@ func Test_Lexer_getToken(t *testing.T) {
 				{Type: TokenType_NotEquals},
 			},
 		},
+		"Not": {
+			input: "!true",
+			expectedTokens: []Token{
+				{Type: TokenType_Not},
+				{Type: TokenType_Boolean, Value: "true"},
+			},
+		},
+		"Let binding": {
+			input: "let x = 1; x",
+			expectedTokens: []Token{
+				{Type: TokenType_Let, Value: "let"},
+				{Type: TokenType_Label, Value: "x"},
+				{Type: TokenType_Assign},
+				{Type: TokenType_Number, Value: "1"},
+				{Type: TokenType_Semicolon},
+				{Type: TokenType_Label, Value: "x"},
+			},
+		},
+		"Assign is not Equals": {
+			input: "= ==",
+			expectedTokens: []Token{
+				{Type: TokenType_Assign},
+				{Type: TokenType_Equals},
+			},
+		},
+		"Lambda arrow": {
+			input: "o => o.total >= 1",
+			expectedTokens: []Token{
+				{Type: TokenType_Label, Value: "o"},
+				{Type: TokenType_Arrow},
+				{Type: TokenType_Label, Value: "o"},
+				{Type: TokenType_Dot},
+				{Type: TokenType_Label, Value: "total"},
+				{Type: TokenType_GreaterThanOrEqual},
+				{Type: TokenType_Number, Value: "1"},
+			},
+		},
+		"Pipe is not Or": {
+			input: "$ | len() || true",
+			expectedTokens: []Token{
+				{Type: TokenType_Dollar},
+				{Type: TokenType_Pipe},
+				{Type: TokenType_Label, Value: "len"},
+				{Type: TokenType_LeftParan},
+				{Type: TokenType_RightParan},
+				{Type: TokenType_Or},
+				{Type: TokenType_Boolean, Value: "true"},
+			},
+		},
+		"Optional chaining and coalescing": {
+			input: `$.a?.b?["c"] ?? $.d ? 1 : 2`,
+			expectedTokens: []Token{
+				{Type: TokenType_Dollar},
+				{Type: TokenType_Dot},
+				{Type: TokenType_Label, Value: "a"},
+				{Type: TokenType_OptionalDot},
+				{Type: TokenType_Label, Value: "b"},
+				{Type: TokenType_OptionalBracket},
+				{Type: TokenType_StringLiteral, Value: "c"},
+				{Type: TokenType_RightBracket},
+				{Type: TokenType_Coalesce},
+				{Type: TokenType_Dollar},
+				{Type: TokenType_Dot},
+				{Type: TokenType_Label, Value: "d"},
+				{Type: TokenType_Question},
+				{Type: TokenType_Number, Value: "1"},
+				{Type: TokenType_Colon},
+				{Type: TokenType_Number, Value: "2"},
+			},
+		},
+		"Question followed by space is ternary": {
+			input: "? [1] : ? .",
+			expectedTokens: []Token{
+				{Type: TokenType_Question},
+				{Type: TokenType_LeftBracket},
+				{Type: TokenType_Number, Value: "1"},
+				{Type: TokenType_RightBracket},
+				{Type: TokenType_Colon},
+				{Type: TokenType_Question},
+				{Type: TokenType_Dot},
+			},
+		},
+		"Membership operators": {
+			input: "$.a in $.b not\tin $.c",
+			expectedTokens: []Token{
+				{Type: TokenType_Dollar},
+				{Type: TokenType_Dot},
+				{Type: TokenType_Label, Value: "a"},
+				{Type: TokenType_In, Value: "in"},
+				{Type: TokenType_Dollar},
+				{Type: TokenType_Dot},
+				{Type: TokenType_Label, Value: "b"},
+				{Type: TokenType_NotIn, Value: "not in"},
+				{Type: TokenType_Dollar},
+				{Type: TokenType_Dot},
+				{Type: TokenType_Label, Value: "c"},
+			},
+		},
+		"Not without in is a label": {
+			input: "not inside notin not",
+			expectedTokens: []Token{
+				{Type: TokenType_Label, Value: "not"},
+				{Type: TokenType_Label, Value: "inside"},
+				{Type: TokenType_Label, Value: "notin"},
+				{Type: TokenType_Label, Value: "not"},
+			},
+		},
+		"Not after a dot is a field name": {
+			input: "$.not in $?.not not in x",
+			expectedTokens: []Token{
+				{Type: TokenType_Dollar},
+				{Type: TokenType_Dot},
+				{Type: TokenType_Label, Value: "not"},
+				{Type: TokenType_In, Value: "in"},
+				{Type: TokenType_Dollar},
+				{Type: TokenType_OptionalDot},
+				{Type: TokenType_Label, Value: "not"},
+				{Type: TokenType_NotIn, Value: "not in"},
+				{Type: TokenType_Label, Value: "x"},
+			},
+		},
+		"Match is not Assign": {
+			input: `$ =~ "a" = b`,
+			expectedTokens: []Token{
+				{Type: TokenType_Dollar},
+				{Type: TokenType_Match},
+				{Type: TokenType_StringLiteral, Value: "a"},
+				{Type: TokenType_Assign},
+				{Type: TokenType_Label, Value: "b"},
+			},
+		},
 		"LessThan": {
 			input: "<",
 			expectedTokens: []Token{
@@ -331,6 +471,31 @@ func Test_Lexer_getToken(t *testing.T) {
 				{Type: TokenType_Slash},
 			},
 		},
+		"Dot": {
+			input: ".",
+			expectedTokens: []Token{
+				{Type: TokenType_Dot},
+			},
+		},
+		"DotPath": {
+			input: "$.items[0].price",
+			expectedTokens: []Token{
+				{Type: TokenType_Dollar},
+				{Type: TokenType_Dot},
+				{Type: TokenType_Label, Value: "items"},
+				{Type: TokenType_LeftBracket},
+				{Type: TokenType_Number, Value: "0"},
+				{Type: TokenType_RightBracket},
+				{Type: TokenType_Dot},
+				{Type: TokenType_Label, Value: "price"},
+			},
+		},
+		"DecimalNumberIsNotDot": {
+			input: "1.5",
+			expectedTokens: []Token{
+				{Type: TokenType_Number, Value: "1.5"},
+			},
+		},
 	}
 
 	for name, tc := range testCases {
@@ -384,18 +549,9 @@ func Test_Lexer_getToken_InvalidRune(t *testing.T) {
 		"backtick": {
 			input: "  123  `",
 		},
-		"single equals": {
-			input: "  123  =",
-		},
-		"single exclamation": {
-			input: "  123  !",
-		},
 		"single ampersand": {
 			input: "  123  &",
 		},
-		"single pipe": {
-			input: "  123  |",
-		},
 	}
 
 	for name, tc := range testCases {
@@ -506,6 +662,99 @@ func Test_Lexer_peekToken(t *testing.T) {
 	})
 }
 
+func Test_Lexer_getToken_Offsets(t *testing.T) {
+	input := "$.a  >=\n\t'日本' 12.5"
+	expected := []struct {
+		tokenType int
+		offset    int
+		end       int
+	}{
+		{TokenType_Dollar, 0, 1},
+		{TokenType_Dot, 1, 2},
+		{TokenType_Label, 2, 3},
+		{TokenType_GreaterThanOrEqual, 5, 7},
+		{TokenType_StringLiteral, 9, 13},
+		{TokenType_Number, 14, 18},
+	}
+	lexer := New(input)
+
+	for _, exp := range expected {
+		tok, err := lexer.GetToken()
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+
+		if tok.Type != exp.tokenType || tok.Offset != exp.offset || tok.End != exp.end {
+			t.Fatalf("Unexpected token\nExpected: %s at %d-%d\nActual: %s at %d-%d", TokenTypeString[exp.tokenType], exp.offset, exp.end, tok, tok.Offset, tok.End)
+		}
+
+		if last := lexer.LastToken(); last != tok {
+			t.Fatalf("Unexpected last token\nExpected: %+v\nActual: %+v", tok, last)
+		}
+	}
+
+	tok, err := lexer.GetToken()
+	if err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+
+	if tok.Offset != len([]rune(input)) || lexer.LastToken().Offset != len([]rune(input)) {
+		t.Fatalf("Expected EOF offset %d, got %d", len([]rune(input)), tok.Offset)
+	}
+}
+
+func Test_Lexer_getToken_ErrorOffset(t *testing.T) {
+	testCases := map[string]struct {
+		input  string
+		offset int
+	}{
+		"Invalid rune": {
+			input:  "1 + `",
+			offset: 4,
+		},
+		"Invalid escape": {
+			input:  `"ab\q"`,
+			offset: 3,
+		},
+		"Unterminated string": {
+			input:  `  "abc`,
+			offset: 2,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			lexer := New(tc.input)
+
+			var err error
+			for err == nil {
+				_, err = lexer.GetToken()
+			}
+
+			var lexErr *Error
+			if !errors.As(err, &lexErr) {
+				t.Fatalf("Expected *Error, got %T: %v", err, err)
+			}
+
+			if lexErr.Offset != tc.offset {
+				t.Fatalf("Unexpected offset\nExpected: %d\nActual: %d", tc.offset, lexErr.Offset)
+			}
+		})
+	}
+}
+
+func Test_Lexer_peekToken_Error(t *testing.T) {
+	lexer := New("`")
+
+	if _, err := lexer.PeekToken(); !errors.Is(err, errInvalidRune) {
+		t.Fatalf("Unexpected result\nExpected: %s\nActual: %v", errInvalidRune, err)
+	}
+
+	if _, err := lexer.GetToken(); !errors.Is(err, errInvalidRune) {
+		t.Fatalf("Unexpected result\nExpected: %s\nActual: %v", errInvalidRune, err)
+	}
+}
+
 func Test_Lexer_getTokenStringLiteral_UnexpectedEOF(t *testing.T) {
 	input := `"hello `
 	lexer := New(input)
@@ -546,6 +795,62 @@ func Test_Token_String(t *testing.T) {
 			token:    Token{Type: TokenType_IntegerDivision, Value: ""},
 			expected: "IntegerDivision",
 		},
+		"Dot": {
+			token:    Token{Type: TokenType_Dot, Value: ""},
+			expected: "Dot",
+		},
+		"Null": {
+			token:    Token{Type: TokenType_Null, Value: ""},
+			expected: "Null",
+		},
+		"Not": {
+			token:    Token{Type: TokenType_Not, Value: ""},
+			expected: "Not",
+		},
+		"Let": {
+			token:    Token{Type: TokenType_Let, Value: ""},
+			expected: "Let",
+		},
+		"Assign": {
+			token:    Token{Type: TokenType_Assign, Value: ""},
+			expected: "Assign",
+		},
+		"Semicolon": {
+			token:    Token{Type: TokenType_Semicolon, Value: ""},
+			expected: "Semicolon",
+		},
+		"Arrow": {
+			token:    Token{Type: TokenType_Arrow, Value: ""},
+			expected: "Arrow",
+		},
+		"Pipe": {
+			token:    Token{Type: TokenType_Pipe, Value: ""},
+			expected: "Pipe",
+		},
+		"OptionalDot": {
+			token:    Token{Type: TokenType_OptionalDot, Value: ""},
+			expected: "OptionalDot",
+		},
+		"OptionalBracket": {
+			token:    Token{Type: TokenType_OptionalBracket, Value: ""},
+			expected: "OptionalBracket",
+		},
+		"Coalesce": {
+			token:    Token{Type: TokenType_Coalesce, Value: ""},
+			expected: "Coalesce",
+		},
+		"In": {
+			token:    Token{Type: TokenType_In, Value: ""},
+			expected: "In",
+		},
+		"NotIn": {
+			token:    Token{Type: TokenType_NotIn, Value: ""},
+			expected: "NotIn",
+		},
+		"Match": {
+			token:    Token{Type: TokenType_Match, Value: ""},
+			expected: "Match",
+		},
 	}
 
 	for name, tc := range testCases {
@@ -643,6 +948,22 @@ func Test_Lexer_getTokenLabel(t *testing.T) {
 			input:    "false",
 			expected: Token{Type: TokenType_Boolean, Value: "false"},
 		},
+		"Null": {
+			input:    "null",
+			expected: Token{Type: TokenType_Null, Value: "null"},
+		},
+		"Null prefix is a label": {
+			input:    "nullable",
+			expected: Token{Type: TokenType_Label, Value: "nullable"},
+		},
+		"Let": {
+			input:    "let",
+			expected: Token{Type: TokenType_Let, Value: "let"},
+		},
+		"Let prefix is a label": {
+			input:    "letter",
+			expected: Token{Type: TokenType_Label, Value: "letter"},
+		},
 	}
 
 	for name, tc := range testCases {
@@ -664,6 +985,7 @@ func Test_Lexer_getTokenLabel(t *testing.T) {
 func Test_Lexer_getTokenStringLiteral(t *testing.T) {
 	testCases := map[string]struct {
 		input    string
+		quote    rune
 		expected Token
 	}{
 		"Simple string": {
@@ -682,12 +1004,64 @@ func Test_Lexer_getTokenStringLiteral(t *testing.T) {
 			input:    "hello!@#$%^&*()\"",
 			expected: Token{Type: TokenType_StringLiteral, Value: "hello!@#$%^&*()"},
 		},
+		"Escaped quote": {
+			input:    `say \"hi\""`,
+			expected: Token{Type: TokenType_StringLiteral, Value: `say "hi"`},
+		},
+		"Escaped backslash": {
+			input:    `C:\\temp"`,
+			expected: Token{Type: TokenType_StringLiteral, Value: `C:\temp`},
+		},
+		"Escaped whitespace": {
+			input:    `a\nb\tc\rd"`,
+			expected: Token{Type: TokenType_StringLiteral, Value: "a\nb\tc\rd"},
+		},
+		"Remaining JSON escapes": {
+			input:    `\/\b\f"`,
+			expected: Token{Type: TokenType_StringLiteral, Value: "/\b\f"},
+		},
+		"Unicode escape": {
+			input:    `caf\u00e9 \u00E9"`,
+			expected: Token{Type: TokenType_StringLiteral, Value: "café é"},
+		},
+		"Unicode surrogate pair": {
+			input:    `\ud83d\ude00"`,
+			expected: Token{Type: TokenType_StringLiteral, Value: "😀"},
+		},
+		"Unescaped multibyte runes": {
+			input:    `日本語"`,
+			expected: Token{Type: TokenType_StringLiteral, Value: "日本語"},
+		},
+		"Single-quoted string": {
+			input:    `hello'`,
+			quote:    '\'',
+			expected: Token{Type: TokenType_StringLiteral, Value: `hello`},
+		},
+		"Single-quoted string with double quotes": {
+			input:    `say "hi"'`,
+			quote:    '\'',
+			expected: Token{Type: TokenType_StringLiteral, Value: `say "hi"`},
+		},
+		"Single-quoted string with escaped single quote": {
+			input:    `it\'s'`,
+			quote:    '\'',
+			expected: Token{Type: TokenType_StringLiteral, Value: `it's`},
+		},
+		"Escaped single quote in double-quoted string": {
+			input:    `it\'s"`,
+			expected: Token{Type: TokenType_StringLiteral, Value: `it's`},
+		},
 	}
 
 	for name, tc := range testCases {
 		t.Run(name, func(t *testing.T) {
+			quote := tc.quote
+			if quote == 0 {
+				quote = '"'
+			}
+
 			lexer := New(tc.input)
-			result, err := lexer.getTokenStringLiteral()
+			result, err := lexer.getTokenStringLiteral(quote)
 
 			if err != nil {
 				t.Fatalf("Unexpected error: %s", err)
@@ -700,11 +1074,81 @@ func Test_Lexer_getTokenStringLiteral(t *testing.T) {
 	}
 }
 
+func Test_Lexer_getTokenStringLiteral_InvalidEscape(t *testing.T) {
+	testCases := map[string]struct {
+		input    string
+		expected error
+		message  string
+		offset   int
+	}{
+		"Unknown escape": {
+			input:    `"ab\x"`,
+			expected: errInvalidEscape,
+			message:  "invalid escape sequence: \\x",
+			offset:   3,
+		},
+		"Short unicode escape": {
+			input:    `"\u12"`,
+			expected: errInvalidEscape,
+			message:  "\\u requires 4 hex digits",
+			offset:   1,
+		},
+		"Non-hex unicode escape": {
+			input:    `"ok \uZZZZ"`,
+			expected: errInvalidEscape,
+			message:  "\\u requires 4 hex digits",
+			offset:   4,
+		},
+		"Unpaired high surrogate": {
+			input:    `"\ud83d"`,
+			expected: errInvalidEscape,
+			message:  "unpaired surrogate \\uD83D",
+			offset:   1,
+		},
+		"Unpaired low surrogate": {
+			input:    `"\ude00"`,
+			expected: errInvalidEscape,
+			message:  "unpaired surrogate \\uDE00",
+			offset:   1,
+		},
+		"High surrogate followed by non-surrogate": {
+			input:    `"\ud83d\u0041"`,
+			expected: errInvalidEscape,
+			message:  "unpaired surrogate",
+			offset:   1,
+		},
+		"Backslash at end of input": {
+			input:    `"abc\`,
+			expected: errUnexpectedEOF,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			lexer := New(tc.input)
+			_, err := lexer.GetToken()
+
+			if !errors.Is(err, tc.expected) {
+				t.Fatalf("Unexpected result\nExpected: %s\nActual: %v", tc.expected, err)
+			}
+
+			if !strings.Contains(err.Error(), tc.message) {
+				t.Fatalf("Expected error to contain %q, got %q", tc.message, err)
+			}
+
+			var lexErr *Error
+			if tc.expected == errInvalidEscape && (!errors.As(err, &lexErr) || lexErr.Offset != tc.offset) {
+				t.Fatalf("Expected error at offset %d, got %v", tc.offset, err)
+			}
+		})
+	}
+}
+
 func Test_Lexer_getTokenStringLiteral_EOF(t *testing.T) {
 	input := "hello world"
 	lexer := New(input)
 
-	_, err := lexer.getTokenStringLiteral()
+	_, err := lexer.getTokenStringLiteral('"')
 
 	if err == nil {
 		t.Fatalf("Error expected but not returned")
@@ -3,6 +3,9 @@ package parser
 import (
 	"errors"
 	"fmt"
+	"math"
+	"regexp"
+	"time"
 
 	"github.com/shopspring/decimal"
 )
@@ -37,6 +40,20 @@ const (
 	ExprType_Exponent
 	ExprType_IntegerDivision
 	ExprType_ListSlice
+	ExprType_FieldAccess
+	ExprType_Null
+	ExprType_Not
+	ExprType_UnaryPlus
+	ExprType_Let
+	ExprType_Lambda
+	ExprType_Pipe
+	ExprType_Coalesce
+	ExprType_In
+	ExprType_Match
+	ExprType_Regex
+	ExprType_Time
+	ExprType_Duration
+	ExprType_Negate
 )
 
 var (
@@ -44,6 +61,9 @@ var (
 	ErrUndefinedToken    = errors.New("undefined token")
 	ErrExpectedToken     = errors.New("expected token")
 	ErrUndefinedFunction = errors.New("undefined function")
+	ErrUndefinedVariable = errors.New("undefined variable")
+	ErrInvalidRegex      = errors.New("invalid regular expression")
+	ErrShadowedVariable  = errors.New("shadowed variable")
 )
 
 // Expr represents an evaluable expression.
@@ -52,6 +72,27 @@ type Expr interface {
 
 	Type() int
 	Decode() (any, error)
+	Position() Pos
+}
+
+// Pos is the span of query text an expression was parsed from, given as the
+// rune offsets of its first rune and of the rune following it. Expressions
+// that were not parsed from the query, such as evaluation results, have a
+// zero Pos.
+type Pos struct {
+	Start int
+	End   int
+}
+
+// Position returns the span of query text the expression was parsed from.
+func (p Pos) Position() Pos {
+	return p
+}
+
+// IsZero reports whether the span is empty, as for an expression that was not
+// parsed from the query.
+func (p Pos) IsZero() bool {
+	return p.Start == p.End
 }
 
 func (ExprBlock) Type() int              { return ExprType_Block }
@@ -82,6 +123,20 @@ func (ExprFunction) Type() int           { return ExprType_Function }
 func (ExprExponent) Type() int           { return ExprType_Exponent }
 func (ExprIntegerDivision) Type() int    { return ExprType_IntegerDivision }
 func (ExprListSlice) Type() int          { return ExprType_ListSlice }
+func (ExprFieldAccess) Type() int        { return ExprType_FieldAccess }
+func (ExprNull) Type() int               { return ExprType_Null }
+func (ExprNot) Type() int                { return ExprType_Not }
+func (ExprUnaryPlus) Type() int          { return ExprType_UnaryPlus }
+func (ExprLet) Type() int                { return ExprType_Let }
+func (ExprLambda) Type() int             { return ExprType_Lambda }
+func (ExprPipe) Type() int               { return ExprType_Pipe }
+func (ExprCoalesce) Type() int           { return ExprType_Coalesce }
+func (ExprIn) Type() int                 { return ExprType_In }
+func (ExprMatch) Type() int              { return ExprType_Match }
+func (ExprRegex) Type() int              { return ExprType_Regex }
+func (ExprTime) Type() int               { return ExprType_Time }
+func (ExprDuration) Type() int           { return ExprType_Duration }
+func (ExprNegate) Type() int             { return ExprType_Negate }
 func (ExprVariable) String() string      { return "Variable" }
 
 func (ExprBlock) String() string              { return "Block" }
@@ -111,10 +166,25 @@ func (ExprFunction) String() string           { return "Function" }
 func (ExprExponent) String() string           { return "Exponent" }
 func (ExprIntegerDivision) String() string    { return "IntegerDivision" }
 func (ExprListSlice) String() string          { return "ListSlice" }
+func (ExprFieldAccess) String() string        { return "FieldAccess" }
+func (ExprNull) String() string               { return "Null" }
+func (ExprNot) String() string                { return "Not" }
+func (ExprUnaryPlus) String() string          { return "UnaryPlus" }
+func (ExprLet) String() string                { return "Let" }
+func (ExprLambda) String() string             { return "Lambda" }
+func (ExprPipe) String() string               { return "Pipe" }
+func (ExprCoalesce) String() string           { return "Coalesce" }
+func (ExprIn) String() string                 { return "In" }
+func (ExprMatch) String() string              { return "Match" }
+func (ExprRegex) String() string              { return "Regex" }
+func (ExprTime) String() string               { return "Time" }
+func (ExprDuration) String() string           { return "Duration" }
+func (ExprNegate) String() string             { return "Negate" }
 
 // ExprBlock represents a grouped expression.
 type ExprBlock struct {
 	Expr Expr
+	Pos
 }
 
 func (e ExprBlock) Decode() (result any, err error) {
@@ -125,21 +195,25 @@ func (e ExprBlock) Decode() (result any, err error) {
 // ExprNumber represents a number literal.
 type ExprNumber struct {
 	Value decimal.Decimal
+	Pos
 }
 
+// Decode returns the nearest float64 to the number, which may not represent
+// it exactly, such as for 0.1 or the result of 1 / 3.
 func (e ExprNumber) Decode() (result any, err error) {
-	result, ok := e.Value.Float64()
-	if !ok {
-		err = fmt.Errorf("failed to decode as float64")
+	f := e.Value.InexactFloat64()
+	if math.IsInf(f, 0) {
+		err = fmt.Errorf("failed to decode as float64: %s is out of range", e.Value)
 		return
 	}
 
-	return result, nil
+	return f, nil
 }
 
 // ExprString represents a string literal.
 type ExprString struct {
 	Value string
+	Pos
 }
 
 func (e ExprString) Decode() (result any, err error) {
@@ -149,6 +223,7 @@ func (e ExprString) Decode() (result any, err error) {
 
 // ExprInput represents the input data variable.
 type ExprInput struct {
+	Pos
 }
 
 func (e ExprInput) Decode() (result any, err error) {
@@ -160,6 +235,7 @@ func (e ExprInput) Decode() (result any, err error) {
 type ExprAdd struct {
 	Expr1 Expr
 	Expr2 Expr
+	Pos
 }
 
 func (e ExprAdd) Decode() (result any, err error) {
@@ -171,6 +247,7 @@ func (e ExprAdd) Decode() (result any, err error) {
 type ExprSubtract struct {
 	Expr1 Expr
 	Expr2 Expr
+	Pos
 }
 
 func (e ExprSubtract) Decode() (result any, err error) {
@@ -182,6 +259,7 @@ func (e ExprSubtract) Decode() (result any, err error) {
 type ExprMultiply struct {
 	Expr1 Expr
 	Expr2 Expr
+	Pos
 }
 
 func (e ExprMultiply) Decode() (result any, err error) {
@@ -193,6 +271,7 @@ func (e ExprMultiply) Decode() (result any, err error) {
 type ExprDivide struct {
 	Expr1 Expr
 	Expr2 Expr
+	Pos
 }
 
 func (e ExprDivide) Decode() (result any, err error) {
@@ -204,6 +283,7 @@ func (e ExprDivide) Decode() (result any, err error) {
 type ExprModulo struct {
 	Expr1 Expr
 	Expr2 Expr
+	Pos
 }
 
 func (e ExprModulo) Decode() (result any, err error) {
@@ -215,6 +295,7 @@ func (e ExprModulo) Decode() (result any, err error) {
 type ExprIntegerDivision struct {
 	Expr1 Expr
 	Expr2 Expr
+	Pos
 }
 
 func (e ExprIntegerDivision) Decode() (result any, err error) {
@@ -226,6 +307,7 @@ func (e ExprIntegerDivision) Decode() (result any, err error) {
 type ExprEquals struct {
 	Expr1 Expr
 	Expr2 Expr
+	Pos
 }
 
 func (e ExprEquals) Decode() (result any, err error) {
@@ -237,6 +319,7 @@ func (e ExprEquals) Decode() (result any, err error) {
 type ExprNotEquals struct {
 	Expr1 Expr
 	Expr2 Expr
+	Pos
 }
 
 func (e ExprNotEquals) Decode() (result any, err error) {
@@ -248,6 +331,7 @@ func (e ExprNotEquals) Decode() (result any, err error) {
 type ExprGreaterThan struct {
 	Expr1 Expr
 	Expr2 Expr
+	Pos
 }
 
 func (e ExprGreaterThan) Decode() (result any, err error) {
@@ -259,6 +343,7 @@ func (e ExprGreaterThan) Decode() (result any, err error) {
 type ExprGreaterThanOrEqual struct {
 	Expr1 Expr
 	Expr2 Expr
+	Pos
 }
 
 func (e ExprGreaterThanOrEqual) Decode() (result any, err error) {
@@ -270,6 +355,7 @@ func (e ExprGreaterThanOrEqual) Decode() (result any, err error) {
 type ExprLessThan struct {
 	Expr1 Expr
 	Expr2 Expr
+	Pos
 }
 
 func (e ExprLessThan) Decode() (result any, err error) {
@@ -281,6 +367,7 @@ func (e ExprLessThan) Decode() (result any, err error) {
 type ExprLessThanOrEqual struct {
 	Expr1 Expr
 	Expr2 Expr
+	Pos
 }
 
 func (e ExprLessThanOrEqual) Decode() (result any, err error) {
@@ -292,6 +379,7 @@ func (e ExprLessThanOrEqual) Decode() (result any, err error) {
 type ExprAnd struct {
 	Expr1 Expr
 	Expr2 Expr
+	Pos
 }
 
 func (e ExprAnd) Decode() (result any, err error) {
@@ -303,6 +391,7 @@ func (e ExprAnd) Decode() (result any, err error) {
 type ExprOr struct {
 	Expr1 Expr
 	Expr2 Expr
+	Pos
 }
 
 func (e ExprOr) Decode() (result any, err error) {
@@ -315,6 +404,7 @@ type ExprTernary struct {
 	Condition Expr
 	TrueExpr  Expr
 	FalseExpr Expr
+	Pos
 }
 
 func (e ExprTernary) Decode() (result any, err error) {
@@ -325,6 +415,7 @@ func (e ExprTernary) Decode() (result any, err error) {
 // ExprBoolean represents a boolean literal.
 type ExprBoolean struct {
 	Value bool
+	Pos
 }
 
 func (e ExprBoolean) Decode() (result any, err error) {
@@ -332,9 +423,20 @@ func (e ExprBoolean) Decode() (result any, err error) {
 	return result, nil
 }
 
+// ExprNull represents the null literal and the absence of a value in the
+// input data.
+type ExprNull struct {
+	Pos
+}
+
+func (e ExprNull) Decode() (result any, err error) {
+	return nil, nil
+}
+
 // ExprList represents a list literal containing zero or more expressions.
 type ExprList struct {
 	Values []Expr
+	Pos
 }
 
 func (e ExprList) Decode() (result any, err error) {
@@ -344,8 +446,10 @@ func (e ExprList) Decode() (result any, err error) {
 
 // ExprListIndex represents an indexing operation into a list expression.
 type ExprListIndex struct {
-	List  Expr
-	Index Expr
+	List     Expr
+	Index    Expr
+	Optional bool // yields null for a null list or missing index, see ?[
+	Pos
 }
 
 func (e ExprListIndex) Decode() (result any, err error) {
@@ -356,6 +460,7 @@ func (e ExprListIndex) Decode() (result any, err error) {
 // ExprMap represents a map literal containing zero or more key-value pairs.
 type ExprMap struct {
 	Pairs []ExprMapPair
+	Pos
 }
 
 func (e ExprMap) Decode() (result any, err error) {
@@ -371,8 +476,10 @@ type ExprMapPair struct {
 
 // ExprMapIndex represents an indexing operation into a map expression.
 type ExprMapIndex struct {
-	Map   Expr
-	Index Expr
+	Map      Expr
+	Index    Expr
+	Optional bool // yields null for a null map or missing key, see ?[
+	Pos
 }
 
 func (e ExprMapIndex) Decode() (result any, err error) {
@@ -380,11 +487,174 @@ func (e ExprMapIndex) Decode() (result any, err error) {
 	return
 }
 
+// ExprFieldAccess represents a dot-path field access such as `$.name` on a
+// map or struct expression.
+type ExprFieldAccess struct {
+	Object   Expr
+	Field    string
+	Optional bool // yields null for a null object or missing field, see ?.
+	Pos
+}
+
+func (e ExprFieldAccess) Decode() (result any, err error) {
+	err = fmt.Errorf("%w: %s", ErrInvalidDecode, e)
+	return
+}
+
+// ExprNot represents a logical negation of a boolean expression.
+type ExprNot struct {
+	Expr Expr
+	Pos
+}
+
+func (e ExprNot) Decode() (result any, err error) {
+	err = fmt.Errorf("%w: %s", ErrInvalidDecode, e)
+	return
+}
+
+// ExprUnaryPlus represents a unary plus applied to a number expression.
+type ExprUnaryPlus struct {
+	Expr Expr
+	Pos
+}
+
+func (e ExprUnaryPlus) Decode() (result any, err error) {
+	err = fmt.Errorf("%w: %s", ErrInvalidDecode, e)
+	return
+}
+
+// ExprNegate represents a unary minus expression.
+type ExprNegate struct {
+	Expr Expr
+	Pos
+}
+
+func (e ExprNegate) Decode() (result any, err error) {
+	err = fmt.Errorf("%w: %s", ErrInvalidDecode, e)
+	return
+}
+
+// ExprLet represents a `let name = value; body` binding, where name is
+// visible only within body.
+type ExprLet struct {
+	Name  string
+	Value Expr
+	Body  Expr
+	Pos
+}
+
+func (e ExprLet) Decode() (result any, err error) {
+	err = fmt.Errorf("%w: %s", ErrInvalidDecode, e)
+	return
+}
+
+// ExprLambda represents an arrow function such as `o => o.total > 10`, passed
+// to functions that take a predicate or transform. Params are visible only
+// within Body.
+type ExprLambda struct {
+	Params []string
+	Body   Expr
+	Pos
+}
+
+func (e ExprLambda) Decode() (result any, err error) {
+	err = fmt.Errorf("%w: %s", ErrInvalidDecode, e)
+	return
+}
+
+// ExprPipe represents `value | call(args...)`, which calls the function with
+// the value as its first argument.
+type ExprPipe struct {
+	Value Expr
+	Call  ExprFunction
+	Pos
+}
+
+func (e ExprPipe) Decode() (result any, err error) {
+	err = fmt.Errorf("%w: %s", ErrInvalidDecode, e)
+	return
+}
+
+// ExprCoalesce represents `left ?? right`, which evaluates to right when left
+// is null or its final field access or index is missing.
+type ExprCoalesce struct {
+	Left  Expr
+	Right Expr
+	Pos
+}
+
+func (e ExprCoalesce) Decode() (result any, err error) {
+	err = fmt.Errorf("%w: %s", ErrInvalidDecode, e)
+	return
+}
+
+// ExprIn represents `value in collection`, which is true when the value is an
+// element of a list, a key of a map or a substring of a string. `value not in
+// collection` is represented as the ExprNot of an ExprIn.
+type ExprIn struct {
+	Value      Expr
+	Collection Expr
+	Pos
+}
+
+func (e ExprIn) Decode() (result any, err error) {
+	err = fmt.Errorf("%w: %s", ErrInvalidDecode, e)
+	return
+}
+
+// ExprMatch represents `value =~ pattern`, which is true when the regular
+// expression matches the string.
+type ExprMatch struct {
+	Value   Expr
+	Pattern Expr
+	Pos
+}
+
+func (e ExprMatch) Decode() (result any, err error) {
+	err = fmt.Errorf("%w: %s", ErrInvalidDecode, e)
+	return
+}
+
+// ExprRegex represents a string literal used as a regular expression, compiled
+// when the query is parsed.
+type ExprRegex struct {
+	Regexp *regexp.Regexp
+	Pos
+}
+
+func (e ExprRegex) Decode() (result any, err error) {
+	result = e.Regexp.String()
+	return result, nil
+}
+
+// ExprTime represents a point in time. Times have no literal syntax; they come
+// from input data or from functions such as parse_time().
+type ExprTime struct {
+	Value time.Time
+	Pos
+}
+
+func (e ExprTime) Decode() (result any, err error) {
+	return e.Value, nil
+}
+
+// ExprDuration represents an elapsed time, such as the difference between two
+// times.
+type ExprDuration struct {
+	Value time.Duration
+	Pos
+}
+
+func (e ExprDuration) Decode() (result any, err error) {
+	return e.Value, nil
+}
+
 // ExprListSlice represents a slicing operation into a list expression with optional start and end indices.
 type ExprListSlice struct {
 	List  Expr
-	Start Expr  // optional
-	End   Expr  // optional
+	Start Expr // optional
+	End   Expr // optional
+	Pos
 }
 
 func (e ExprListSlice) Decode() (result any, err error) {
@@ -396,12 +666,14 @@ func (e ExprListSlice) Decode() (result any, err error) {
 type ExprFunction struct {
 	Name string
 	Args []Expr
+	Pos
 }
 
 // ExprExponent represents an operation that raises one expression to the power of another.
 type ExprExponent struct {
 	Expr1 Expr
 	Expr2 Expr
+	Pos
 }
 
 func (e ExprExponent) Decode() (result any, err error) {
@@ -412,6 +684,7 @@ func (e ExprExponent) Decode() (result any, err error) {
 // ExprVariable represents a variable identifier in the expression, such as `_`
 type ExprVariable struct {
 	Name string
+	Pos
 }
 
 func (e ExprVariable) Decode() (result any, err error) {
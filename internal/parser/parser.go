@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"reflect"
+	"regexp"
 
 	"github.com/fletcharoo/fpath/internal/lexer"
 	"github.com/shopspring/decimal"
@@ -14,9 +16,58 @@ type (
 	operatorFunc func(Expr, Expr) Expr
 )
 
+// Precedence selects how binary operators are grouped by the parser.
+type Precedence int
+
+const (
+	// PrecedenceLeftToRight evaluates arithmetic operators strictly
+	// left-to-right with equal precedence. This is the default.
+	PrecedenceLeftToRight Precedence = iota
+	// PrecedenceStandard uses conventional operator precedence.
+	PrecedenceStandard
+)
+
+// AccumulatorVariable is the name bound to the running value inside the
+// expression passed to reduce().
+const AccumulatorVariable = "acc"
+
+// regexFunctions are the built-in functions whose second argument is a
+// regular expression.
+var regexFunctions = map[string]bool{
+	"matches":    true,
+	"find_all":   true,
+	"replace_re": true,
+	"capture":    true,
+}
+
 var parseMap map[int]parseFunc
 var operatorMap map[int]operatorFunc
 
+// standardPrecedence holds the binding power of each binary operator when
+// parsing with PrecedenceStandard. Higher values bind tighter.
+var standardPrecedence = map[int]int{
+	lexer.TokenType_Coalesce:           1,
+	lexer.TokenType_Or:                 2,
+	lexer.TokenType_And:                3,
+	lexer.TokenType_Equals:             4,
+	lexer.TokenType_NotEquals:          4,
+	lexer.TokenType_GreaterThan:        4,
+	lexer.TokenType_GreaterThanOrEqual: 4,
+	lexer.TokenType_LessThan:           4,
+	lexer.TokenType_LessThanOrEqual:    4,
+	lexer.TokenType_In:                 4,
+	lexer.TokenType_NotIn:              4,
+	lexer.TokenType_Match:              4,
+	lexer.TokenType_Pipe:               5,
+	lexer.TokenType_Plus:               6,
+	lexer.TokenType_Minus:              6,
+	lexer.TokenType_Asterisk:           7,
+	lexer.TokenType_Slash:              7,
+	lexer.TokenType_IntegerDivision:    7,
+	lexer.TokenType_Modulo:             7,
+	lexer.TokenType_Caret:              8,
+}
+
 func init() {
 	parseMap = map[int]parseFunc{
 		lexer.TokenType_Undefined:     parseUndefined,
@@ -24,10 +75,14 @@ func init() {
 		lexer.TokenType_Number:        parseNumber,
 		lexer.TokenType_StringLiteral: parseString,
 		lexer.TokenType_Boolean:       parseBoolean,
+		lexer.TokenType_Null:          parseNull,
 		lexer.TokenType_Dollar:        parseInput,
 		lexer.TokenType_LeftBracket:   parseList,
 		lexer.TokenType_LeftBrace:     parseMapLiteral,
 		lexer.TokenType_Minus:         parseUnaryMinus,
+		lexer.TokenType_Plus:          parseUnaryPlus,
+		lexer.TokenType_Not:           parseNot,
+		lexer.TokenType_Let:           parseLet,
 		lexer.TokenType_Label:         parseLabelOrFunction,
 	}
 
@@ -47,6 +102,10 @@ func init() {
 		lexer.TokenType_LessThanOrEqual:    operatorLessThanOrEqual,
 		lexer.TokenType_And:                operatorAnd,
 		lexer.TokenType_Or:                 operatorOr,
+		lexer.TokenType_Coalesce:           operatorCoalesce,
+		lexer.TokenType_In:                 operatorIn,
+		lexer.TokenType_NotIn:              operatorNotIn,
+		lexer.TokenType_Match:              operatorMatch,
 	}
 }
 
@@ -56,14 +115,82 @@ func New(lexer *lexer.Lexer) *Parser {
 	}
 }
 
+// TokenError is a parse error caused by a token other than the last one read
+// from the lexer.
+type TokenError struct {
+	Token lexer.Token
+	Err   error
+}
+
+func (e *TokenError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TokenError) Unwrap() error {
+	return e.Err
+}
+
+// Options configures a Parser.
+type Options struct {
+	// Precedence selects how binary operators are grouped.
+	Precedence Precedence
+	// Variables lists the variable names a query may reference. When nil,
+	// any name is accepted and resolved at evaluation time.
+	Variables []string
+}
+
+// NewWithOptions returns a parser configured with the given options.
+func NewWithOptions(lexer *lexer.Lexer, opts Options) *Parser {
+	p := &Parser{
+		lexer:      lexer,
+		precedence: opts.Precedence,
+	}
+
+	if opts.Variables != nil {
+		p.variables = make(map[string]bool, len(opts.Variables))
+		p.bindings = make(map[string]bool, len(opts.Variables))
+		for _, name := range opts.Variables {
+			p.variables[name] = true
+			p.bindings[name] = true
+		}
+	}
+
+	return p
+}
+
 // Parser parses a tokenized string into an executable AST.
 type Parser struct {
-	lexer *lexer.Lexer
+	lexer      *lexer.Lexer
+	precedence Precedence
+	variables  map[string]bool // declared variables, nil when unchecked
+	bindings   map[string]bool // variables bound by the query or its options
+}
+
+// spanFrom returns a copy of expr whose source span runs from start to the
+// end of the last token consumed.
+func (p *Parser) spanFrom(expr Expr, start int) Expr {
+	return withPos(expr, Pos{Start: start, End: p.lexer.Offset()})
+}
+
+// withPos returns a copy of expr with its source span set to pos.
+func withPos(expr Expr, pos Pos) Expr {
+	if expr == nil {
+		return nil
+	}
+
+	value := reflect.New(reflect.TypeOf(expr)).Elem()
+	value.Set(reflect.ValueOf(expr))
+	value.FieldByName("Pos").Set(reflect.ValueOf(pos))
+	return value.Interface().(Expr)
 }
 
 // Parse parses the next expression in the query.
 // This now handles primary expressions and then calls wrapOperation to handle binary operations.
 func (p *Parser) Parse() (expr Expr, err error) {
+	if p.precedence == PrecedenceStandard {
+		return p.parseStandard()
+	}
+
 	tok, err := p.lexer.GetToken()
 	if err != nil {
 		err = fmt.Errorf("failed to get token: %w", err)
@@ -83,7 +210,29 @@ func (p *Parser) Parse() (expr Expr, err error) {
 		return
 	}
 
-	return p.wrapOperation(expr)
+	return p.wrapOperation(p.spanFrom(expr, tok.Offset))
+}
+
+// ParseQuery parses a complete query. Unlike Parse, which stops after the
+// first expression, it returns an error if any tokens are left over.
+func (p *Parser) ParseQuery() (expr Expr, err error) {
+	expr, err = p.Parse()
+	if err != nil {
+		return
+	}
+
+	// A peeked EOF reads back as an Undefined token
+	tok, err := p.lexer.PeekToken()
+	if errors.Is(err, io.EOF) || (err == nil && tok.Type == lexer.TokenType_Undefined) {
+		return expr, nil
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to peek token: %w", err)
+		return nil, err
+	}
+
+	err = &TokenError{Token: tok, Err: fmt.Errorf("%w end of query, got %s", ErrExpectedToken, tok)}
+	return nil, err
 }
 
 // wrapOperation checks if the given expression is part of an operation and
@@ -105,34 +254,38 @@ func (p *Parser) wrapOperation(expr Expr) (op Expr, err error) {
 		return p.parseTernary(expr)
 	}
 
-	// Check for indexing next (higher precedence)
-	if tok.Type == lexer.TokenType_LeftBracket {
-		// Consume the left bracket first
-		p.lexer.GetToken() // Consume the LeftBracket from tok
+	// Check for dot-path field access (same precedence as indexing)
+	if tok.Type == lexer.TokenType_Dot || tok.Type == lexer.TokenType_OptionalDot {
+		fieldExpr, err := p.parseFieldAccess(expr)
+		if err != nil {
+			return nil, err
+		}
 
-		// Now peek at the next token to determine operation type
-		nextTok, err := p.lexer.PeekToken()
+		// Check for chained access (e.g., $.a.b or $.a[0])
+		return p.wrapOperation(fieldExpr)
+	}
+
+	// Check for indexing next (higher precedence)
+	if tok.Type == lexer.TokenType_LeftBracket || tok.Type == lexer.TokenType_OptionalBracket {
+		indexedExpr, err := p.parseIndex(expr)
 		if err != nil {
-			return nil, fmt.Errorf("failed to peek token: %w", err)
+			return nil, err
 		}
 
-		// Use map indexing if:
-		// 1. Expression being indexed is a map or map index
-		// 2. Expression being indexed is not a list AND index is a string literal
-		// 3. Expression being indexed is a list AND index is a string literal (invalid map access)
-		// 4. For ExprInput, decide based on index type (string -> map, number -> list)
-		// Otherwise, use list indexing
-		if expr.Type() == ExprType_Map || expr.Type() == ExprType_MapIndex || nextTok.Type == lexer.TokenType_StringLiteral {
-			return p.parseMapIndex(expr)
-		} else if expr.Type() == ExprType_Input {
-			if nextTok.Type == lexer.TokenType_StringLiteral {
-				return p.parseMapIndex(expr)
-			} else {
-				return p.parseListIndex(expr)
-			}
-		} else {
-			return p.parseListIndex(expr)
+		// Check for chained indexing (e.g., [1,2,3][0][1])
+		return p.wrapOperation(indexedExpr)
+	}
+
+	// Check for a pipe into a function call, which applies to everything on
+	// its left
+	if tok.Type == lexer.TokenType_Pipe {
+		pipeExpr, err := p.parsePipe(expr)
+		if err != nil {
+			return nil, err
 		}
+
+		// Check for chained pipes and operations on the result
+		return p.wrapOperation(pipeExpr)
 	}
 
 	f, ok := operatorMap[tok.Type]
@@ -143,6 +296,24 @@ func (p *Parser) wrapOperation(expr Expr) (op Expr, err error) {
 	// This skips the peeked token.
 	p.lexer.GetToken()
 
+	// Membership and matching only take the operand that follows them, so
+	// that `$.status in ["open"] && $.paid` chains with the logical operators
+	if tok.Type == lexer.TokenType_In || tok.Type == lexer.TokenType_NotIn || tok.Type == lexer.TokenType_Match {
+		operand, err := p.parsePrimary()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the second expression: %w", err)
+		}
+
+		if tok.Type == lexer.TokenType_Match {
+			operand, err = regexLiteral(operand)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return p.wrapOperation(p.spanFrom(f(expr, operand), expr.Position().Start))
+	}
+
 	// For the specific requirement of left-associative arithmetic operations without precedence,
 	// we need to distinguish between arithmetic operators and other operators.
 	// Arithmetic operators (+, -, *, /) should all have the same precedence and be left-associative.
@@ -159,7 +330,7 @@ func (p *Parser) wrapOperation(expr Expr) (op Expr, err error) {
 		// but we still need to be careful about precedence
 		expr2, err := p.Parse()
 		if err != nil {
-			err = fmt.Errorf("failed to parse the second expression: %w", err)
+			return nil, fmt.Errorf("failed to parse the second expression: %w", err)
 		}
 
 		// Check if the second expression is a ternary and there's no more tokens
@@ -172,16 +343,19 @@ func (p *Parser) wrapOperation(expr Expr) (op Expr, err error) {
 				// No more tokens, so this should be parsed as a ternary
 				// with the binary operation as the condition
 				ternaryExpr := expr2.(ExprTernary)
-				binaryOp := f(expr, ternaryExpr.Condition)
-				return ExprTernary{
+				binaryOp := withPos(f(expr, ternaryExpr.Condition), Pos{
+					Start: expr.Position().Start,
+					End:   ternaryExpr.Condition.Position().End,
+				})
+				return p.spanFrom(ExprTernary{
 					Condition: binaryOp,
 					TrueExpr:  ternaryExpr.TrueExpr,
 					FalseExpr: ternaryExpr.FalseExpr,
-				}, nil
+				}, expr.Position().Start), nil
 			}
 		}
 
-		result, err := p.wrapOperation(f(expr, expr2))
+		result, err := p.wrapOperation(p.spanFrom(f(expr, expr2), expr.Position().Start))
 		if err != nil {
 			return nil, err
 		}
@@ -221,7 +395,7 @@ func (p *Parser) parseArithmeticLeftAssociative(left Expr, leftOp operatorFunc)
 	}
 
 	// Apply the first operation
-	result = leftOp(left, right)
+	result = p.spanFrom(leftOp(left, right), left.Position().Start)
 
 	// Continue looking for more arithmetic operators at the same precedence level
 	for {
@@ -249,7 +423,7 @@ func (p *Parser) parseArithmeticLeftAssociative(left Expr, leftOp operatorFunc)
 		}
 
 		// Apply the operator left-associatively: (result op nextRight)
-		result = nextOp(result, nextRight)
+		result = p.spanFrom(nextOp(result, nextRight), left.Position().Start)
 	}
 
 	// After processing all arithmetic operations at this level,
@@ -275,6 +449,7 @@ func (p *Parser) parseArithmeticOperand() (Expr, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse arithmetic operand: %w", err)
 	}
+	expr = p.spanFrom(expr, tok.Offset)
 
 	// After parsing the primary, we need to handle higher precedence operations
 	// like indexing, but NOT arithmetic operations
@@ -304,31 +479,21 @@ func (p *Parser) wrapOperationNonArithmetic(expr Expr) (Expr, error) {
 			return p.parseTernary(expr)
 		}
 
-		// Handle indexing (higher precedence than binary ops)
-		if tok.Type == lexer.TokenType_LeftBracket {
-			// Consume left bracket
-			p.lexer.GetToken()
-
-			// Peek at the index expression
-			nextTok, err := p.lexer.PeekToken()
+		// Handle dot-path field access (same precedence as indexing)
+		if tok.Type == lexer.TokenType_Dot || tok.Type == lexer.TokenType_OptionalDot {
+			fieldExpr, err := p.parseFieldAccess(expr)
 			if err != nil {
-				return nil, fmt.Errorf("failed to peek token: %w", err)
+				return nil, err
 			}
 
-			var indexedExpr Expr
-			var indexErr error
-			if expr.Type() == ExprType_Map || expr.Type() == ExprType_MapIndex || nextTok.Type == lexer.TokenType_StringLiteral {
-				indexedExpr, indexErr = p.parseMapIndex(expr)
-			} else if expr.Type() == ExprType_Input {
-				if nextTok.Type == lexer.TokenType_StringLiteral {
-					indexedExpr, indexErr = p.parseMapIndex(expr)
-				} else {
-					indexedExpr, indexErr = p.parseListIndex(expr)
-				}
-			} else {
-				indexedExpr, indexErr = p.parseListIndex(expr)
-			}
+			// Continue to check for more non-arithmetic operations
+			expr = fieldExpr
+			continue
+		}
 
+		// Handle indexing (higher precedence than binary ops)
+		if tok.Type == lexer.TokenType_LeftBracket || tok.Type == lexer.TokenType_OptionalBracket {
+			indexedExpr, indexErr := p.parseIndex(expr)
 			if indexErr != nil {
 				return nil, indexErr
 			}
@@ -353,6 +518,10 @@ func parseUndefined(_ *Parser, tok lexer.Token) (expr Expr, err error) {
 // parseBlock parses a blocked expression (an expression within parantheses).
 // parseBlock implement parseFunc.
 func parseBlock(p *Parser, _ lexer.Token) (expr Expr, err error) {
+	if params, ok := p.parseLambdaParams(); ok {
+		return p.parseLambda(params)
+	}
+
 	expr, err = p.Parse()
 	if err != nil {
 		err = fmt.Errorf("parseBlock: %w", err)
@@ -407,6 +576,12 @@ func parseBoolean(_ *Parser, tok lexer.Token) (expr Expr, err error) {
 	return exprBoolean, nil
 }
 
+// parseNull parses a null literal token.
+// parseNull implements parseFunc.
+func parseNull(_ *Parser, _ lexer.Token) (expr Expr, err error) {
+	return ExprNull{}, nil
+}
+
 // parseInput parses an input data variable token.
 // parseInput implements parseFunc.
 func parseInput(_ *Parser, _ lexer.Token) (expr Expr, err error) {
@@ -417,21 +592,397 @@ func parseInput(_ *Parser, _ lexer.Token) (expr Expr, err error) {
 // parseUnaryMinus parses a unary minus expression.
 // parseUnaryMinus implements parseFunc.
 func parseUnaryMinus(p *Parser, _ lexer.Token) (expr Expr, err error) {
-	// Parse the operand after the minus
-	operand, err := p.Parse()
+	// Parse the operand after the minus. With standard precedence the minus
+	// binds looser than `^` but tighter than every other binary operator;
+	// otherwise it binds only to the operand directly after it, like `!`.
+	var operand Expr
+	if p.precedence == PrecedenceStandard {
+		operand, err = p.parseBinary(standardPrecedence[lexer.TokenType_Caret] - 1)
+	} else {
+		operand, err = p.parseUnaryOperand()
+	}
 	if err != nil {
 		err = fmt.Errorf("failed to parse unary minus operand: %w", err)
 		return
 	}
 
-	// Create a subtraction from zero: 0 - operand
-	zero := ExprNumber{Value: decimal.NewFromInt(0)}
-	return ExprSubtract{
-		Expr1: zero,
-		Expr2: operand,
+	return ExprNegate{Expr: operand}, nil
+}
+
+// parseStandard parses a full expression using PrecedenceStandard, with the
+// ternary operator binding loosest.
+func (p *Parser) parseStandard() (Expr, error) {
+	expr, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+
+	tok, err := p.lexer.PeekToken()
+	if err == nil && tok.Type == lexer.TokenType_Question {
+		return p.parseTernary(expr)
+	}
+
+	return expr, nil
+}
+
+// parseBinary parses binary operations whose precedence is greater than
+// minPrecedence using precedence climbing. All operators, including `^`,
+// are left-associative, matching the runtime's handling of chained
+// exponentiation.
+func (p *Parser) parseBinary(minPrecedence int) (Expr, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse: %w", err)
+	}
+
+	for {
+		tok, err := p.lexer.PeekToken()
+		if errors.Is(io.EOF, err) {
+			return left, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to peek token: %w", err)
+		}
+
+		precedence, ok := standardPrecedence[tok.Type]
+		if !ok || precedence <= minPrecedence {
+			return left, nil
+		}
+
+		if tok.Type == lexer.TokenType_Pipe {
+			left, err = p.parsePipe(left)
+			if err != nil {
+				return nil, err
+			}
+
+			left, err = p.parsePostfix(left)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// This skips the peeked token.
+		p.lexer.GetToken()
+
+		right, err := p.parseBinary(precedence)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the second expression: %w", err)
+		}
+
+		if tok.Type == lexer.TokenType_Match {
+			right, err = regexLiteral(right)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		left = p.spanFrom(operatorMap[tok.Type](left, right), left.Position().Start)
+	}
+}
+
+// parsePipe parses the function call on the right of a pipe, with value as
+// its first argument.
+func (p *Parser) parsePipe(value Expr) (expr Expr, err error) {
+	// Consume the pipe (already peeked)
+	p.lexer.GetToken()
+
+	nameTok, err := p.lexer.GetToken()
+	if err != nil {
+		err = fmt.Errorf("%w function call after pipe, got EOF", ErrExpectedToken)
+		return
+	}
+
+	nextTok, peekErr := p.lexer.PeekToken()
+	if nameTok.Type != lexer.TokenType_Label || peekErr != nil || nextTok.Type != lexer.TokenType_LeftParan {
+		err = fmt.Errorf("%w function call after pipe, got %s", ErrExpectedToken, nameTok)
+		return
+	}
+
+	call, err := p.parseFunction(nameTok.Value, 1)
+	if err != nil {
+		return
+	}
+
+	exprFunction, ok := call.(ExprFunction)
+	if !ok {
+		err = fmt.Errorf("failed to assert expression as function")
+		return
+	}
+
+	return p.spanFrom(ExprPipe{
+		Value: value,
+		Call:  p.spanFrom(exprFunction, nameTok.Offset).(ExprFunction),
+	}, value.Position().Start), nil
+}
+
+// parseLet parses a `let name = value; body` binding. The body extends as far
+// as possible, so a binding inside a larger expression must be wrapped in
+// parentheses.
+// parseLet implements parseFunc.
+func parseLet(p *Parser, _ lexer.Token) (expr Expr, err error) {
+	nameTok, err := p.lexer.GetToken()
+	if err != nil {
+		err = fmt.Errorf("%w Label after let, got EOF", ErrExpectedToken)
+		return
+	}
+
+	if nameTok.Type != lexer.TokenType_Label {
+		err = fmt.Errorf("%w Label after let, got %s", ErrExpectedToken, nameTok)
+		return
+	}
+
+	if nameTok.Value == "_" {
+		err = fmt.Errorf("cannot bind reserved variable _")
+		return
+	}
+
+	assignTok, err := p.lexer.GetToken()
+	if err != nil || assignTok.Type != lexer.TokenType_Assign {
+		err = fmt.Errorf("%w Assign after let %s, got %s", ErrExpectedToken, nameTok.Value, assignTok)
+		return
+	}
+
+	value, err := p.Parse()
+	if err != nil {
+		err = fmt.Errorf("failed to parse value of let %s: %w", nameTok.Value, err)
+		return
+	}
+
+	semicolonTok, err := p.lexer.GetToken()
+	if err != nil || semicolonTok.Type != lexer.TokenType_Semicolon {
+		err = fmt.Errorf("%w Semicolon after let %s value, got %s", ErrExpectedToken, nameTok.Value, semicolonTok)
+		return
+	}
+
+	// The bound name is declared while parsing the body only
+	restore := p.declareVariable(nameTok.Value)
+	body, err := p.Parse()
+	restore()
+	if err != nil {
+		err = fmt.Errorf("failed to parse body of let %s: %w", nameTok.Value, err)
+		return
+	}
+
+	return ExprLet{
+		Name:  nameTok.Value,
+		Value: value,
+		Body:  body,
+	}, nil
+}
+
+// parseLambdaParams reports whether the tokens following a left parenthesis
+// form a lambda parameter list such as `(a, b) =>`. If they do, the list and
+// the arrow are consumed and the parameter names are returned. Otherwise no
+// tokens are consumed.
+func (p *Parser) parseLambdaParams() (params []string, ok bool) {
+	// Scan ahead on a copy of the lexer so a block can be parsed as normal
+	lex := *p.lexer
+
+	params = []string{}
+	for {
+		tok, err := lex.GetToken()
+		if err != nil {
+			return nil, false
+		}
+
+		if tok.Type == lexer.TokenType_RightParan && len(params) == 0 {
+			break
+		}
+
+		if tok.Type != lexer.TokenType_Label {
+			return nil, false
+		}
+		params = append(params, tok.Value)
+
+		tok, err = lex.GetToken()
+		if err != nil {
+			return nil, false
+		}
+
+		if tok.Type == lexer.TokenType_RightParan {
+			break
+		}
+
+		if tok.Type != lexer.TokenType_Comma {
+			return nil, false
+		}
+	}
+
+	tok, err := lex.GetToken()
+	if err != nil || tok.Type != lexer.TokenType_Arrow {
+		return nil, false
+	}
+
+	*p.lexer = lex
+	return params, true
+}
+
+// parseLambda parses the body of a lambda whose parameters and arrow have
+// already been consumed.
+func (p *Parser) parseLambda(params []string) (expr Expr, err error) {
+	seen := make(map[string]bool, len(params))
+	for _, param := range params {
+		if param == "_" {
+			err = fmt.Errorf("cannot bind reserved variable _")
+			return
+		}
+
+		if seen[param] {
+			err = fmt.Errorf("duplicate lambda parameter %s", param)
+			return
+		}
+		seen[param] = true
+	}
+
+	// The parameters are declared while parsing the body only
+	restores := make([]func(), 0, len(params))
+	for _, param := range params {
+		restores = append(restores, p.declareVariable(param))
+	}
+
+	body, err := p.Parse()
+	for i := len(restores) - 1; i >= 0; i-- {
+		restores[i]()
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to parse lambda body: %w", err)
+		return
+	}
+
+	return ExprLambda{
+		Params: params,
+		Body:   body,
 	}, nil
 }
 
+// declareVariable marks name as bound by the query, and as declared for
+// compile-time variable checking, and returns a function that restores the
+// previous declarations.
+func (p *Parser) declareVariable(name string) (restore func()) {
+	return p.bindVariable(name, true)
+}
+
+// declareAccumulator declares the accumulator of reduce(). Unlike
+// declareVariable it does not count as a binding, so a nested reduce() may
+// hide it.
+func (p *Parser) declareAccumulator() (restore func()) {
+	return p.bindVariable(AccumulatorVariable, false)
+}
+
+// bindVariable declares name, recording in bindings whether it was bound by
+// the query, and returns a function that restores the previous declarations.
+func (p *Parser) bindVariable(name string, bound bool) (restore func()) {
+	if p.bindings == nil {
+		p.bindings = make(map[string]bool)
+	}
+
+	wasBound := p.bindings[name]
+	p.bindings[name] = bound
+	if p.variables == nil {
+		return func() {
+			p.bindings[name] = wasBound
+		}
+	}
+
+	declared := p.variables[name]
+	p.variables[name] = true
+	return func() {
+		p.bindings[name] = wasBound
+		p.variables[name] = declared
+	}
+}
+
+// parseNot parses a logical NOT expression.
+// parseNot implements parseFunc.
+func parseNot(p *Parser, _ lexer.Token) (expr Expr, err error) {
+	operand, err := p.parseUnaryOperand()
+	if err != nil {
+		err = fmt.Errorf("failed to parse not operand: %w", err)
+		return
+	}
+
+	return ExprNot{
+		Expr: operand,
+	}, nil
+}
+
+// parseUnaryPlus parses a unary plus expression.
+// parseUnaryPlus implements parseFunc.
+func parseUnaryPlus(p *Parser, _ lexer.Token) (expr Expr, err error) {
+	operand, err := p.parseUnaryOperand()
+	if err != nil {
+		err = fmt.Errorf("failed to parse unary plus operand: %w", err)
+		return
+	}
+
+	return ExprUnaryPlus{
+		Expr: operand,
+	}, nil
+}
+
+// parseUnaryOperand parses the operand of the `!` and unary `+` operators.
+// The operator binds only to the primary expression that follows it,
+// including any indexing or field access, so `!a && b` is parsed as
+// `(!a) && b`. Binary operators after the operand are chained by
+// wrapOperation as usual.
+func (p *Parser) parseUnaryOperand() (Expr, error) {
+	expr, err := p.parsePrimary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse unary operand: %w", err)
+	}
+
+	return expr, nil
+}
+
+// parsePrimary parses a single operand followed by any indexing or field
+// access, without consuming binary operators.
+func (p *Parser) parsePrimary() (Expr, error) {
+	tok, err := p.lexer.GetToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token for operand: %w", err)
+	}
+
+	f, ok := parseMap[tok.Type]
+	if !ok {
+		return nil, fmt.Errorf("unrecognizable token for operand: %s (type: %d)", tok, tok.Type)
+	}
+
+	expr, err := f(p, tok)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.parsePostfix(p.spanFrom(expr, tok.Offset))
+}
+
+// parsePostfix applies any indexing and field access following expr, which
+// bind tighter than any operator.
+func (p *Parser) parsePostfix(expr Expr) (Expr, error) {
+	for {
+		nextTok, err := p.lexer.PeekToken()
+		if errors.Is(io.EOF, err) {
+			return expr, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to peek token: %w", err)
+		}
+
+		switch nextTok.Type {
+		case lexer.TokenType_Dot, lexer.TokenType_OptionalDot:
+			expr, err = p.parseFieldAccess(expr)
+		case lexer.TokenType_LeftBracket, lexer.TokenType_OptionalBracket:
+			expr, err = p.parseIndex(expr)
+		default:
+			return expr, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
 // parseList parses a list literal token.
 // parseList implements parseFunc.
 func parseList(p *Parser, _ lexer.Token) (expr Expr, err error) {
@@ -729,6 +1280,61 @@ func operatorOr(expr1 Expr, expr2 Expr) (op Expr) {
 	}
 }
 
+// operatorCoalesce wraps two expressions in a null-coalescing expression.
+// operatorCoalesce implements operatorFunc.
+func operatorCoalesce(expr1 Expr, expr2 Expr) (op Expr) {
+	return ExprCoalesce{
+		Left:  expr1,
+		Right: expr2,
+	}
+}
+
+// operatorIn wraps two expressions in a membership expression.
+// operatorIn implements operatorFunc.
+func operatorIn(expr1 Expr, expr2 Expr) (op Expr) {
+	return ExprIn{
+		Value:      expr1,
+		Collection: expr2,
+	}
+}
+
+// operatorNotIn wraps two expressions in a negated membership expression.
+// operatorNotIn implements operatorFunc.
+func operatorNotIn(expr1 Expr, expr2 Expr) (op Expr) {
+	return ExprNot{
+		Expr: operatorIn(expr1, expr2),
+	}
+}
+
+// operatorMatch wraps two expressions in a regular expression match.
+// operatorMatch implements operatorFunc.
+func operatorMatch(expr1 Expr, expr2 Expr) (op Expr) {
+	return ExprMatch{
+		Value:   expr1,
+		Pattern: expr2,
+	}
+}
+
+// regexLiteral compiles pattern if it is a string literal, so that an invalid
+// pattern is reported when the query is parsed rather than evaluated.
+func regexLiteral(pattern Expr) (Expr, error) {
+	str, ok := pattern.(ExprString)
+	if !ok {
+		return pattern, nil
+	}
+
+	re, err := regexp.Compile(str.Value)
+	if err != nil {
+		pos := str.Position()
+		return nil, &TokenError{
+			Token: lexer.Token{Type: lexer.TokenType_StringLiteral, Value: str.Value, Offset: pos.Start, End: pos.End},
+			Err:   fmt.Errorf("%w: %v", ErrInvalidRegex, err),
+		}
+	}
+
+	return ExprRegex{Regexp: re, Pos: str.Pos}, nil
+}
+
 // operatorExponent wraps two expressions in an exponent expression.
 // operatorExponent implements operatorFunc.
 func operatorExponent(expr1 Expr, expr2 Expr) (op Expr) {
@@ -747,6 +1353,50 @@ func operatorIntegerDivision(expr1 Expr, expr2 Expr) (op Expr) {
 	}
 }
 
+// parseIndex consumes the left bracket of an indexing operation and parses it
+// as a map index, list index or slice depending on the indexed expression and
+// the index token.
+func (p *Parser) parseIndex(expr Expr) (Expr, error) {
+	// Consume the left bracket, which is optional when written as ?[
+	bracketTok, _ := p.lexer.GetToken()
+	optional := bracketTok.Type == lexer.TokenType_OptionalBracket
+
+	// Peek at the next token to determine operation type
+	nextTok, err := p.lexer.PeekToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to peek token: %w", err)
+	}
+
+	// Use map indexing if:
+	// 1. Expression being indexed is a map or map index
+	// 2. Index is a string literal (including invalid map access on lists)
+	// Otherwise, use list indexing
+	var indexExpr Expr
+	if expr.Type() == ExprType_Map || expr.Type() == ExprType_MapIndex || nextTok.Type == lexer.TokenType_StringLiteral {
+		indexExpr, err = p.parseMapIndex(expr)
+	} else {
+		indexExpr, err = p.parseListIndex(expr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if optional {
+		switch e := indexExpr.(type) {
+		case ExprListIndex:
+			e.Optional = true
+			indexExpr = e
+		case ExprMapIndex:
+			e.Optional = true
+			indexExpr = e
+		default:
+			return nil, &TokenError{Token: bracketTok, Err: fmt.Errorf("slices cannot be optional")}
+		}
+	}
+
+	return p.spanFrom(indexExpr, expr.Position().Start), nil
+}
+
 // parseListIndex parses a list indexing operation.
 func (p *Parser) parseListIndex(listExpr Expr) (expr Expr, err error) {
 	if p == nil {
@@ -803,11 +1453,10 @@ func (p *Parser) parseListIndex(listExpr Expr) (expr Expr, err error) {
 		return
 	}
 
-	// Check for chained indexing (e.g., [1,2,3][0][1])
-	return p.wrapOperation(ExprListIndex{
+	return ExprListIndex{
 		List:  listExpr,
 		Index: indexExpr,
-	})
+	}, nil
 }
 
 // parseListSlice parses a list slicing operation like list[start:end].
@@ -848,15 +1497,11 @@ func (p *Parser) parseListSlice(listExpr Expr, startExpr Expr) (expr Expr, err e
 		return
 	}
 
-	// Create the list slice expression
-	sliceExpr := ExprListSlice{
+	return ExprListSlice{
 		List:  listExpr,
 		Start: startExpr,
 		End:   endExpr,
-	}
-
-	// Check for chained indexing (e.g., [1,2,3][1:3][0])
-	return p.wrapOperation(sliceExpr)
+	}, nil
 }
 
 // parseMapIndex parses a map indexing operation.
@@ -930,39 +1575,89 @@ func (p *Parser) parseMapIndex(mapExpr Expr) (expr Expr, err error) {
 		return
 	}
 
-	// Check for chained indexing (e.g., {"a": {"b": 1}}["a"]["b"])
-	return p.wrapOperation(ExprMapIndex{
+	return ExprMapIndex{
 		Map:   mapExpr,
 		Index: indexExpr,
-	})
+	}, nil
+}
+
+// parseFieldAccess parses a dot-path field access operation like `$.name`.
+func (p *Parser) parseFieldAccess(objectExpr Expr) (expr Expr, err error) {
+	if p == nil {
+		err = fmt.Errorf("parser is nil")
+		return
+	}
+
+	// Consume the dot (already peeked), which is optional when written as ?.
+	dotTok, _ := p.lexer.GetToken()
+
+	// The field name must be a label
+	tok, err := p.lexer.GetToken()
+	if errors.Is(err, io.EOF) {
+		err = fmt.Errorf("%w Label after Dot, got EOF", ErrExpectedToken)
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to get token: %w", err)
+		return
+	}
+
+	// Keywords such as `null` and `true` are still valid field names
+	if tok.Type != lexer.TokenType_Label && tok.Type != lexer.TokenType_Boolean && tok.Type != lexer.TokenType_Null && tok.Type != lexer.TokenType_Let && tok.Type != lexer.TokenType_In {
+		err = fmt.Errorf("%w Label after Dot, got %s", ErrExpectedToken, tok)
+		return
+	}
+
+	return p.spanFrom(ExprFieldAccess{
+		Object:   objectExpr,
+		Field:    tok.Value,
+		Optional: dotTok.Type == lexer.TokenType_OptionalDot,
+	}, objectExpr.Position().Start), nil
 }
 
 // parseLabelOrFunction parses a label token, checking if it's followed by a left parenthesis to determine if it's a function call.
 // parseLabelOrFunction implements parseFunc.
 func parseLabelOrFunction(p *Parser, tok lexer.Token) (expr Expr, err error) {
+	// Peek at the next token to see if it's a left parenthesis
+	nextTok, peekErr := p.lexer.PeekToken()
+	if peekErr != nil && !errors.Is(peekErr, io.EOF) {
+		err = fmt.Errorf("failed to peek token: %w", peekErr)
+		return
+	}
+
 	// Check if this is the special underscore variable
 	if tok.Value == "_" {
+		if nextTok.Type == lexer.TokenType_Arrow {
+			err = &TokenError{Token: tok, Err: fmt.Errorf("cannot bind reserved variable _")}
+			return
+		}
+
 		// This is the special underscore variable used in filter expressions
 		return ExprVariable{
 			Name: "_",
 		}, nil
 	}
 
-	// Peek at the next token to see if it's a left parenthesis
-	nextTok, peekErr := p.lexer.PeekToken()
-	if peekErr != nil && !errors.Is(peekErr, io.EOF) {
-		err = fmt.Errorf("failed to peek token: %w", peekErr)
-		return
-	}
-
 	if nextTok.Type == lexer.TokenType_LeftParan {
 		// This is a function call
-		return p.parseFunction(tok.Value)
+		return p.parseFunction(tok.Value, 0)
 	}
 
-	// This is just a regular label (which should be an error in current grammar)
-	err = fmt.Errorf("%w: %v", ErrUndefinedToken, tok.Value)
-	return
+	if nextTok.Type == lexer.TokenType_Arrow {
+		// This is a single parameter lambda
+		p.lexer.GetToken()
+		return p.parseLambda([]string{tok.Value})
+	}
+
+	// Any other label is a named variable
+	if p.variables != nil && !p.variables[tok.Value] {
+		err = &TokenError{Token: tok, Err: fmt.Errorf("%w: %v", ErrUndefinedVariable, tok.Value)}
+		return
+	}
+
+	return ExprVariable{
+		Name: tok.Value,
+	}, nil
 }
 
 // parseTernary parses a ternary conditional expression.
@@ -995,15 +1690,16 @@ func (p *Parser) parseTernary(conditionExpr Expr) (expr Expr, err error) {
 		return
 	}
 
-	return ExprTernary{
+	return p.spanFrom(ExprTernary{
 		Condition: conditionExpr,
 		TrueExpr:  trueExpr,
 		FalseExpr: falseExpr,
-	}, nil
+	}, conditionExpr.Position().Start), nil
 }
 
-// parseFunction parses a function call with the given name.
-func (p *Parser) parseFunction(functionName string) (expr Expr, err error) {
+// parseFunction parses a function call with the given name. pipedArgs is the
+// number of arguments supplied by a pipe ahead of the parsed arguments.
+func (p *Parser) parseFunction(functionName string, pipedArgs int) (expr Expr, err error) {
 	// Consume the left parenthesis
 	leftParanTok, err := p.lexer.GetToken()
 	if err != nil {
@@ -1034,7 +1730,7 @@ func (p *Parser) parseFunction(functionName string) (expr Expr, err error) {
 	}
 
 	// Parse the first argument
-	firstArg, parseErr := p.Parse()
+	firstArg, parseErr := p.parseFunctionArgument(functionName, pipedArgs+len(args))
 	if parseErr != nil {
 		err = fmt.Errorf("failed to parse first function argument: %w", parseErr)
 		return
@@ -1068,7 +1764,7 @@ func (p *Parser) parseFunction(functionName string) (expr Expr, err error) {
 		p.lexer.GetToken()
 
 		// Parse the next argument
-		nextArg, parseErr := p.Parse()
+		nextArg, parseErr := p.parseFunctionArgument(functionName, pipedArgs+len(args))
 		if parseErr != nil {
 			err = fmt.Errorf("failed to parse function argument: %w", parseErr)
 			return
@@ -1081,3 +1777,44 @@ func (p *Parser) parseFunction(functionName string) (expr Expr, err error) {
 		Args: args,
 	}, nil
 }
+
+// parseFunctionArgument parses the argument at the given position of a call to
+// functionName. The accumulator is declared while parsing the expression
+// passed to reduce(), which must not hide a variable of the same name.
+func (p *Parser) parseFunctionArgument(functionName string, position int) (Expr, error) {
+	if functionName == "reduce" && position == 2 {
+		return p.parseReduceExpression()
+	}
+
+	arg, err := p.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	if regexFunctions[functionName] && position == 1 {
+		return regexLiteral(arg)
+	}
+
+	return arg, nil
+}
+
+// parseReduceExpression parses the expression passed to reduce(), with the
+// accumulator declared. A lambda names its own parameters, but any other
+// expression is an error if the accumulator would hide a variable bound by
+// the query.
+func (p *Parser) parseReduceExpression() (Expr, error) {
+	shadowed := p.bindings[AccumulatorVariable]
+
+	restore := p.declareAccumulator()
+	arg, err := p.Parse()
+	restore()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, isLambda := arg.(ExprLambda); shadowed && !isLambda {
+		return nil, fmt.Errorf("%w: the reduce() accumulator %s hides the variable of the same name, use a lambda such as (total, x) => total + x instead", ErrShadowedVariable, AccumulatorVariable)
+	}
+
+	return arg, nil
+}
@@ -18,6 +18,37 @@ func Test_New(t *testing.T) {
 	}
 }
 
+func Test_ExprNumber_Decode(t *testing.T) {
+	testCases := map[string]struct {
+		value       decimal.Decimal
+		expected    float64
+		expectError bool
+	}{
+		"exact":        {value: decimal.RequireFromString("1.5"), expected: 1.5},
+		"inexact":      {value: decimal.RequireFromString("0.1"), expected: 0.1},
+		"repeating":    {value: decimal.NewFromInt(1).Div(decimal.NewFromInt(3)), expected: 1.0 / 3},
+		"out of range": {value: decimal.New(1, 400), expectError: true},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			decoded, err := ExprNumber{Value: tc.value}.Decode()
+			if tc.expectError {
+				if err == nil {
+					t.Fatalf("Expected error but got %v", decoded)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if decoded != tc.expected {
+				t.Fatalf("Expected %v, got %v", tc.expected, decoded)
+			}
+		})
+	}
+}
+
 func Test_Parser_Parse(t *testing.T) {
 	testCases := map[string]struct {
 		input    string
@@ -96,6 +127,21 @@ func Test_Parser_Parse(t *testing.T) {
 				}
 			},
 		},
+		"Null": {
+			input: "null",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				if expr.Type() != ExprType_Null {
+					t.Fatalf("Expected Null type, got %d", expr.Type())
+				}
+				_, ok := expr.(ExprNull)
+				if !ok {
+					t.Fatalf("Expected ExprNull, got %T", expr)
+				}
+			},
+		},
 		"Input": {
 			input: "$",
 			validate: func(expr Expr, err error) {
@@ -1204,3 +1250,1332 @@ func Test_Parser_Parse_ListSlice(t *testing.T) {
 		})
 	}
 }
+
+func Test_Parser_Parse_FieldAccess(t *testing.T) {
+	testCases := map[string]struct {
+		input    string
+		validate func(Expr, error)
+	}{
+		"Input field access": {
+			input: "$.name",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				if expr.Type() != ExprType_FieldAccess {
+					t.Fatalf("Expected FieldAccess type, got %d", expr.Type())
+				}
+				fieldAccess, ok := expr.(ExprFieldAccess)
+				if !ok {
+					t.Fatalf("Expected ExprFieldAccess, got %T", expr)
+				}
+				if fieldAccess.Object.Type() != ExprType_Input {
+					t.Fatalf("Expected Input object, got %d", fieldAccess.Object.Type())
+				}
+				if fieldAccess.Field != "name" {
+					t.Fatalf("Expected field name, got %s", fieldAccess.Field)
+				}
+			},
+		},
+		"Chained field access": {
+			input: "$.user.name",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				fieldAccess, ok := expr.(ExprFieldAccess)
+				if !ok {
+					t.Fatalf("Expected ExprFieldAccess, got %T", expr)
+				}
+				if fieldAccess.Field != "name" {
+					t.Fatalf("Expected field name, got %s", fieldAccess.Field)
+				}
+				inner, ok := fieldAccess.Object.(ExprFieldAccess)
+				if !ok {
+					t.Fatalf("Expected nested ExprFieldAccess, got %T", fieldAccess.Object)
+				}
+				if inner.Field != "user" {
+					t.Fatalf("Expected field user, got %s", inner.Field)
+				}
+			},
+		},
+		"Field access mixed with indexing": {
+			input: "$.items[0].price",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				fieldAccess, ok := expr.(ExprFieldAccess)
+				if !ok {
+					t.Fatalf("Expected ExprFieldAccess, got %T", expr)
+				}
+				if fieldAccess.Object.Type() != ExprType_ListIndex {
+					t.Fatalf("Expected ListIndex object, got %d", fieldAccess.Object.Type())
+				}
+			},
+		},
+		"Field access in arithmetic": {
+			input: "$.price * 2",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				multiply, ok := expr.(ExprMultiply)
+				if !ok {
+					t.Fatalf("Expected ExprMultiply, got %T", expr)
+				}
+				if multiply.Expr1.Type() != ExprType_FieldAccess {
+					t.Fatalf("Expected FieldAccess operand, got %d", multiply.Expr1.Type())
+				}
+			},
+		},
+		"Field access as right arithmetic operand": {
+			input: "2 * $.price",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				multiply, ok := expr.(ExprMultiply)
+				if !ok {
+					t.Fatalf("Expected ExprMultiply, got %T", expr)
+				}
+				if multiply.Expr2.Type() != ExprType_FieldAccess {
+					t.Fatalf("Expected FieldAccess operand, got %d", multiply.Expr2.Type())
+				}
+			},
+		},
+		"Missing field name": {
+			input: "$.",
+			validate: func(expr Expr, err error) {
+				if !errors.Is(err, ErrExpectedToken) {
+					t.Fatalf("Expected ErrExpectedToken, got %v", err)
+				}
+			},
+		},
+		"Invalid field name": {
+			input: "$.[0]",
+			validate: func(expr Expr, err error) {
+				if !errors.Is(err, ErrExpectedToken) {
+					t.Fatalf("Expected ErrExpectedToken, got %v", err)
+				}
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			lex := lexer.New(tc.input)
+			parser := New(lex)
+			expr, err := parser.Parse()
+			tc.validate(expr, err)
+		})
+	}
+}
+
+func Test_Parser_Parse_Unary(t *testing.T) {
+	testCases := map[string]struct {
+		input    string
+		validate func(Expr, error)
+	}{
+		"Not": {
+			input: "!true",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				not, ok := expr.(ExprNot)
+				if !ok {
+					t.Fatalf("Expected ExprNot, got %T", expr)
+				}
+				if not.Expr.Type() != ExprType_Boolean {
+					t.Fatalf("Expected Boolean operand, got %s", not.Expr)
+				}
+			},
+		},
+		"Not binds before binary operator": {
+			input: "!true && false",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				and, ok := expr.(ExprAnd)
+				if !ok {
+					t.Fatalf("Expected ExprAnd, got %T", expr)
+				}
+				if and.Expr1.Type() != ExprType_Not {
+					t.Fatalf("Expected Not as first operand, got %s", and.Expr1)
+				}
+			},
+		},
+		"Not includes field access and indexing": {
+			input: "!$.flags[0]",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				not, ok := expr.(ExprNot)
+				if !ok {
+					t.Fatalf("Expected ExprNot, got %T", expr)
+				}
+				if not.Expr.Type() != ExprType_ListIndex {
+					t.Fatalf("Expected ListIndex operand, got %s", not.Expr)
+				}
+			},
+		},
+		"Unary plus binds before arithmetic": {
+			input: "+2 * 3",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				multiply, ok := expr.(ExprMultiply)
+				if !ok {
+					t.Fatalf("Expected ExprMultiply, got %T", expr)
+				}
+				if multiply.Expr1.Type() != ExprType_UnaryPlus {
+					t.Fatalf("Expected UnaryPlus as first operand, got %s", multiply.Expr1)
+				}
+			},
+		},
+		"Not without operand": {
+			input: "!",
+			validate: func(expr Expr, err error) {
+				if err == nil {
+					t.Fatalf("Expected error but got none")
+				}
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			lex := lexer.New(tc.input)
+			parser := New(lex)
+			expr, err := parser.Parse()
+			tc.validate(expr, err)
+		})
+	}
+}
+
+func Test_Parser_ParseQuery(t *testing.T) {
+	testCases := map[string]struct {
+		input       string
+		expectError bool
+	}{
+		"single expression":        {input: "1 + 2"},
+		"let binding":              {input: "let x = 1; x"},
+		"trailing whitespace":      {input: "$.a \n"},
+		"trailing assignment":      {input: "$.a = 1", expectError: true},
+		"trailing number":          {input: "1 2", expectError: true},
+		"trailing statement":       {input: "let x = 1; x; 5", expectError: true},
+		"trailing closing bracket": {input: "[1])", expectError: true},
+		"trailing semicolon":       {input: "$.a;", expectError: true},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			lex := lexer.New(tc.input)
+			parser := New(lex)
+			_, err := parser.ParseQuery()
+			if !tc.expectError {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrExpectedToken) {
+				t.Fatalf("Expected ErrExpectedToken, got %v", err)
+			}
+		})
+	}
+}
+
+func Test_Parser_Parse_StandardPrecedence(t *testing.T) {
+	testCases := map[string]struct {
+		input    string
+		validate func(Expr, error)
+	}{
+		"Multiplication binds tighter than addition": {
+			input: "2 + 3 * 4",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				add, ok := expr.(ExprAdd)
+				if !ok {
+					t.Fatalf("Expected ExprAdd, got %T", expr)
+				}
+				if add.Expr2.Type() != ExprType_Multiply {
+					t.Fatalf("Expected Multiply as second operand, got %s", add.Expr2)
+				}
+			},
+		},
+		"Subtraction is left-associative": {
+			input: "10 - 3 - 2",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				subtract, ok := expr.(ExprSubtract)
+				if !ok {
+					t.Fatalf("Expected ExprSubtract, got %T", expr)
+				}
+				if subtract.Expr1.Type() != ExprType_Subtract {
+					t.Fatalf("Expected Subtract as first operand, got %s", subtract.Expr1)
+				}
+			},
+		},
+		"Exponent binds tighter than multiplication": {
+			input: "2 * 3 ^ 2",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				multiply, ok := expr.(ExprMultiply)
+				if !ok {
+					t.Fatalf("Expected ExprMultiply, got %T", expr)
+				}
+				if multiply.Expr2.Type() != ExprType_Exponent {
+					t.Fatalf("Expected Exponent as second operand, got %s", multiply.Expr2)
+				}
+			},
+		},
+		"And binds tighter than or": {
+			input: "true || false && false",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				or, ok := expr.(ExprOr)
+				if !ok {
+					t.Fatalf("Expected ExprOr, got %T", expr)
+				}
+				if or.Expr2.Type() != ExprType_And {
+					t.Fatalf("Expected And as second operand, got %s", or.Expr2)
+				}
+			},
+		},
+		"Ternary binds loosest": {
+			input: "1 + 1 == 2 ? 1 : 0",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				ternary, ok := expr.(ExprTernary)
+				if !ok {
+					t.Fatalf("Expected ExprTernary, got %T", expr)
+				}
+				if ternary.Condition.Type() != ExprType_Equals {
+					t.Fatalf("Expected Equals condition, got %s", ternary.Condition)
+				}
+			},
+		},
+		"Missing operand": {
+			input: "2 * ",
+			validate: func(expr Expr, err error) {
+				if err == nil {
+					t.Fatalf("Expected error but got none")
+				}
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			lex := lexer.New(tc.input)
+			parser := NewWithOptions(lex, Options{Precedence: PrecedenceStandard})
+			expr, err := parser.Parse()
+			tc.validate(expr, err)
+		})
+	}
+}
+
+func Test_Parser_Parse_Variable(t *testing.T) {
+	testCases := map[string]struct {
+		input     string
+		variables []string
+		validate  func(Expr, error)
+	}{
+		"Named variable": {
+			input: "threshold",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				variable, ok := expr.(ExprVariable)
+				if !ok {
+					t.Fatalf("Expected ExprVariable, got %T", expr)
+				}
+				if variable.Name != "threshold" {
+					t.Fatalf("Expected name threshold, got %s", variable.Name)
+				}
+			},
+		},
+		"Named variable with field access": {
+			input: "user.name",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				fieldAccess, ok := expr.(ExprFieldAccess)
+				if !ok {
+					t.Fatalf("Expected ExprFieldAccess, got %T", expr)
+				}
+				if fieldAccess.Object.Type() != ExprType_Variable {
+					t.Fatalf("Expected Variable object, got %s", fieldAccess.Object)
+				}
+			},
+		},
+		"Declared variable": {
+			input:     "$.total > threshold",
+			variables: []string{"threshold"},
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+			},
+		},
+		"Underscore is always declared": {
+			input:     "_",
+			variables: []string{},
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+			},
+		},
+		"Accumulator declared inside reduce expression": {
+			input:     "reduce($.items, 0, acc + _)",
+			variables: []string{},
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+			},
+		},
+		"Accumulator undeclared outside reduce expression": {
+			input:     "reduce($.items, acc, _)",
+			variables: []string{},
+			validate: func(expr Expr, err error) {
+				if !errors.Is(err, ErrUndefinedVariable) {
+					t.Fatalf("Expected ErrUndefinedVariable, got %v", err)
+				}
+			},
+		},
+		"Accumulator undeclared after reduce": {
+			input:     "reduce($.items, 0, acc + _) + acc",
+			variables: []string{},
+			validate: func(expr Expr, err error) {
+				if !errors.Is(err, ErrUndefinedVariable) {
+					t.Fatalf("Expected ErrUndefinedVariable, got %v", err)
+				}
+			},
+		},
+		"Undeclared variable": {
+			input:     "$.total > limit",
+			variables: []string{"threshold"},
+			validate: func(expr Expr, err error) {
+				if !errors.Is(err, ErrUndefinedVariable) {
+					t.Fatalf("Expected ErrUndefinedVariable, got %v", err)
+				}
+			},
+		},
+		"Undeclared variable in function argument": {
+			input:     "len(items)",
+			variables: []string{},
+			validate: func(expr Expr, err error) {
+				if !errors.Is(err, ErrUndefinedVariable) {
+					t.Fatalf("Expected ErrUndefinedVariable, got %v", err)
+				}
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			lex := lexer.New(tc.input)
+			parser := NewWithOptions(lex, Options{Variables: tc.variables})
+			expr, err := parser.Parse()
+			tc.validate(expr, err)
+		})
+	}
+}
+
+func Test_Parser_Parse_Let(t *testing.T) {
+	testCases := map[string]struct {
+		input     string
+		variables []string
+		validate  func(Expr, error)
+	}{
+		"Let binding": {
+			input: "let x = 1 + 2; x * 2",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				let, ok := expr.(ExprLet)
+				if !ok {
+					t.Fatalf("Expected ExprLet, got %T", expr)
+				}
+				if let.Name != "x" {
+					t.Fatalf("Expected name x, got %s", let.Name)
+				}
+				if let.Value.Type() != ExprType_Add {
+					t.Fatalf("Expected Add value, got %s", let.Value)
+				}
+				if let.Body.Type() != ExprType_Multiply {
+					t.Fatalf("Expected Multiply body, got %s", let.Body)
+				}
+			},
+		},
+		"Nested let bindings": {
+			input: "let x = 1; let y = x; y",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				let, ok := expr.(ExprLet)
+				if !ok {
+					t.Fatalf("Expected ExprLet, got %T", expr)
+				}
+				if let.Body.Type() != ExprType_Let {
+					t.Fatalf("Expected Let body, got %s", let.Body)
+				}
+			},
+		},
+		"Let bound name is declared in body": {
+			input:     "let big = threshold * 2; big",
+			variables: []string{"threshold"},
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+			},
+		},
+		"Let bound name is not declared outside body": {
+			input:     "(let x = 1; x) + x",
+			variables: []string{},
+			validate: func(expr Expr, err error) {
+				if !errors.Is(err, ErrUndefinedVariable) {
+					t.Fatalf("Expected ErrUndefinedVariable, got %v", err)
+				}
+			},
+		},
+		"Let bound name is not declared in its own value": {
+			input:     "let x = x; x",
+			variables: []string{},
+			validate: func(expr Expr, err error) {
+				if !errors.Is(err, ErrUndefinedVariable) {
+					t.Fatalf("Expected ErrUndefinedVariable, got %v", err)
+				}
+			},
+		},
+		"Missing name": {
+			input: "let = 1; 2",
+			validate: func(expr Expr, err error) {
+				if !errors.Is(err, ErrExpectedToken) {
+					t.Fatalf("Expected ErrExpectedToken, got %v", err)
+				}
+			},
+		},
+		"Missing assign": {
+			input: "let x 1; x",
+			validate: func(expr Expr, err error) {
+				if !errors.Is(err, ErrExpectedToken) {
+					t.Fatalf("Expected ErrExpectedToken, got %v", err)
+				}
+			},
+		},
+		"Missing semicolon": {
+			input: "let x = 1 x",
+			validate: func(expr Expr, err error) {
+				if !errors.Is(err, ErrExpectedToken) {
+					t.Fatalf("Expected ErrExpectedToken, got %v", err)
+				}
+			},
+		},
+		"Missing body": {
+			input: "let x = 1;",
+			validate: func(expr Expr, err error) {
+				if err == nil {
+					t.Fatalf("Expected error but got none")
+				}
+			},
+		},
+		"Reserved underscore": {
+			input: "let _ = 1; _",
+			validate: func(expr Expr, err error) {
+				if err == nil {
+					t.Fatalf("Expected error but got none")
+				}
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			lex := lexer.New(tc.input)
+			parser := NewWithOptions(lex, Options{Variables: tc.variables})
+			expr, err := parser.Parse()
+			tc.validate(expr, err)
+		})
+	}
+}
+
+func Test_Parser_Parse_Lambda(t *testing.T) {
+	testCases := map[string]struct {
+		input     string
+		variables []string
+		validate  func(Expr, error)
+	}{
+		"Single parameter": {
+			input: "filter($, o => o.total > 10)",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				function, ok := expr.(ExprFunction)
+				if !ok {
+					t.Fatalf("Expected ExprFunction, got %T", expr)
+				}
+				lambda, ok := function.Args[1].(ExprLambda)
+				if !ok {
+					t.Fatalf("Expected ExprLambda, got %T", function.Args[1])
+				}
+				if len(lambda.Params) != 1 || lambda.Params[0] != "o" {
+					t.Fatalf("Expected params [o], got %v", lambda.Params)
+				}
+				if lambda.Body.Type() != ExprType_GreaterThan {
+					t.Fatalf("Expected GreaterThan body, got %s", lambda.Body)
+				}
+			},
+		},
+		"Parenthesized parameters": {
+			input: "(acc, x) => acc + x",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				lambda, ok := expr.(ExprLambda)
+				if !ok {
+					t.Fatalf("Expected ExprLambda, got %T", expr)
+				}
+				if len(lambda.Params) != 2 || lambda.Params[0] != "acc" || lambda.Params[1] != "x" {
+					t.Fatalf("Expected params [acc x], got %v", lambda.Params)
+				}
+			},
+		},
+		"Single parenthesized parameter": {
+			input: "(o) => o",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				lambda, ok := expr.(ExprLambda)
+				if !ok {
+					t.Fatalf("Expected ExprLambda, got %T", expr)
+				}
+				if len(lambda.Params) != 1 {
+					t.Fatalf("Expected 1 param, got %v", lambda.Params)
+				}
+			},
+		},
+		"No parameters": {
+			input: "() => 1",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				lambda, ok := expr.(ExprLambda)
+				if !ok {
+					t.Fatalf("Expected ExprLambda, got %T", expr)
+				}
+				if len(lambda.Params) != 0 {
+					t.Fatalf("Expected no params, got %v", lambda.Params)
+				}
+			},
+		},
+		"Parenthesized label is still a block": {
+			input: "(x) + 1",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				if expr.Type() != ExprType_Add {
+					t.Fatalf("Expected Add, got %s", expr)
+				}
+			},
+		},
+		"Nested lambdas": {
+			input: `filter($.orders, o => any(o.items, i => i.sku == "X"))`,
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				function := expr.(ExprFunction)
+				lambda := function.Args[1].(ExprLambda)
+				inner, ok := lambda.Body.(ExprFunction)
+				if !ok || inner.Name != "any" {
+					t.Fatalf("Expected any() body, got %s", lambda.Body)
+				}
+				if inner.Args[1].Type() != ExprType_Lambda {
+					t.Fatalf("Expected Lambda argument, got %s", inner.Args[1])
+				}
+			},
+		},
+		"Parameters are declared in body": {
+			input:     `filter($.orders, o => any(o.items, i => i.price > o.limit))`,
+			variables: []string{},
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+			},
+		},
+		"Parameters are not declared outside body": {
+			input:     `len(filter($, o => o > 1)) + o`,
+			variables: []string{},
+			validate: func(expr Expr, err error) {
+				if !errors.Is(err, ErrUndefinedVariable) {
+					t.Fatalf("Expected ErrUndefinedVariable, got %v", err)
+				}
+			},
+		},
+		"Reserved underscore": {
+			input: "_ => 1",
+			validate: func(expr Expr, err error) {
+				if err == nil {
+					t.Fatalf("Expected error but got none")
+				}
+			},
+		},
+		"Reserved underscore in parameter list": {
+			input: "(a, _) => a",
+			validate: func(expr Expr, err error) {
+				if err == nil {
+					t.Fatalf("Expected error but got none")
+				}
+			},
+		},
+		"Duplicate parameter": {
+			input: "(a, a) => a",
+			validate: func(expr Expr, err error) {
+				if err == nil {
+					t.Fatalf("Expected error but got none")
+				}
+			},
+		},
+		"Missing body": {
+			input: "o =>",
+			validate: func(expr Expr, err error) {
+				if err == nil {
+					t.Fatalf("Expected error but got none")
+				}
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			lex := lexer.New(tc.input)
+			parser := NewWithOptions(lex, Options{Variables: tc.variables})
+			expr, err := parser.Parse()
+			tc.validate(expr, err)
+		})
+	}
+}
+
+func Test_Parser_Parse_Pipe(t *testing.T) {
+	testCases := map[string]struct {
+		input      string
+		precedence Precedence
+		variables  []string
+		validate   func(Expr, error)
+	}{
+		"Pipe into function": {
+			input: "$.items | len()",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				pipe, ok := expr.(ExprPipe)
+				if !ok {
+					t.Fatalf("Expected ExprPipe, got %T", expr)
+				}
+				if pipe.Value.Type() != ExprType_FieldAccess {
+					t.Fatalf("Expected FieldAccess value, got %s", pipe.Value)
+				}
+				if pipe.Call.Name != "len" || len(pipe.Call.Args) != 0 {
+					t.Fatalf("Expected len() call, got %s with %d args", pipe.Call.Name, len(pipe.Call.Args))
+				}
+			},
+		},
+		"Chained pipes are left-associative": {
+			input: "$ | map(_ * 2) | filter(_ > 1) | sort()",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				pipe, ok := expr.(ExprPipe)
+				if !ok {
+					t.Fatalf("Expected ExprPipe, got %T", expr)
+				}
+				if pipe.Call.Name != "sort" {
+					t.Fatalf("Expected outer call sort, got %s", pipe.Call.Name)
+				}
+				inner, ok := pipe.Value.(ExprPipe)
+				if !ok || inner.Call.Name != "filter" || len(inner.Call.Args) != 1 {
+					t.Fatalf("Expected piped filter() value, got %s", pipe.Value)
+				}
+			},
+		},
+		"Pipe applies to arithmetic on its left": {
+			input: "1 + 2 | abs()",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				pipe, ok := expr.(ExprPipe)
+				if !ok {
+					t.Fatalf("Expected ExprPipe, got %T", expr)
+				}
+				if pipe.Value.Type() != ExprType_Add {
+					t.Fatalf("Expected Add value, got %s", pipe.Value)
+				}
+			},
+		},
+		"Pipe applies to arithmetic on its left in standard mode": {
+			input:      "1 + 2 * 3 | abs()",
+			precedence: PrecedenceStandard,
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				pipe, ok := expr.(ExprPipe)
+				if !ok {
+					t.Fatalf("Expected ExprPipe, got %T", expr)
+				}
+				if pipe.Value.Type() != ExprType_Add {
+					t.Fatalf("Expected Add value, got %s", pipe.Value)
+				}
+			},
+		},
+		"Comparison applies to pipe result": {
+			input: "$ | len() > 2",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				greaterThan, ok := expr.(ExprGreaterThan)
+				if !ok {
+					t.Fatalf("Expected ExprGreaterThan, got %T", expr)
+				}
+				if greaterThan.Expr1.Type() != ExprType_Pipe {
+					t.Fatalf("Expected Pipe as first operand, got %s", greaterThan.Expr1)
+				}
+			},
+		},
+		"Comparison applies to pipe result in standard mode": {
+			input:      "$ | len() > 2 && true",
+			precedence: PrecedenceStandard,
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				and, ok := expr.(ExprAnd)
+				if !ok {
+					t.Fatalf("Expected ExprAnd, got %T", expr)
+				}
+				greaterThan, ok := and.Expr1.(ExprGreaterThan)
+				if !ok || greaterThan.Expr1.Type() != ExprType_Pipe {
+					t.Fatalf("Expected Pipe compared in first operand, got %s", and.Expr1)
+				}
+			},
+		},
+		"Indexing applies to pipe result": {
+			input:      "$ | sort()[0]",
+			precedence: PrecedenceStandard,
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				listIndex, ok := expr.(ExprListIndex)
+				if !ok {
+					t.Fatalf("Expected ExprListIndex, got %T", expr)
+				}
+				if listIndex.List.Type() != ExprType_Pipe {
+					t.Fatalf("Expected Pipe as indexed list, got %s", listIndex.List)
+				}
+			},
+		},
+		"Accumulator declared in piped reduce": {
+			input:     "$ | reduce(0, acc + _)",
+			variables: []string{},
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+			},
+		},
+		"Pipe requires function call": {
+			input: "$ | len",
+			validate: func(expr Expr, err error) {
+				if !errors.Is(err, ErrExpectedToken) {
+					t.Fatalf("Expected ErrExpectedToken, got %v", err)
+				}
+			},
+		},
+		"Pipe requires right-hand side": {
+			input: "$ |",
+			validate: func(expr Expr, err error) {
+				if !errors.Is(err, ErrExpectedToken) {
+					t.Fatalf("Expected ErrExpectedToken, got %v", err)
+				}
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			lex := lexer.New(tc.input)
+			parser := NewWithOptions(lex, Options{Precedence: tc.precedence, Variables: tc.variables})
+			expr, err := parser.Parse()
+			tc.validate(expr, err)
+		})
+	}
+}
+
+func Test_Parser_Parse_Optional(t *testing.T) {
+	testCases := map[string]struct {
+		input      string
+		precedence Precedence
+		validate   func(Expr, error)
+	}{
+		"Optional field access": {
+			input: "$.a?.b",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				field, ok := expr.(ExprFieldAccess)
+				if !ok || !field.Optional || field.Field != "b" {
+					t.Fatalf("Expected optional access of b, got %#v", expr)
+				}
+				inner, ok := field.Object.(ExprFieldAccess)
+				if !ok || inner.Optional {
+					t.Fatalf("Expected required access of a, got %#v", field.Object)
+				}
+			},
+		},
+		"Optional map index": {
+			input: `$["a"]?["b"]`,
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				index, ok := expr.(ExprMapIndex)
+				if !ok || !index.Optional {
+					t.Fatalf("Expected optional map index, got %#v", expr)
+				}
+				if inner, ok := index.Map.(ExprMapIndex); !ok || inner.Optional {
+					t.Fatalf("Expected required map index, got %#v", index.Map)
+				}
+			},
+		},
+		"Optional list index in arithmetic": {
+			input: "1 + $.items?[0]",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				add, ok := expr.(ExprAdd)
+				if !ok {
+					t.Fatalf("Expected ExprAdd, got %T", expr)
+				}
+				if index, ok := add.Expr2.(ExprListIndex); !ok || !index.Optional {
+					t.Fatalf("Expected optional list index, got %#v", add.Expr2)
+				}
+			},
+		},
+		"Optional slice is rejected": {
+			input: "$.items?[0:1]",
+			validate: func(expr Expr, err error) {
+				var tokenErr *TokenError
+				if !errors.As(err, &tokenErr) || tokenErr.Token.Type != lexer.TokenType_OptionalBracket {
+					t.Fatalf("Expected TokenError at ?[, got %v", err)
+				}
+			},
+		},
+		"Coalesce": {
+			input: "$.discount ?? 0",
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				coalesce, ok := expr.(ExprCoalesce)
+				if !ok {
+					t.Fatalf("Expected ExprCoalesce, got %T", expr)
+				}
+				if coalesce.Left.Type() != ExprType_FieldAccess || coalesce.Right.Type() != ExprType_Number {
+					t.Fatalf("Unexpected operands %s and %s", coalesce.Left, coalesce.Right)
+				}
+			},
+		},
+		"Coalesce binds loosest in standard precedence": {
+			input:      "$.a ?? $.b || $.c",
+			precedence: PrecedenceStandard,
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				coalesce, ok := expr.(ExprCoalesce)
+				if !ok {
+					t.Fatalf("Expected ExprCoalesce, got %T", expr)
+				}
+				if coalesce.Right.Type() != ExprType_Or {
+					t.Fatalf("Expected Or on the right, got %s", coalesce.Right)
+				}
+			},
+		},
+		"Coalesce before ternary in standard precedence": {
+			input:      "$.a ?? false ? 1 : 2",
+			precedence: PrecedenceStandard,
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				ternary, ok := expr.(ExprTernary)
+				if !ok || ternary.Condition.Type() != ExprType_Coalesce {
+					t.Fatalf("Expected ternary with coalesce condition, got %#v", expr)
+				}
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			lex := lexer.New(tc.input)
+			tc.validate(NewWithOptions(lex, Options{Precedence: tc.precedence}).Parse())
+		})
+	}
+}
+
+func Test_Parser_Parse_In(t *testing.T) {
+	testCases := map[string]struct {
+		input      string
+		precedence Precedence
+		validate   func(Expr, error)
+	}{
+		"In": {
+			input: `$.status in ["open", "pending"]`,
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				in, ok := expr.(ExprIn)
+				if !ok {
+					t.Fatalf("Expected ExprIn, got %T", expr)
+				}
+				if in.Value.Type() != ExprType_FieldAccess || in.Collection.Type() != ExprType_List {
+					t.Fatalf("Unexpected operands %s and %s", in.Value, in.Collection)
+				}
+			},
+		},
+		"Not in": {
+			input: `$.status not in ["closed"]`,
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				not, ok := expr.(ExprNot)
+				if !ok {
+					t.Fatalf("Expected ExprNot, got %T", expr)
+				}
+				if not.Expr.Type() != ExprType_In {
+					t.Fatalf("Expected negated ExprIn, got %s", not.Expr)
+				}
+			},
+		},
+		"In chains with logical operators": {
+			input: `$.status in ["open"] && $.paid`,
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				and, ok := expr.(ExprAnd)
+				if !ok {
+					t.Fatalf("Expected ExprAnd, got %T", expr)
+				}
+				if and.Expr1.Type() != ExprType_In || and.Expr2.Type() != ExprType_FieldAccess {
+					t.Fatalf("Unexpected operands %s and %s", and.Expr1, and.Expr2)
+				}
+			},
+		},
+		"Not in on right of logical operator": {
+			input: `$.paid || $.status not in ["closed"]`,
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				or, ok := expr.(ExprOr)
+				if !ok || or.Expr2.Type() != ExprType_Not {
+					t.Fatalf("Expected ExprOr with negated membership, got %#v", expr)
+				}
+			},
+		},
+		"In collection takes indexing": {
+			input: `"a" in $.tags[0] ? 1 : 2`,
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				ternary, ok := expr.(ExprTernary)
+				if !ok {
+					t.Fatalf("Expected ExprTernary, got %T", expr)
+				}
+				in, ok := ternary.Condition.(ExprIn)
+				if !ok || in.Collection.Type() != ExprType_ListIndex {
+					t.Fatalf("Expected membership in indexed list, got %#v", ternary.Condition)
+				}
+			},
+		},
+		"In binds like comparisons in standard precedence": {
+			input:      `1 + 2 in $.a && true`,
+			precedence: PrecedenceStandard,
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				and, ok := expr.(ExprAnd)
+				if !ok {
+					t.Fatalf("Expected ExprAnd, got %T", expr)
+				}
+				in, ok := and.Expr1.(ExprIn)
+				if !ok || in.Value.Type() != ExprType_Add {
+					t.Fatalf("Expected membership of a sum, got %#v", and.Expr1)
+				}
+			},
+		},
+		"In is a valid field name": {
+			input: `$.in`,
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				if field, ok := expr.(ExprFieldAccess); !ok || field.Field != "in" {
+					t.Fatalf("Expected access of field in, got %#v", expr)
+				}
+			},
+		},
+		"Missing collection": {
+			input: `$.status in`,
+			validate: func(expr Expr, err error) {
+				if err == nil {
+					t.Fatalf("Expected error, got %s", expr)
+				}
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			lex := lexer.New(tc.input)
+			tc.validate(NewWithOptions(lex, Options{Precedence: tc.precedence}).Parse())
+		})
+	}
+}
+
+func Test_Parser_Parse_Regex(t *testing.T) {
+	testCases := map[string]struct {
+		input      string
+		precedence Precedence
+		validate   func(Expr, error)
+	}{
+		"Match literal is compiled": {
+			input: `$.email =~ "@example\\.com$"`,
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				match, ok := expr.(ExprMatch)
+				if !ok {
+					t.Fatalf("Expected ExprMatch, got %T", expr)
+				}
+				regex, ok := match.Pattern.(ExprRegex)
+				if !ok || regex.Regexp.String() != `@example\.com$` {
+					t.Fatalf("Expected compiled pattern, got %#v", match.Pattern)
+				}
+			},
+		},
+		"Match chains with logical operators": {
+			input: `$.name =~ "^a" && $.active`,
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				and, ok := expr.(ExprAnd)
+				if !ok || and.Expr1.Type() != ExprType_Match {
+					t.Fatalf("Expected ExprAnd with match, got %#v", expr)
+				}
+			},
+		},
+		"Match with dynamic pattern": {
+			input:      `$.name =~ $.pattern`,
+			precedence: PrecedenceStandard,
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				match, ok := expr.(ExprMatch)
+				if !ok || match.Pattern.Type() != ExprType_FieldAccess {
+					t.Fatalf("Expected uncompiled pattern, got %#v", expr)
+				}
+			},
+		},
+		"Function literal is compiled": {
+			input: `replace_re($.name, "\\s+", " ")`,
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				function, ok := expr.(ExprFunction)
+				if !ok || len(function.Args) != 3 {
+					t.Fatalf("Expected replace_re() call, got %#v", expr)
+				}
+				if function.Args[1].Type() != ExprType_Regex {
+					t.Fatalf("Expected compiled pattern, got %s", function.Args[1])
+				}
+				if function.Args[2].Type() != ExprType_String {
+					t.Fatalf("Expected replacement string, got %s", function.Args[2])
+				}
+			},
+		},
+		"Piped literal is compiled": {
+			input: `$.name | find_all("[a-z]+")`,
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				pipe, ok := expr.(ExprPipe)
+				if !ok || pipe.Call.Args[0].Type() != ExprType_Regex {
+					t.Fatalf("Expected compiled piped pattern, got %#v", expr)
+				}
+			},
+		},
+		"Other functions keep strings": {
+			input: `contains($.name, "[")`,
+			validate: func(expr Expr, err error) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				function, ok := expr.(ExprFunction)
+				if !ok || function.Args[1].Type() != ExprType_String {
+					t.Fatalf("Expected string argument, got %#v", expr)
+				}
+			},
+		},
+		"Invalid match literal": {
+			input: `$.name =~ "(["`,
+			validate: func(expr Expr, err error) {
+				var tokenErr *TokenError
+				if !errors.As(err, &tokenErr) || !errors.Is(err, ErrInvalidRegex) {
+					t.Fatalf("Expected ErrInvalidRegex TokenError, got %v", err)
+				}
+				if tokenErr.Token.Offset != 10 || tokenErr.Token.End != 14 {
+					t.Fatalf("Expected token at 10-14, got %d-%d", tokenErr.Token.Offset, tokenErr.Token.End)
+				}
+			},
+		},
+		"Invalid function literal in standard precedence": {
+			input:      `capture($.name, "(?P<x")`,
+			precedence: PrecedenceStandard,
+			validate: func(expr Expr, err error) {
+				if !errors.Is(err, ErrInvalidRegex) {
+					t.Fatalf("Expected ErrInvalidRegex, got %v", err)
+				}
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			lex := lexer.New(tc.input)
+			tc.validate(NewWithOptions(lex, Options{Precedence: tc.precedence}).Parse())
+		})
+	}
+}
+
+func Test_Parser_Parse_Position(t *testing.T) {
+	testCases := map[string]struct {
+		input      string
+		precedence Precedence
+		subExpr    func(Expr) Expr
+		expected   string
+	}{
+		"Literal": {
+			input:    "  42  ",
+			subExpr:  func(expr Expr) Expr { return expr },
+			expected: "42",
+		},
+		"Arithmetic chain": {
+			input:    "1 + 2 * 3",
+			subExpr:  func(expr Expr) Expr { return expr.(ExprMultiply).Expr1 },
+			expected: "1 + 2",
+		},
+		"Standard precedence operand": {
+			input:      "1 + 2 * 3",
+			precedence: PrecedenceStandard,
+			subExpr:    func(expr Expr) Expr { return expr.(ExprAdd).Expr2 },
+			expected:   "2 * 3",
+		},
+		"Field access and indexing": {
+			input:    `$.items[0].price == 2`,
+			subExpr:  func(expr Expr) Expr { return expr.(ExprEquals).Expr1.(ExprFieldAccess).Object },
+			expected: "$.items[0]",
+		},
+		"Ternary condition": {
+			input:    `$.a > 1 ? "yes" : "no"`,
+			subExpr:  func(expr Expr) Expr { return expr.(ExprTernary).Condition },
+			expected: "$.a > 1",
+		},
+		"Function argument": {
+			input:    `filter($.items, _.price > 10)`,
+			subExpr:  func(expr Expr) Expr { return expr.(ExprFunction).Args[1] },
+			expected: "_.price > 10",
+		},
+		"Piped call": {
+			input:    "$.items | len()",
+			subExpr:  func(expr Expr) Expr { return expr.(ExprPipe).Call },
+			expected: "len()",
+		},
+		"Parenthesised block": {
+			input:    "(1 + 2) * 3",
+			subExpr:  func(expr Expr) Expr { return expr.(ExprMultiply).Expr1 },
+			expected: "(1 + 2)",
+		},
+		"Multi-byte runes": {
+			input:    `"héllo" + $.ünïcode`,
+			subExpr:  func(expr Expr) Expr { return expr.(ExprAdd).Expr2 },
+			expected: "$.ünïcode",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			lex := lexer.New(tc.input)
+			expr, err := NewWithOptions(lex, Options{Precedence: tc.precedence}).Parse()
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			pos := tc.subExpr(expr).Position()
+			source := []rune(tc.input)
+			if pos.Start < 0 || pos.End > len(source) || pos.Start >= pos.End {
+				t.Fatalf("Invalid position %+v for input of length %d", pos, len(source))
+			}
+			if actual := string(source[pos.Start:pos.End]); actual != tc.expected {
+				t.Fatalf("Expected span %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -1,11 +1,20 @@
 package runtime
 
 import (
+	"cmp"
 	"errors"
 	"fmt"
+	"math"
+	"reflect"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/fletcharoo/fpath/internal"
 	"github.com/fletcharoo/fpath/internal/parser"
 	"github.com/shopspring/decimal"
 )
@@ -18,16 +27,103 @@ var (
 	ErrInvalidIndex         = errors.New("invalid list index")
 	ErrKeyNotFound          = errors.New("map key not found")
 	ErrInvalidMapIndex      = errors.New("invalid map index")
-	ErrUndefinedFunction    = errors.New("undefined function")
+	ErrUndefinedFunction    = parser.ErrUndefinedFunction
 	ErrInvalidArgumentCount = errors.New("invalid argument count")
 	ErrInvalidArgumentType  = errors.New("invalid argument type")
+	ErrUndefinedVariable    = parser.ErrUndefinedVariable
+	ErrInvalidLambda        = errors.New("invalid lambda")
+	ErrInvalidRegex         = parser.ErrInvalidRegex
+	ErrShadowedVariable     = parser.ErrShadowedVariable
+	ErrDuplicateKey         = errors.New("duplicate key")
+	ErrInvalidTime          = errors.New("invalid time or duration")
 )
 
-type evalFunc func(parser.Expr, any) (parser.Expr, error)
-type functionFunc func([]parser.Expr, any) (parser.Expr, error)
+// maxGeneratedLength is the largest number of characters or elements a
+// function such as repeat() or range() may generate, so that a query cannot
+// exhaust the host's memory.
+const maxGeneratedLength = 10_000_000
+
+// Error is returned when evaluation fails. Expr is the innermost expression
+// with a source span that failed, and Types holds the names of the value types
+// involved, if any.
+type Error struct {
+	Expr  parser.Expr
+	Types []string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// typesError annotates an error with the types of the values that caused it.
+type typesError struct {
+	types []string
+	err   error
+}
+
+func (e *typesError) Error() string {
+	return e.err.Error()
+}
+
+func (e *typesError) Unwrap() error {
+	return e.err
+}
+
+// withTypes annotates err with the type names of values.
+func withTypes(err error, values ...parser.Expr) error {
+	types := make([]string, len(values))
+	for i, value := range values {
+		types[i] = TypeName(value)
+	}
+
+	return &typesError{types: types, err: err}
+}
+
+// TypeName returns the human-readable name of the type of an evaluated
+// expression, such as "number" or "list".
+func TypeName(expr parser.Expr) string {
+	if expr == nil {
+		return "undefined"
+	}
+
+	switch expr.Type() {
+	case parser.ExprType_Number:
+		return "number"
+	case parser.ExprType_String:
+		return "string"
+	case parser.ExprType_Boolean:
+		return "boolean"
+	case parser.ExprType_Null:
+		return "null"
+	case parser.ExprType_List:
+		return "list"
+	case parser.ExprType_Map:
+		return "map"
+	case parser.ExprType_Lambda:
+		return "lambda"
+	case parser.ExprType_Time:
+		return "time"
+	case parser.ExprType_Duration:
+		return "duration"
+	default:
+		return "expression"
+	}
+}
+
+type evalFunc func(parser.Expr, *Context) (parser.Expr, error)
+
+// FunctionFunc implements a function callable from a query. It receives the
+// unevaluated argument expressions and the evaluation context, so it can
+// choose when and how each argument is evaluated.
+type FunctionFunc func([]parser.Expr, *Context) (parser.Expr, error)
 
 var evalFuncMap map[int]evalFunc
-var functionRegistry map[string]functionFunc
+var functionRegistry map[string]FunctionFunc
 
 func init() {
 	evalFuncMap = map[int]evalFunc{
@@ -38,6 +134,9 @@ func init() {
 		parser.ExprType_Input:              evalInput,
 		parser.ExprType_Variable:           evalVariable,
 		parser.ExprType_Boolean:            evalLiteral,
+		parser.ExprType_Null:               evalLiteral,
+		parser.ExprType_Time:               evalLiteral,
+		parser.ExprType_Duration:           evalLiteral,
 		parser.ExprType_Add:                evalAdd,
 		parser.ExprType_Subtract:           evalSubtract,
 		parser.ExprType_Multiply:           evalMultiply,
@@ -53,96 +152,315 @@ func init() {
 		parser.ExprType_LessThanOrEqual:    evalLessThanOrEqual,
 		parser.ExprType_And:                evalAnd,
 		parser.ExprType_Or:                 evalOr,
+		parser.ExprType_Not:                evalNot,
+		parser.ExprType_UnaryPlus:          evalUnaryPlus,
+		parser.ExprType_Negate:             evalNegate,
+		parser.ExprType_Lambda:             evalLambda,
+		parser.ExprType_Pipe:               evalPipe,
+		parser.ExprType_Coalesce:           evalCoalesce,
+		parser.ExprType_In:                 evalIn,
+		parser.ExprType_Match:              evalMatch,
+		parser.ExprType_Regex:              evalRegex,
+		parser.ExprType_Let:                evalLet,
 		parser.ExprType_Ternary:            evalTernary,
 		parser.ExprType_List:               evalList,
 		parser.ExprType_ListIndex:          evalListIndex,
 		parser.ExprType_ListSlice:          evalListSlice,
 		parser.ExprType_Map:                evalMap,
 		parser.ExprType_MapIndex:           evalMapIndex,
+		parser.ExprType_FieldAccess:        evalFieldAccess,
 		parser.ExprType_Function:           evalFunction,
 	}
 
-	functionRegistry = map[string]functionFunc{
-		"len":      evalLenFunction,
-		"filter":   evalFilterFunction,
-		"contains": evalContainsFunction,
-		"abs":      evalAbsFunction,
-		"min":      evalMinFunction,
-		"max":      evalMaxFunction,
-		"round":    evalRoundFunction,
-		"floor":    evalFloorFunction,
-		"ceil":     evalCeilFunction,
-		"sort":     evalSortFunction,
+	functionRegistry = map[string]FunctionFunc{
+		"len":          evalLenFunction,
+		"filter":       evalFilterFunction,
+		"map":          evalMapFunction,
+		"reduce":       evalReduceFunction,
+		"any":          evalAnyFunction,
+		"all":          evalAllFunction,
+		"find":         evalFindFunction,
+		"findIndex":    evalFindIndexFunction,
+		"count":        evalCountFunction,
+		"contains":     evalContainsFunction,
+		"abs":          evalAbsFunction,
+		"min":          evalMinFunction,
+		"max":          evalMaxFunction,
+		"round":        evalRoundFunction,
+		"floor":        evalFloorFunction,
+		"ceil":         evalCeilFunction,
+		"sort":         evalSortFunction,
+		"sort_desc":    evalSortDescFunction,
+		"sort_by":      evalSortByFunction,
+		"matches":      evalMatchesFunction,
+		"find_all":     evalFindAllFunction,
+		"replace_re":   evalReplaceReFunction,
+		"capture":      evalCaptureFunction,
+		"upper":        evalUpperFunction,
+		"lower":        evalLowerFunction,
+		"title":        evalTitleFunction,
+		"trim":         evalTrimBothFunction,
+		"trim_left":    evalTrimLeftFunction,
+		"trim_right":   evalTrimRightFunction,
+		"split":        evalSplitFunction,
+		"join":         evalJoinFunction,
+		"starts_with":  evalStartsWithFunction,
+		"ends_with":    evalEndsWithFunction,
+		"replace":      evalReplaceFunction,
+		"index_of":     evalIndexOfFunction,
+		"pad_left":     evalPadLeftFunction,
+		"pad_right":    evalPadRightFunction,
+		"repeat":       evalRepeatFunction,
+		"reverse":      evalReverseFunction,
+		"keys":         evalKeysFunction,
+		"values":       evalValuesFunction,
+		"entries":      evalEntriesFunction,
+		"from_entries": evalFromEntriesFunction,
+		"merge":        evalMergeFunction,
+		"deep_merge":   evalDeepMergeFunction,
+		"pick":         evalPickFunction,
+		"omit":         evalOmitFunction,
+		"map_values":   evalMapValuesFunction,
+		"sum":          evalSumFunction,
+		"product":      evalProductFunction,
+		"avg":          evalAvgFunction,
+		"median":       evalMedianFunction,
+		"mode":         evalModeFunction,
+		"variance":     evalVarianceFunction,
+		"stddev":       evalStddevFunction,
+		"percentile":   evalPercentileFunction,
+		"unique":       evalUniqueFunction,
+		"flatten":      evalFlattenFunction,
+		"zip":          evalZipFunction,
+		"concat":       evalConcatFunction,
+		"chunk":        evalChunkFunction,
+		"range":        evalRangeFunction,
+		"first":        evalFirstFunction,
+		"last":         evalLastFunction,
+		"take":         evalTakeFunction,
+		"drop":         evalDropFunction,
+		"group_by":     evalGroupByFunction,
+		"count_by":     evalCountByFunction,
+		"index_by":     evalIndexByFunction,
+		"partition":    evalPartitionFunction,
+		"now":          evalNowFunction,
+		"parse_time":   evalParseTimeFunction,
+		"format_time":  evalFormatTimeFunction,
+		"duration":     evalDurationFunction,
+		"date_diff":    evalDateDiffFunction,
+		"year":         evalYearFunction,
+		"month":        evalMonthFunction,
+		"day":          evalDayFunction,
+		"hour":         evalHourFunction,
+		"minute":       evalMinuteFunction,
+		"second":       evalSecondFunction,
+		"weekday":      evalWeekdayFunction,
+		"unix":         evalUnixFunction,
+	}
+}
+
+// Context holds the state an expression is evaluated against.
+type Context struct {
+	// Input is the data referenced by `$`.
+	Input any
+	// Functions holds host-registered functions. They are looked up before
+	// the built-in functions.
+	Functions map[string]FunctionFunc
+	// Variables holds the values of named variables referenced by bare
+	// labels in the query.
+	Variables map[string]any
+	// Now returns the current time for now(). When nil, time.Now is used.
+	Now func() time.Time
+
+	// scope holds the innermost `let` binding, which shadows Variables.
+	scope *scope
+	// element is the list element bound to `_` while evaluating a predicate,
+	// or nil outside of predicates.
+	element parser.Expr
+}
+
+// scope is a link in the chain of bindings visible to an expression.
+type scope struct {
+	name   string
+	value  parser.Expr
+	parent *scope
+}
+
+// lookup returns the value bound to name in the innermost scope that binds it.
+func (s *scope) lookup(name string) (parser.Expr, bool) {
+	for ; s != nil; s = s.parent {
+		if s.name == name {
+			return s.value, true
+		}
+	}
+
+	return nil, false
+}
+
+// withBinding returns a copy of the context with name bound to value.
+func (c *Context) withBinding(name string, value parser.Expr) *Context {
+	bindingCtx := *c
+	bindingCtx.scope = &scope{
+		name:   name,
+		value:  value,
+		parent: c.scope,
+	}
+	return &bindingCtx
+}
+
+// WithElement returns a copy of the context for evaluating a predicate, such
+// as the condition passed to filter(), against a single list element bound
+// to `_`. `$` still refers to the input data.
+func (c *Context) WithElement(element parser.Expr) *Context {
+	elementCtx := *c
+	elementCtx.element = element
+	return &elementCtx
+}
+
+// Apply evaluates fn, the predicate or transform argument of a function such
+// as filter(), for a single call. A lambda binds its parameters to args, which
+// must match them in number. Any other expression is evaluated with `_` bound
+// to the first of args.
+func (c *Context) Apply(fn parser.Expr, args ...parser.Expr) (parser.Expr, error) {
+	lambda, ok := fn.(parser.ExprLambda)
+	if !ok {
+		if len(args) == 0 {
+			return eval(fn, c)
+		}
+		return eval(fn, c.WithElement(args[0]))
+	}
+
+	if len(lambda.Params) != len(args) {
+		return nil, fmt.Errorf("%w: lambda expects %d parameters, got %d arguments", ErrInvalidArgumentCount, len(lambda.Params), len(args))
+	}
+
+	// Parameters are bound on top of the calling scope, so the body can see
+	// any enclosing let bindings and lambda parameters
+	lambdaCtx := c
+	for i, param := range lambda.Params {
+		lambdaCtx = lambdaCtx.withBinding(param, args[i])
 	}
+
+	return eval(lambda.Body, lambdaCtx)
 }
 
 // Eval accepts a parsed expression and the query's input data and returns the
 // evaluated result
 func Eval(expr parser.Expr, input any) (result parser.Expr, err error) {
+	return EvalWithContext(expr, &Context{Input: input})
+}
+
+// EvalWithContext accepts a parsed expression and an evaluation context and
+// returns the evaluated result.
+func EvalWithContext(expr parser.Expr, ctx *Context) (result parser.Expr, err error) {
+	return eval(expr, ctx)
+}
+
+// eval dispatches the expression to its evaluator.
+func eval(expr parser.Expr, ctx *Context) (result parser.Expr, err error) {
 	f, ok := evalFuncMap[expr.Type()]
 	if !ok {
 		return evalUndefined(nil, nil)
 	}
 
-	return f(expr, input)
+	result, err = f(expr, ctx)
+	if err != nil && !expr.Position().IsZero() {
+		err = wrapError(expr, err)
+	}
+
+	return
+}
+
+// wrapError wraps err in an *Error for expr, unless a more specific
+// expression has already been recorded.
+func wrapError(expr parser.Expr, err error) error {
+	var runtimeErr *Error
+	if errors.As(err, &runtimeErr) {
+		return err
+	}
+
+	var typesErr *typesError
+	var types []string
+	if errors.As(err, &typesErr) {
+		types = typesErr.types
+	}
+
+	return &Error{Expr: expr, Types: types, Err: err}
+}
+
+// IsBuiltinFunction reports whether name is a built-in function.
+func IsBuiltinFunction(name string) bool {
+	_, ok := functionRegistry[name]
+	return ok
+}
+
+// ConvertValue converts a Go value to an expression, using the same rules as
+// the query's input data.
+func ConvertValue(value any) (parser.Expr, error) {
+	return convertInputToExpr(value)
 }
 
 // evalUndefined returns an undefined error.
-func evalUndefined(_ parser.Expr, _ any) (ret parser.Expr, err error) {
+func evalUndefined(_ parser.Expr, _ *Context) (ret parser.Expr, err error) {
 	err = fmt.Errorf("failed to eval undefined expression")
 	return
 }
 
 // evalLiteral returns the expression passed into it.
-func evalLiteral(expr parser.Expr, _ any) (ret parser.Expr, err error) {
+func evalLiteral(expr parser.Expr, _ *Context) (ret parser.Expr, err error) {
 	return expr, nil
 }
 
 // evalString returns the string expression passed into it.
-func evalString(expr parser.Expr, _ any) (ret parser.Expr, err error) {
+func evalString(expr parser.Expr, _ *Context) (ret parser.Expr, err error) {
 	return expr, nil
 }
 
 // evalInput converts input data to appropriate expression types.
-func evalInput(_ parser.Expr, input any) (ret parser.Expr, err error) {
-	return convertInputToExpr(input)
+func evalInput(_ parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return convertInputToExpr(ctx.Input)
 }
 
 // evalLiteral evaluates the contained expression.
-func evalBlock(expr parser.Expr, input any) (ret parser.Expr, err error) {
+func evalBlock(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	exprBlock, ok := expr.(parser.ExprBlock)
 	if !ok {
 		err = fmt.Errorf("failed to assert expression as block")
 		return
 	}
 
-	return Eval(exprBlock.Expr, input)
+	return eval(exprBlock.Expr, ctx)
 }
 
 // evalAdd accepts a parser.ExprAdd expression and performs the operation.
-func evalAdd(expr parser.Expr, input any) (ret parser.Expr, err error) {
+func evalAdd(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	exprAdd, ok := expr.(parser.ExprAdd)
 	if !ok {
 		err = fmt.Errorf("failed to assert expression as add")
 		return
 	}
 
-	expr1, err := Eval(exprAdd.Expr1, input)
+	expr1, err := eval(exprAdd.Expr1, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate first expression: %w", err)
 		return
 	}
 
-	expr2, err := Eval(exprAdd.Expr2, input)
+	expr2, err := eval(exprAdd.Expr2, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate second expression: %w", err)
 		return
 	}
 
+	// Times and durations combine with each other, see evalAddTemporal
+	if isTemporal(expr1) || isTemporal(expr2) {
+		return evalAddTemporal(expr1, expr2)
+	}
+
 	expr1Type := expr1.Type()
 	expr2Type := expr2.Type()
 	if expr1Type != expr2Type {
-		err = fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, expr1, expr2)
+		err = withTypes(fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, TypeName(expr1), TypeName(expr2)), expr1, expr2)
 		return
 	}
 
@@ -151,6 +469,8 @@ func evalAdd(expr parser.Expr, input any) (ret parser.Expr, err error) {
 		return evalAddNumber(expr1, expr2)
 	case parser.ExprType_String:
 		return evalAddString(expr1, expr2)
+	case parser.ExprType_List:
+		return evalAddList(expr1, expr2)
 	default:
 		err = fmt.Errorf("invalid add type: %s", expr1)
 		return
@@ -201,8 +521,30 @@ func evalAddString(expr1, expr2 parser.Expr) (result parser.Expr, err error) {
 	return resultString, nil
 }
 
+// evalAddList accepts two parser.ExprList expressions and concatenates them
+// together.
+func evalAddList(expr1, expr2 parser.Expr) (result parser.Expr, err error) {
+	expr1List, ok := expr1.(parser.ExprList)
+	if !ok {
+		err = fmt.Errorf("failed to assert first expression as list")
+		return
+	}
+
+	expr2List, ok := expr2.(parser.ExprList)
+	if !ok {
+		err = fmt.Errorf("failed to assert second expression as list")
+		return
+	}
+
+	values := make([]parser.Expr, 0, len(expr1List.Values)+len(expr2List.Values))
+	values = append(values, expr1List.Values...)
+	values = append(values, expr2List.Values...)
+
+	return parser.ExprList{Values: values}, nil
+}
+
 // evalSubtract accepts a parser.ExprSubtract expression and performs the operation.
-func evalSubtract(expr parser.Expr, input any) (ret parser.Expr, err error) {
+func evalSubtract(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	exprSubtract, ok := expr.(parser.ExprSubtract)
 	if !ok {
 		err = fmt.Errorf("failed to assert expression as subtract")
@@ -217,7 +559,7 @@ func evalSubtract(expr parser.Expr, input any) (ret parser.Expr, err error) {
 		leftResult, err := evalSubtract(parser.ExprSubtract{
 			Expr1: exprSubtract.Expr1,
 			Expr2: nestedSubtract.Expr1,
-		}, input)
+		}, ctx)
 		if err != nil {
 			return nil, fmt.Errorf("failed to evaluate left part of chained subtraction: %w", err)
 		}
@@ -226,25 +568,30 @@ func evalSubtract(expr parser.Expr, input any) (ret parser.Expr, err error) {
 		return evalSubtract(parser.ExprSubtract{
 			Expr1: leftResult,
 			Expr2: nestedSubtract.Expr2,
-		}, input)
+		}, ctx)
 	}
 
-	expr1, err := Eval(exprSubtract.Expr1, input)
+	expr1, err := eval(exprSubtract.Expr1, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate first expression: %w", err)
 		return
 	}
 
-	expr2, err := Eval(exprSubtract.Expr2, input)
+	expr2, err := eval(exprSubtract.Expr2, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate second expression: %w", err)
 		return
 	}
 
+	// Times and durations combine with each other, see evalSubtractTemporal
+	if isTemporal(expr1) || isTemporal(expr2) {
+		return evalSubtractTemporal(expr1, expr2)
+	}
+
 	expr1Type := expr1.Type()
 	expr2Type := expr2.Type()
 	if expr1Type != expr2Type {
-		err = fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, expr1, expr2)
+		err = withTypes(fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, TypeName(expr1), TypeName(expr2)), expr1, expr2)
 		return
 	}
 
@@ -280,20 +627,20 @@ func evalSubtractNumber(expr1, expr2 parser.Expr) (result parser.Expr, err error
 }
 
 // evalMultoply accepts a parser.ExprMultiply expression and performs the operation.
-func evalMultiply(expr parser.Expr, input any) (ret parser.Expr, err error) {
+func evalMultiply(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	exprAdd, ok := expr.(parser.ExprMultiply)
 	if !ok {
 		err = fmt.Errorf("failed to assert expression as multiply")
 		return
 	}
 
-	expr1, err := Eval(exprAdd.Expr1, input)
+	expr1, err := eval(exprAdd.Expr1, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate first expression: %w", err)
 		return
 	}
 
-	expr2, err := Eval(exprAdd.Expr2, input)
+	expr2, err := eval(exprAdd.Expr2, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate second expression: %w", err)
 		return
@@ -302,7 +649,7 @@ func evalMultiply(expr parser.Expr, input any) (ret parser.Expr, err error) {
 	expr1Type := expr1.Type()
 	expr2Type := expr2.Type()
 	if expr1Type != expr2Type {
-		err = fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, expr1, expr2)
+		err = withTypes(fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, TypeName(expr1), TypeName(expr2)), expr1, expr2)
 		return
 	}
 
@@ -338,7 +685,7 @@ func evalMultiplyNumber(expr1, expr2 parser.Expr) (result parser.Expr, err error
 }
 
 // evalDivide accepts a parser.ExprDivide expression and performs the operation.
-func evalDivide(expr parser.Expr, input any) (ret parser.Expr, err error) {
+func evalDivide(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	exprDivide, ok := expr.(parser.ExprDivide)
 	if !ok {
 		err = fmt.Errorf("failed to assert expression as divide")
@@ -353,7 +700,7 @@ func evalDivide(expr parser.Expr, input any) (ret parser.Expr, err error) {
 		leftResult, err := evalDivide(parser.ExprDivide{
 			Expr1: exprDivide.Expr1,
 			Expr2: nestedDivide.Expr1,
-		}, input)
+		}, ctx)
 		if err != nil {
 			return nil, fmt.Errorf("failed to evaluate left part of chained division: %w", err)
 		}
@@ -362,16 +709,16 @@ func evalDivide(expr parser.Expr, input any) (ret parser.Expr, err error) {
 		return evalDivide(parser.ExprDivide{
 			Expr1: leftResult,
 			Expr2: nestedDivide.Expr2,
-		}, input)
+		}, ctx)
 	}
 
-	expr1, err := Eval(exprDivide.Expr1, input)
+	expr1, err := eval(exprDivide.Expr1, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate first expression: %w", err)
 		return
 	}
 
-	expr2, err := Eval(exprDivide.Expr2, input)
+	expr2, err := eval(exprDivide.Expr2, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate second expression: %w", err)
 		return
@@ -380,7 +727,7 @@ func evalDivide(expr parser.Expr, input any) (ret parser.Expr, err error) {
 	expr1Type := expr1.Type()
 	expr2Type := expr2.Type()
 	if expr1Type != expr2Type {
-		err = fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, expr1, expr2)
+		err = withTypes(fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, TypeName(expr1), TypeName(expr2)), expr1, expr2)
 		return
 	}
 
@@ -422,7 +769,7 @@ func evalDivideNumber(expr1, expr2 parser.Expr) (result parser.Expr, err error)
 }
 
 // evalIntegerDivision accepts a parser.ExprIntegerDivision expression and performs the operation.
-func evalIntegerDivision(expr parser.Expr, input any) (ret parser.Expr, err error) {
+func evalIntegerDivision(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	exprIntDiv, ok := expr.(parser.ExprIntegerDivision)
 	if !ok {
 		err = fmt.Errorf("failed to assert expression as integer division")
@@ -437,7 +784,7 @@ func evalIntegerDivision(expr parser.Expr, input any) (ret parser.Expr, err erro
 		leftResult, err := evalIntegerDivision(parser.ExprIntegerDivision{
 			Expr1: exprIntDiv.Expr1,
 			Expr2: nestedIntDiv.Expr1,
-		}, input)
+		}, ctx)
 		if err != nil {
 			return nil, fmt.Errorf("failed to evaluate left part of chained integer division: %w", err)
 		}
@@ -446,16 +793,16 @@ func evalIntegerDivision(expr parser.Expr, input any) (ret parser.Expr, err erro
 		return evalIntegerDivision(parser.ExprIntegerDivision{
 			Expr1: leftResult,
 			Expr2: nestedIntDiv.Expr2,
-		}, input)
+		}, ctx)
 	}
 
-	expr1, err := Eval(exprIntDiv.Expr1, input)
+	expr1, err := eval(exprIntDiv.Expr1, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate first expression: %w", err)
 		return
 	}
 
-	expr2, err := Eval(exprIntDiv.Expr2, input)
+	expr2, err := eval(exprIntDiv.Expr2, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate second expression: %w", err)
 		return
@@ -464,7 +811,7 @@ func evalIntegerDivision(expr parser.Expr, input any) (ret parser.Expr, err erro
 	expr1Type := expr1.Type()
 	expr2Type := expr2.Type()
 	if expr1Type != expr2Type {
-		err = fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, expr1, expr2)
+		err = withTypes(fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, TypeName(expr1), TypeName(expr2)), expr1, expr2)
 		return
 	}
 
@@ -515,7 +862,7 @@ func evalIntegerDivisionNumber(expr1, expr2 parser.Expr) (result parser.Expr, er
 }
 
 // evalModulo accepts a parser.ExprModulo expression and performs the operation.
-func evalModulo(expr parser.Expr, input any) (ret parser.Expr, err error) {
+func evalModulo(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	exprModulo, ok := expr.(parser.ExprModulo)
 	if !ok {
 		err = fmt.Errorf("failed to assert expression as modulo")
@@ -530,7 +877,7 @@ func evalModulo(expr parser.Expr, input any) (ret parser.Expr, err error) {
 		leftResult, err := evalModulo(parser.ExprModulo{
 			Expr1: exprModulo.Expr1,
 			Expr2: nestedModulo.Expr1,
-		}, input)
+		}, ctx)
 		if err != nil {
 			return nil, fmt.Errorf("failed to evaluate left part of chained modulo: %w", err)
 		}
@@ -539,16 +886,16 @@ func evalModulo(expr parser.Expr, input any) (ret parser.Expr, err error) {
 		return evalModulo(parser.ExprModulo{
 			Expr1: leftResult,
 			Expr2: nestedModulo.Expr2,
-		}, input)
+		}, ctx)
 	}
 
-	expr1, err := Eval(exprModulo.Expr1, input)
+	expr1, err := eval(exprModulo.Expr1, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate first expression: %w", err)
 		return
 	}
 
-	expr2, err := Eval(exprModulo.Expr2, input)
+	expr2, err := eval(exprModulo.Expr2, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate second expression: %w", err)
 		return
@@ -557,7 +904,7 @@ func evalModulo(expr parser.Expr, input any) (ret parser.Expr, err error) {
 	expr1Type := expr1.Type()
 	expr2Type := expr2.Type()
 	if expr1Type != expr2Type {
-		err = fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, expr1, expr2)
+		err = withTypes(fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, TypeName(expr1), TypeName(expr2)), expr1, expr2)
 		return
 	}
 
@@ -598,7 +945,7 @@ func evalModuloNumber(expr1, expr2 parser.Expr) (result parser.Expr, err error)
 }
 
 // evalExponent accepts a parser.ExprExponent expression and performs the operation.
-func evalExponent(expr parser.Expr, input any) (ret parser.Expr, err error) {
+func evalExponent(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	exprExponent, ok := expr.(parser.ExprExponent)
 	if !ok {
 		err = fmt.Errorf("failed to assert expression as exponent")
@@ -613,7 +960,7 @@ func evalExponent(expr parser.Expr, input any) (ret parser.Expr, err error) {
 		leftResult, err := evalExponent(parser.ExprExponent{
 			Expr1: exprExponent.Expr1,
 			Expr2: nestedExponent.Expr1,
-		}, input)
+		}, ctx)
 		if err != nil {
 			return nil, fmt.Errorf("failed to evaluate left part of chained exponentiation: %w", err)
 		}
@@ -622,16 +969,16 @@ func evalExponent(expr parser.Expr, input any) (ret parser.Expr, err error) {
 		return evalExponent(parser.ExprExponent{
 			Expr1: leftResult,
 			Expr2: nestedExponent.Expr2,
-		}, input)
+		}, ctx)
 	}
 
-	expr1, err := Eval(exprExponent.Expr1, input)
+	expr1, err := eval(exprExponent.Expr1, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate first expression: %w", err)
 		return
 	}
 
-	expr2, err := Eval(exprExponent.Expr2, input)
+	expr2, err := eval(exprExponent.Expr2, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate second expression: %w", err)
 		return
@@ -640,7 +987,7 @@ func evalExponent(expr parser.Expr, input any) (ret parser.Expr, err error) {
 	expr1Type := expr1.Type()
 	expr2Type := expr2.Type()
 	if expr1Type != expr2Type {
-		err = fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, expr1, expr2)
+		err = withTypes(fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, TypeName(expr1), TypeName(expr2)), expr1, expr2)
 		return
 	}
 
@@ -678,20 +1025,20 @@ func evalExponentNumber(expr1, expr2 parser.Expr) (result parser.Expr, err error
 }
 
 // evalEquals accepts a parser.ExprEquals expression and performs the equality comparison.
-func evalEquals(expr parser.Expr, input any) (ret parser.Expr, err error) {
+func evalEquals(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	exprEquals, ok := expr.(parser.ExprEquals)
 	if !ok {
 		err = fmt.Errorf("failed to assert expression as equals")
 		return
 	}
 
-	expr1, err := Eval(exprEquals.Expr1, input)
+	expr1, err := eval(exprEquals.Expr1, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate first expression: %w", err)
 		return
 	}
 
-	expr2, err := Eval(exprEquals.Expr2, input)
+	expr2, err := eval(exprEquals.Expr2, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate second expression: %w", err)
 		return
@@ -699,8 +1046,14 @@ func evalEquals(expr parser.Expr, input any) (ret parser.Expr, err error) {
 
 	expr1Type := expr1.Type()
 	expr2Type := expr2.Type()
+
+	// Null can be compared with any type and is only equal to itself
+	if expr1Type == parser.ExprType_Null || expr2Type == parser.ExprType_Null {
+		return parser.ExprBoolean{Value: expr1Type == expr2Type}, nil
+	}
+
 	if expr1Type != expr2Type {
-		err = fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, expr1, expr2)
+		err = withTypes(fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, TypeName(expr1), TypeName(expr2)), expr1, expr2)
 		return
 	}
 
@@ -711,6 +1064,8 @@ func evalEquals(expr parser.Expr, input any) (ret parser.Expr, err error) {
 		return evalEqualsString(expr1, expr2)
 	case parser.ExprType_Boolean:
 		return evalEqualsBoolean(expr1, expr2)
+	case parser.ExprType_Time, parser.ExprType_Duration:
+		return evalCompareTemporal(expr1, expr2, func(c int) bool { return c == 0 })
 	default:
 		err = fmt.Errorf("invalid equals type: %s", expr1)
 		return
@@ -787,20 +1142,20 @@ func evalEqualsBoolean(expr1, expr2 parser.Expr) (result parser.Expr, err error)
 }
 
 // evalNotEquals accepts a parser.ExprNotEquals expression and performs the inequality comparison.
-func evalNotEquals(expr parser.Expr, input any) (ret parser.Expr, err error) {
+func evalNotEquals(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	exprNotEquals, ok := expr.(parser.ExprNotEquals)
 	if !ok {
 		err = fmt.Errorf("failed to assert expression as not equals")
 		return
 	}
 
-	expr1, err := Eval(exprNotEquals.Expr1, input)
+	expr1, err := eval(exprNotEquals.Expr1, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate first expression: %w", err)
 		return
 	}
 
-	expr2, err := Eval(exprNotEquals.Expr2, input)
+	expr2, err := eval(exprNotEquals.Expr2, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate second expression: %w", err)
 		return
@@ -808,8 +1163,14 @@ func evalNotEquals(expr parser.Expr, input any) (ret parser.Expr, err error) {
 
 	expr1Type := expr1.Type()
 	expr2Type := expr2.Type()
+
+	// Null can be compared with any type and is only equal to itself
+	if expr1Type == parser.ExprType_Null || expr2Type == parser.ExprType_Null {
+		return parser.ExprBoolean{Value: expr1Type != expr2Type}, nil
+	}
+
 	if expr1Type != expr2Type {
-		err = fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, expr1, expr2)
+		err = withTypes(fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, TypeName(expr1), TypeName(expr2)), expr1, expr2)
 		return
 	}
 
@@ -820,6 +1181,8 @@ func evalNotEquals(expr parser.Expr, input any) (ret parser.Expr, err error) {
 		return evalNotEqualsString(expr1, expr2)
 	case parser.ExprType_Boolean:
 		return evalNotEqualsBoolean(expr1, expr2)
+	case parser.ExprType_Time, parser.ExprType_Duration:
+		return evalCompareTemporal(expr1, expr2, func(c int) bool { return c != 0 })
 	default:
 		err = fmt.Errorf("invalid not equals type: %s", expr1)
 		return
@@ -896,20 +1259,20 @@ func evalNotEqualsBoolean(expr1, expr2 parser.Expr) (result parser.Expr, err err
 }
 
 // evalGreaterThan accepts a parser.ExprGreaterThan expression and performs the greater than comparison.
-func evalGreaterThan(expr parser.Expr, input any) (ret parser.Expr, err error) {
+func evalGreaterThan(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	exprGreaterThan, ok := expr.(parser.ExprGreaterThan)
 	if !ok {
 		err = fmt.Errorf("failed to assert expression as greater than")
 		return
 	}
 
-	expr1, err := Eval(exprGreaterThan.Expr1, input)
+	expr1, err := eval(exprGreaterThan.Expr1, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate first expression: %w", err)
 		return
 	}
 
-	expr2, err := Eval(exprGreaterThan.Expr2, input)
+	expr2, err := eval(exprGreaterThan.Expr2, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate second expression: %w", err)
 		return
@@ -918,7 +1281,7 @@ func evalGreaterThan(expr parser.Expr, input any) (ret parser.Expr, err error) {
 	expr1Type := expr1.Type()
 	expr2Type := expr2.Type()
 	if expr1Type != expr2Type {
-		err = fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, expr1, expr2)
+		err = withTypes(fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, TypeName(expr1), TypeName(expr2)), expr1, expr2)
 		return
 	}
 
@@ -929,6 +1292,8 @@ func evalGreaterThan(expr parser.Expr, input any) (ret parser.Expr, err error) {
 		return evalGreaterThanString(expr1, expr2)
 	case parser.ExprType_Boolean:
 		return evalGreaterThanBoolean(expr1, expr2)
+	case parser.ExprType_Time, parser.ExprType_Duration:
+		return evalCompareTemporal(expr1, expr2, func(c int) bool { return c > 0 })
 	default:
 		err = fmt.Errorf("invalid greater than type: %s", expr1)
 		return
@@ -1005,20 +1370,20 @@ func evalGreaterThanBoolean(expr1, expr2 parser.Expr) (result parser.Expr, err e
 }
 
 // evalLessThan accepts a parser.ExprLessThan expression and performs the less than comparison.
-func evalLessThan(expr parser.Expr, input any) (ret parser.Expr, err error) {
+func evalLessThan(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	exprLessThan, ok := expr.(parser.ExprLessThan)
 	if !ok {
 		err = fmt.Errorf("failed to assert expression as less than")
 		return
 	}
 
-	expr1, err := Eval(exprLessThan.Expr1, input)
+	expr1, err := eval(exprLessThan.Expr1, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate first expression: %w", err)
 		return
 	}
 
-	expr2, err := Eval(exprLessThan.Expr2, input)
+	expr2, err := eval(exprLessThan.Expr2, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate second expression: %w", err)
 		return
@@ -1027,7 +1392,7 @@ func evalLessThan(expr parser.Expr, input any) (ret parser.Expr, err error) {
 	expr1Type := expr1.Type()
 	expr2Type := expr2.Type()
 	if expr1Type != expr2Type {
-		err = fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, expr1, expr2)
+		err = withTypes(fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, TypeName(expr1), TypeName(expr2)), expr1, expr2)
 		return
 	}
 
@@ -1038,6 +1403,8 @@ func evalLessThan(expr parser.Expr, input any) (ret parser.Expr, err error) {
 		return evalLessThanString(expr1, expr2)
 	case parser.ExprType_Boolean:
 		return evalLessThanBoolean(expr1, expr2)
+	case parser.ExprType_Time, parser.ExprType_Duration:
+		return evalCompareTemporal(expr1, expr2, func(c int) bool { return c < 0 })
 	default:
 		err = fmt.Errorf("invalid less than type: %s", expr1)
 		return
@@ -1114,20 +1481,20 @@ func evalLessThanBoolean(expr1, expr2 parser.Expr) (result parser.Expr, err erro
 }
 
 // evalLessThanOrEqual accepts a parser.ExprLessThanOrEqual expression and performs the less than or equal comparison.
-func evalLessThanOrEqual(expr parser.Expr, input any) (ret parser.Expr, err error) {
+func evalLessThanOrEqual(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	exprLessThanOrEqual, ok := expr.(parser.ExprLessThanOrEqual)
 	if !ok {
 		err = fmt.Errorf("failed to assert expression as less than or equal")
 		return
 	}
 
-	expr1, err := Eval(exprLessThanOrEqual.Expr1, input)
+	expr1, err := eval(exprLessThanOrEqual.Expr1, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate first expression: %w", err)
 		return
 	}
 
-	expr2, err := Eval(exprLessThanOrEqual.Expr2, input)
+	expr2, err := eval(exprLessThanOrEqual.Expr2, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate second expression: %w", err)
 		return
@@ -1136,7 +1503,7 @@ func evalLessThanOrEqual(expr parser.Expr, input any) (ret parser.Expr, err erro
 	expr1Type := expr1.Type()
 	expr2Type := expr2.Type()
 	if expr1Type != expr2Type {
-		err = fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, expr1, expr2)
+		err = withTypes(fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, TypeName(expr1), TypeName(expr2)), expr1, expr2)
 		return
 	}
 
@@ -1147,6 +1514,8 @@ func evalLessThanOrEqual(expr parser.Expr, input any) (ret parser.Expr, err erro
 		return evalLessThanOrEqualString(expr1, expr2)
 	case parser.ExprType_Boolean:
 		return evalLessThanOrEqualBoolean(expr1, expr2)
+	case parser.ExprType_Time, parser.ExprType_Duration:
+		return evalCompareTemporal(expr1, expr2, func(c int) bool { return c <= 0 })
 	default:
 		err = fmt.Errorf("invalid less than or equal type: %s", expr1)
 		return
@@ -1223,20 +1592,20 @@ func evalLessThanOrEqualBoolean(expr1, expr2 parser.Expr) (result parser.Expr, e
 }
 
 // evalGreaterThanOrEqual accepts a parser.ExprGreaterThanOrEqual expression and performs the greater than or equal comparison.
-func evalGreaterThanOrEqual(expr parser.Expr, input any) (ret parser.Expr, err error) {
+func evalGreaterThanOrEqual(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	exprGreaterThanOrEqual, ok := expr.(parser.ExprGreaterThanOrEqual)
 	if !ok {
 		err = fmt.Errorf("failed to assert expression as greater than or equal")
 		return
 	}
 
-	expr1, err := Eval(exprGreaterThanOrEqual.Expr1, input)
+	expr1, err := eval(exprGreaterThanOrEqual.Expr1, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate first expression: %w", err)
 		return
 	}
 
-	expr2, err := Eval(exprGreaterThanOrEqual.Expr2, input)
+	expr2, err := eval(exprGreaterThanOrEqual.Expr2, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate second expression: %w", err)
 		return
@@ -1245,7 +1614,7 @@ func evalGreaterThanOrEqual(expr parser.Expr, input any) (ret parser.Expr, err e
 	expr1Type := expr1.Type()
 	expr2Type := expr2.Type()
 	if expr1Type != expr2Type {
-		err = fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, expr1, expr2)
+		err = withTypes(fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, TypeName(expr1), TypeName(expr2)), expr1, expr2)
 		return
 	}
 
@@ -1256,6 +1625,8 @@ func evalGreaterThanOrEqual(expr parser.Expr, input any) (ret parser.Expr, err e
 		return evalGreaterThanOrEqualString(expr1, expr2)
 	case parser.ExprType_Boolean:
 		return evalGreaterThanOrEqualBoolean(expr1, expr2)
+	case parser.ExprType_Time, parser.ExprType_Duration:
+		return evalCompareTemporal(expr1, expr2, func(c int) bool { return c >= 0 })
 	default:
 		err = fmt.Errorf("invalid greater than or equal type: %s", expr1)
 		return
@@ -1332,7 +1703,7 @@ func evalGreaterThanOrEqualBoolean(expr1, expr2 parser.Expr) (result parser.Expr
 }
 
 // evalAnd accepts a parser.ExprAnd expression and performs the logical AND operation.
-func evalAnd(expr parser.Expr, input any) (ret parser.Expr, err error) {
+func evalAnd(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	exprAnd, ok := expr.(parser.ExprAnd)
 	if !ok {
 		err = fmt.Errorf("failed to assert expression as and")
@@ -1340,7 +1711,7 @@ func evalAnd(expr parser.Expr, input any) (ret parser.Expr, err error) {
 	}
 
 	// Evaluate first expression
-	expr1, err := Eval(exprAnd.Expr1, input)
+	expr1, err := eval(exprAnd.Expr1, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate first expression: %w", err)
 		return
@@ -1355,7 +1726,7 @@ func evalAnd(expr parser.Expr, input any) (ret parser.Expr, err error) {
 	}
 
 	// Evaluate second expression
-	expr2, err := Eval(exprAnd.Expr2, input)
+	expr2, err := eval(exprAnd.Expr2, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate second expression: %w", err)
 		return
@@ -1365,7 +1736,7 @@ func evalAnd(expr parser.Expr, input any) (ret parser.Expr, err error) {
 	expr1Type := expr1.Type()
 	expr2Type := expr2.Type()
 	if expr1Type != parser.ExprType_Boolean || expr2Type != parser.ExprType_Boolean {
-		err = fmt.Errorf("%w: got %s and %s", ErrBooleanOperation, expr1, expr2)
+		err = withTypes(fmt.Errorf("%w: got %s and %s", ErrBooleanOperation, TypeName(expr1), TypeName(expr2)), expr1, expr2)
 		return
 	}
 
@@ -1396,7 +1767,7 @@ func evalAndBoolean(expr1, expr2 parser.Expr) (result parser.Expr, err error) {
 }
 
 // evalOr accepts a parser.ExprOr expression and performs the logical OR operation.
-func evalOr(expr parser.Expr, input any) (ret parser.Expr, err error) {
+func evalOr(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	exprOr, ok := expr.(parser.ExprOr)
 	if !ok {
 		err = fmt.Errorf("failed to assert expression as or")
@@ -1404,7 +1775,7 @@ func evalOr(expr parser.Expr, input any) (ret parser.Expr, err error) {
 	}
 
 	// Evaluate first expression
-	expr1, err := Eval(exprOr.Expr1, input)
+	expr1, err := eval(exprOr.Expr1, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate first expression: %w", err)
 		return
@@ -1419,7 +1790,7 @@ func evalOr(expr parser.Expr, input any) (ret parser.Expr, err error) {
 	}
 
 	// Evaluate second expression
-	expr2, err := Eval(exprOr.Expr2, input)
+	expr2, err := eval(exprOr.Expr2, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate second expression: %w", err)
 		return
@@ -1429,7 +1800,7 @@ func evalOr(expr parser.Expr, input any) (ret parser.Expr, err error) {
 	expr1Type := expr1.Type()
 	expr2Type := expr2.Type()
 	if expr1Type != parser.ExprType_Boolean || expr2Type != parser.ExprType_Boolean {
-		err = fmt.Errorf("%w: got %s and %s", ErrBooleanOperation, expr1, expr2)
+		err = withTypes(fmt.Errorf("%w: got %s and %s", ErrBooleanOperation, TypeName(expr1), TypeName(expr2)), expr1, expr2)
 		return
 	}
 
@@ -1459,8 +1830,109 @@ func evalOrBoolean(expr1, expr2 parser.Expr) (result parser.Expr, err error) {
 	return resultBoolean, nil
 }
 
+// evalNot accepts a parser.ExprNot expression and performs logical NOT.
+func evalNot(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	exprNot, ok := expr.(parser.ExprNot)
+	if !ok {
+		err = fmt.Errorf("failed to assert expression as not")
+		return
+	}
+
+	operand, err := eval(exprNot.Expr, ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to evaluate not operand: %w", err)
+		return
+	}
+
+	operandBoolean, ok := operand.(parser.ExprBoolean)
+	if !ok {
+		err = withTypes(fmt.Errorf("%w: got %s", ErrBooleanOperation, TypeName(operand)), operand)
+		return
+	}
+
+	return parser.ExprBoolean{Value: !operandBoolean.Value}, nil
+}
+
+// evalUnaryPlus accepts a parser.ExprUnaryPlus expression and returns its
+// number operand unchanged.
+func evalUnaryPlus(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	exprUnaryPlus, ok := expr.(parser.ExprUnaryPlus)
+	if !ok {
+		err = fmt.Errorf("failed to assert expression as unary plus")
+		return
+	}
+
+	operand, err := eval(exprUnaryPlus.Expr, ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to evaluate unary plus operand: %w", err)
+		return
+	}
+
+	if operand.Type() != parser.ExprType_Number {
+		err = withTypes(fmt.Errorf("%w: unary plus requires a number, got %s", ErrIncompatibleTypes, TypeName(operand)), operand)
+		return
+	}
+
+	return operand, nil
+}
+
+// evalNegate accepts a parser.ExprNegate expression and returns its operand
+// negated, which must be a number or a duration.
+func evalNegate(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	exprNegate, ok := expr.(parser.ExprNegate)
+	if !ok {
+		err = fmt.Errorf("failed to assert expression as negate")
+		return
+	}
+
+	operand, err := eval(exprNegate.Expr, ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to evaluate unary minus operand: %w", err)
+		return
+	}
+
+	switch value := operand.(type) {
+	case parser.ExprNumber:
+		return parser.ExprNumber{Value: value.Value.Neg()}, nil
+	case parser.ExprDuration:
+		if value.Value == math.MinInt64 {
+			return nil, errDurationOverflow
+		}
+		return parser.ExprDuration{Value: -value.Value}, nil
+	}
+
+	err = withTypes(fmt.Errorf("%w: unary minus requires a number or duration, got %s", ErrIncompatibleTypes, TypeName(operand)), operand)
+	return
+}
+
+// evalLet evaluates a let binding's value once and then evaluates its body
+// with the name bound to that value.
+func evalLet(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	exprLet, ok := expr.(parser.ExprLet)
+	if !ok {
+		err = fmt.Errorf("failed to assert expression as let")
+		return
+	}
+
+	value, err := eval(exprLet.Value, ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to evaluate let %s value: %w", exprLet.Name, err)
+		return
+	}
+
+	return eval(exprLet.Body, ctx.withBinding(exprLet.Name, value))
+}
+
+// evalLambda rejects a lambda evaluated on its own. Lambdas are only
+// meaningful as arguments to functions such as filter(), which call them
+// through Context.Apply.
+func evalLambda(_ parser.Expr, _ *Context) (ret parser.Expr, err error) {
+	err = fmt.Errorf("%w: lambdas can only be passed as function arguments", ErrInvalidLambda)
+	return
+}
+
 // evalTernary evaluates a ternary conditional expression with short-circuiting.
-func evalTernary(expr parser.Expr, input any) (ret parser.Expr, err error) {
+func evalTernary(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	exprTernary, ok := expr.(parser.ExprTernary)
 	if !ok {
 		err = fmt.Errorf("failed to assert expression as ternary")
@@ -1468,7 +1940,7 @@ func evalTernary(expr parser.Expr, input any) (ret parser.Expr, err error) {
 	}
 
 	// Evaluate condition first
-	conditionExpr, err := Eval(exprTernary.Condition, input)
+	conditionExpr, err := eval(exprTernary.Condition, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate ternary condition: %w", err)
 		return
@@ -1476,7 +1948,7 @@ func evalTernary(expr parser.Expr, input any) (ret parser.Expr, err error) {
 
 	// Condition must be boolean
 	if conditionExpr.Type() != parser.ExprType_Boolean {
-		err = fmt.Errorf("%w: ternary condition must be boolean, got %s", ErrBooleanOperation, conditionExpr)
+		err = withTypes(fmt.Errorf("%w: ternary condition must be boolean, got %s", ErrBooleanOperation, TypeName(conditionExpr)), conditionExpr)
 		return
 	}
 
@@ -1489,7 +1961,7 @@ func evalTernary(expr parser.Expr, input any) (ret parser.Expr, err error) {
 	// Short-circuit: evaluate only the appropriate branch
 	if conditionBoolean.Value {
 		// Evaluate true expression
-		trueExpr, err := Eval(exprTernary.TrueExpr, input)
+		trueExpr, err := eval(exprTernary.TrueExpr, ctx)
 		if err != nil {
 			err = fmt.Errorf("failed to evaluate ternary true expression: %w", err)
 			return nil, err
@@ -1497,7 +1969,7 @@ func evalTernary(expr parser.Expr, input any) (ret parser.Expr, err error) {
 		return trueExpr, nil
 	} else {
 		// Evaluate false expression
-		falseExpr, err := Eval(exprTernary.FalseExpr, input)
+		falseExpr, err := eval(exprTernary.FalseExpr, ctx)
 		if err != nil {
 			err = fmt.Errorf("failed to evaluate ternary false expression: %w", err)
 			return nil, err
@@ -1505,7 +1977,7 @@ func evalTernary(expr parser.Expr, input any) (ret parser.Expr, err error) {
 		return falseExpr, nil
 	}
 }
-func evalList(expr parser.Expr, input any) (ret parser.Expr, err error) {
+func evalList(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	exprList, ok := expr.(parser.ExprList)
 	if !ok {
 		err = fmt.Errorf("failed to assert expression as list")
@@ -1514,7 +1986,7 @@ func evalList(expr parser.Expr, input any) (ret parser.Expr, err error) {
 
 	var evaluatedValues []parser.Expr
 	for _, valueExpr := range exprList.Values {
-		evaluatedValue, err := Eval(valueExpr, input)
+		evaluatedValue, err := eval(valueExpr, ctx)
 		if err != nil {
 			err = fmt.Errorf("failed to evaluate list element: %w", err)
 			return nil, err
@@ -1528,28 +2000,33 @@ func evalList(expr parser.Expr, input any) (ret parser.Expr, err error) {
 }
 
 // evalListIndex evaluates a list indexing operation.
-func evalListIndex(expr parser.Expr, input any) (ret parser.Expr, err error) {
-	exprListIndex, ok := expr.(parser.ExprListIndex)
-	if !ok {
+func evalListIndex(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if _, ok := expr.(parser.ExprListIndex); !ok {
 		err = fmt.Errorf("failed to assert expression as list index")
 		return
 	}
 
-	// Evaluate the list expression
-	listExpr, err := Eval(exprListIndex.List, input)
-	if err != nil {
-		err = fmt.Errorf("failed to evaluate list expression: %w", err)
-		return
+	ret, _, err = evalChainLink(expr, false, ctx)
+	return
+}
+
+// indexList indexes listExpr, an evaluated list or string, with the position
+// expression. A missing index yields null when optional is set.
+func indexList(listExpr, position parser.Expr, optional bool, ctx *Context) (ret parser.Expr, err error) {
+	// Maps reached through a list index (e.g. group_by(...)[true]) are looked
+	// up by key, since the parser only knows the object is a map for literals
+	if listExpr.Type() == parser.ExprType_Map {
+		return indexMap(listExpr, position, optional, ctx)
 	}
 
 	// Check if it's a list or string
 	if listExpr.Type() != parser.ExprType_List && listExpr.Type() != parser.ExprType_String {
-		err = fmt.Errorf("%w: cannot index into non-list expression of type %d", ErrInvalidIndex, listExpr.Type())
+		err = withTypes(fmt.Errorf("%w: cannot index into non-list expression of type %s", ErrInvalidIndex, TypeName(listExpr)), listExpr)
 		return
 	}
 
 	// Evaluate the index expression
-	indexExpr, err := Eval(exprListIndex.Index, input)
+	indexExpr, err := eval(position, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate index expression: %w", err)
 		return
@@ -1557,7 +2034,7 @@ func evalListIndex(expr parser.Expr, input any) (ret parser.Expr, err error) {
 
 	// Check if index is a number
 	if indexExpr.Type() != parser.ExprType_Number {
-		err = fmt.Errorf("%w: index must be a number, got %d", ErrInvalidIndex, indexExpr.Type())
+		err = withTypes(fmt.Errorf("%w: index must be a number, got %s", ErrInvalidIndex, TypeName(indexExpr)), indexExpr)
 		return
 	}
 
@@ -1590,6 +2067,9 @@ func evalListIndex(expr parser.Expr, input any) (ret parser.Expr, err error) {
 
 		// Check bounds
 		if index < 0 || index >= len(list.Values) {
+			if optional {
+				return parser.ExprNull{}, nil
+			}
 			err = fmt.Errorf("%w: index %d is out of bounds for list of length %d", ErrIndexOutOfBounds, index, len(list.Values))
 			return
 		}
@@ -1608,6 +2088,9 @@ func evalListIndex(expr parser.Expr, input any) (ret parser.Expr, err error) {
 
 		// Check bounds for string
 		if index < 0 || index >= len(str.Value) {
+			if optional {
+				return parser.ExprNull{}, nil
+			}
 			err = fmt.Errorf("%w: index %d is out of bounds for string of length %d", ErrIndexOutOfBounds, index, len(str.Value))
 			return
 		}
@@ -1622,30 +2105,29 @@ func evalListIndex(expr parser.Expr, input any) (ret parser.Expr, err error) {
 }
 
 // evalListSlice evaluates a list slicing operation like list[start:end].
-func evalListSlice(expr parser.Expr, input any) (ret parser.Expr, err error) {
-	exprListSlice, ok := expr.(parser.ExprListSlice)
-	if !ok {
+func evalListSlice(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if _, ok := expr.(parser.ExprListSlice); !ok {
 		err = fmt.Errorf("failed to assert expression as list slice")
 		return
 	}
 
-	// Evaluate the list expression
-	listExpr, err := Eval(exprListSlice.List, input)
-	if err != nil {
-		err = fmt.Errorf("failed to evaluate list expression: %w", err)
-		return
-	}
+	ret, _, err = evalChainLink(expr, false, ctx)
+	return
+}
 
+// sliceList slices listExpr, an evaluated list or string, with the start
+// and end expressions of exprListSlice.
+func sliceList(exprListSlice parser.ExprListSlice, listExpr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	// Check if it's a list or string
 	if listExpr.Type() != parser.ExprType_List && listExpr.Type() != parser.ExprType_String {
-		err = fmt.Errorf("%w: cannot slice non-list expression of type %d", ErrIncompatibleTypes, listExpr.Type())
+		err = withTypes(fmt.Errorf("%w: cannot slice non-list expression of type %s", ErrIncompatibleTypes, TypeName(listExpr)), listExpr)
 		return
 	}
 
 	// Evaluate the start index if provided
 	var startIndex int
 	if exprListSlice.Start != nil {
-		startExpr, err := Eval(exprListSlice.Start, input)
+		startExpr, err := eval(exprListSlice.Start, ctx)
 		if err != nil {
 			err = fmt.Errorf("failed to evaluate start expression: %w", err)
 			return nil, err
@@ -1653,7 +2135,7 @@ func evalListSlice(expr parser.Expr, input any) (ret parser.Expr, err error) {
 
 		// Check if start index is a number
 		if startExpr.Type() != parser.ExprType_Number {
-			err = fmt.Errorf("%w: start index must be a number, got %d", ErrInvalidIndex, startExpr.Type())
+			err = withTypes(fmt.Errorf("%w: start index must be a number, got %s", ErrInvalidIndex, TypeName(startExpr)), startExpr)
 			return nil, err
 		}
 
@@ -1683,7 +2165,7 @@ func evalListSlice(expr parser.Expr, input any) (ret parser.Expr, err error) {
 	// Evaluate the end index if provided
 	var endIndex int
 	if exprListSlice.End != nil {
-		endExpr, err := Eval(exprListSlice.End, input)
+		endExpr, err := eval(exprListSlice.End, ctx)
 		if err != nil {
 			err = fmt.Errorf("failed to evaluate end expression: %w", err)
 			return nil, err
@@ -1691,7 +2173,7 @@ func evalListSlice(expr parser.Expr, input any) (ret parser.Expr, err error) {
 
 		// Check if end index is a number
 		if endExpr.Type() != parser.ExprType_Number {
-			err = fmt.Errorf("%w: end index must be a number, got %d", ErrInvalidIndex, endExpr.Type())
+			err = withTypes(fmt.Errorf("%w: end index must be a number, got %s", ErrInvalidIndex, TypeName(endExpr)), endExpr)
 			return nil, err
 		}
 
@@ -1812,8 +2294,10 @@ func evalListSlice(expr parser.Expr, input any) (ret parser.Expr, err error) {
 	}
 }
 
-// evalVariable evaluates a variable expression like `_` and returns its value from the input context.
-func evalVariable(expr parser.Expr, input any) (ret parser.Expr, err error) {
+// evalVariable evaluates a variable expression. `_` returns the current
+// predicate element, and any other name is looked up in the enclosing let
+// bindings and then the context's variables.
+func evalVariable(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	exprVariable, ok := expr.(parser.ExprVariable)
 	if !ok {
 		err = fmt.Errorf("failed to assert expression as variable")
@@ -1824,17 +2308,30 @@ func evalVariable(expr parser.Expr, input any) (ret parser.Expr, err error) {
 
 	// Handle the special underscore variable used in filter operations
 	if variableName == "_" {
-		// Convert the input to an expression to return as the value of the variable
-		return convertInputToExpr(input)
+		if ctx.element == nil {
+			err = fmt.Errorf("%w: _ is only bound inside predicates such as filter()", ErrUndefinedVariable)
+			return
+		}
+		return ctx.element, nil
 	}
 
-	// For other variables (if any), return an error since they're not supported yet
-	err = fmt.Errorf("undefined variable: %s", variableName)
-	return
+	// Bindings from enclosing `let` expressions shadow the context's variables
+	if bound, exists := ctx.scope.lookup(variableName); exists {
+		return bound, nil
+	}
+
+	// Named variables are provided with the evaluation context
+	value, exists := ctx.Variables[variableName]
+	if !exists {
+		err = fmt.Errorf("%w: %s", ErrUndefinedVariable, variableName)
+		return
+	}
+
+	return convertInputToExpr(value)
 }
 
 // evalMap evaluates a map expression by evaluating all its key-value pairs.
-func evalMap(expr parser.Expr, input any) (ret parser.Expr, err error) {
+func evalMap(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	exprMap, ok := expr.(parser.ExprMap)
 	if !ok {
 		err = fmt.Errorf("failed to assert expression as map")
@@ -1844,14 +2341,14 @@ func evalMap(expr parser.Expr, input any) (ret parser.Expr, err error) {
 	var evaluatedPairs []parser.ExprMapPair
 	for _, pair := range exprMap.Pairs {
 		// Evaluate the key expression
-		evaluatedKey, err := Eval(pair.Key, input)
+		evaluatedKey, err := eval(pair.Key, ctx)
 		if err != nil {
 			err = fmt.Errorf("failed to evaluate map key: %w", err)
 			return nil, err
 		}
 
 		// Evaluate the value expression
-		evaluatedValue, err := Eval(pair.Value, input)
+		evaluatedValue, err := eval(pair.Value, ctx)
 		if err != nil {
 			err = fmt.Errorf("failed to evaluate map value: %w", err)
 			return nil, err
@@ -1869,23 +2366,35 @@ func evalMap(expr parser.Expr, input any) (ret parser.Expr, err error) {
 }
 
 // evalMapIndex evaluates a map indexing operation.
-func evalMapIndex(expr parser.Expr, input any) (ret parser.Expr, err error) {
-	exprMapIndex, ok := expr.(parser.ExprMapIndex)
-	if !ok {
+func evalMapIndex(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if _, ok := expr.(parser.ExprMapIndex); !ok {
 		err = fmt.Errorf("failed to assert expression as map index")
 		return
 	}
 
-	// Evaluate the map expression
-	mapExpr, err := Eval(exprMapIndex.Map, input)
-	if err != nil {
-		err = fmt.Errorf("failed to evaluate map expression: %w", err)
-		return
+	ret, _, err = evalChainLink(expr, false, ctx)
+	return
+}
+
+// indexMap looks up the index expression in mapExpr, an evaluated map. A
+// missing key yields null when optional is set.
+func indexMap(mapExpr, index parser.Expr, optional bool, ctx *Context) (ret parser.Expr, err error) {
+	// Lists and strings reached through a map index (e.g. $["items"][0]) are
+	// indexed positionally when the index is a number
+	if mapExpr.Type() == parser.ExprType_List || mapExpr.Type() == parser.ExprType_String {
+		indexExpr, err := eval(index, ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate index expression: %w", err)
+		}
+
+		if indexExpr.Type() == parser.ExprType_Number {
+			return indexList(mapExpr, indexExpr, optional, ctx)
+		}
 	}
 
 	// Check if it's actually a map
 	if mapExpr.Type() != parser.ExprType_Map {
-		err = fmt.Errorf("%w: cannot index into non-map expression of type %d", ErrInvalidMapIndex, mapExpr.Type())
+		err = withTypes(fmt.Errorf("%w: cannot index into non-map expression of type %s", ErrInvalidMapIndex, TypeName(mapExpr)), mapExpr)
 		return
 	}
 
@@ -1896,7 +2405,7 @@ func evalMapIndex(expr parser.Expr, input any) (ret parser.Expr, err error) {
 	}
 
 	// Evaluate the index expression
-	indexExpr, err := Eval(exprMapIndex.Index, input)
+	indexExpr, err := eval(index, ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate index expression: %w", err)
 		return
@@ -1916,10 +2425,191 @@ func evalMapIndex(expr parser.Expr, input any) (ret parser.Expr, err error) {
 	}
 
 	// Key not found
-	err = fmt.Errorf("%w: key %v not found in map", ErrKeyNotFound, indexExpr)
+	if optional {
+		return parser.ExprNull{}, nil
+	}
+	if key, ok := indexExpr.(parser.ExprString); ok {
+		err = fmt.Errorf("%w: key %q not found in map", ErrKeyNotFound, key.Value)
+		return
+	}
+	err = withTypes(fmt.Errorf("%w: %s key not found in map", ErrKeyNotFound, TypeName(indexExpr)), indexExpr)
+	return
+}
+
+// evalFieldAccess evaluates a dot-path field access operation.
+func evalFieldAccess(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if _, ok := expr.(parser.ExprFieldAccess); !ok {
+		err = fmt.Errorf("failed to assert expression as field access")
+		return
+	}
+
+	ret, _, err = evalChainLink(expr, false, ctx)
+	return
+}
+
+// evalChainLink evaluates expr as a link in a chain of field accesses,
+// indexes and slices, such as `$.order?.items[0].sku`. skipped reports that
+// an optional access in the chain found a null or missing value, which cuts
+// the rest of the chain short so that it evaluates to null. When lenient is
+// set, a missing field, key or index at expr itself yields null, as the
+// optional access following it expects.
+func evalChainLink(expr parser.Expr, lenient bool, ctx *Context) (ret parser.Expr, skipped bool, err error) {
+	switch e := expr.(type) {
+	case parser.ExprFieldAccess:
+		// Paths rooted directly at the input data are resolved against the
+		// raw Go value, which lets them reach into structs without
+		// converting the whole input first
+		if path, ok := inputFieldPath(e); ok && !lenient {
+			if _, isExpr := ctx.Input.(parser.Expr); !isExpr {
+				value, lookupErr := internal.LookupPath(ctx.Input, path)
+				if lookupErr != nil {
+					return nil, false, lookupPathError(lookupErr)
+				}
+
+				ret, err = convertInputToExpr(value)
+				return ret, false, err
+			}
+		}
+
+		objectExpr, skipped, err := evalChainObject(e.Object, e.Optional, ctx)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to evaluate field access object: %w", err)
+		}
+		if skipped {
+			return parser.ExprNull{}, true, nil
+		}
+
+		ret, err = accessField(objectExpr, e.Field, e.Optional || lenient)
+		return ret, false, err
+	case parser.ExprMapIndex:
+		mapExpr, skipped, err := evalChainObject(e.Map, e.Optional, ctx)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to evaluate map expression: %w", err)
+		}
+		if skipped {
+			return parser.ExprNull{}, true, nil
+		}
+
+		ret, err = indexMap(mapExpr, e.Index, e.Optional || lenient, ctx)
+		return ret, false, err
+	case parser.ExprListIndex:
+		listExpr, skipped, err := evalChainObject(e.List, e.Optional, ctx)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to evaluate list expression: %w", err)
+		}
+		if skipped {
+			return parser.ExprNull{}, true, nil
+		}
+
+		ret, err = indexList(listExpr, e.Index, e.Optional || lenient, ctx)
+		return ret, false, err
+	case parser.ExprListSlice:
+		listExpr, skipped, err := evalChainObject(e.List, false, ctx)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to evaluate list expression: %w", err)
+		}
+		if skipped {
+			return parser.ExprNull{}, true, nil
+		}
+
+		ret, err = sliceList(e, listExpr, ctx)
+		return ret, false, err
+	default:
+		ret, err = eval(expr, ctx)
+		return ret, false, err
+	}
+}
+
+// evalChainObject evaluates object, the value accessed by a link in a chain.
+// When the link is optional, a null or missing object skips the rest of the
+// chain.
+func evalChainObject(object parser.Expr, optional bool, ctx *Context) (ret parser.Expr, skipped bool, err error) {
+	ret, skipped, err = evalChainLink(object, optional, ctx)
+	if err != nil {
+		if !object.Position().IsZero() {
+			err = wrapError(object, err)
+		}
+		return nil, false, err
+	}
+
+	if skipped || (optional && ret.Type() == parser.ExprType_Null) {
+		return parser.ExprNull{}, true, nil
+	}
+
+	return ret, false, nil
+}
+
+// accessField looks up field in objectExpr, an evaluated map. A missing field
+// yields null when optional is set.
+func accessField(objectExpr parser.Expr, field string, optional bool) (ret parser.Expr, err error) {
+	if objectExpr.Type() != parser.ExprType_Map {
+		err = withTypes(fmt.Errorf("%w: cannot access field %q on non-map expression of type %s", ErrInvalidMapIndex, field, TypeName(objectExpr)), objectExpr)
+		return
+	}
+
+	mapValue, ok := objectExpr.(parser.ExprMap)
+	if !ok {
+		err = fmt.Errorf("failed to assert expression as map")
+		return
+	}
+
+	fieldExpr := parser.ExprString{Value: field}
+	for _, pair := range mapValue.Pairs {
+		isEqual, err := areExpressionsEqual(pair.Key, fieldExpr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compare map keys: %w", err)
+		}
+
+		if isEqual {
+			return pair.Value, nil
+		}
+	}
+
+	if optional {
+		return parser.ExprNull{}, nil
+	}
+
+	err = fmt.Errorf("%w: key %q not found in map", ErrKeyNotFound, field)
 	return
 }
 
+// lookupPathError wraps an error from internal.LookupPath in the error that
+// evaluating the same field accesses one at a time would have returned.
+func lookupPathError(lookupErr error) error {
+	switch {
+	case errors.Is(lookupErr, internal.ErrNotFound):
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, lookupErr)
+	case errors.Is(lookupErr, internal.ErrOutOfBounds):
+		return fmt.Errorf("%w: %s", ErrIndexOutOfBounds, lookupErr)
+	case errors.Is(lookupErr, internal.ErrCannotLookup):
+		return fmt.Errorf("%w: %s", ErrInvalidMapIndex, lookupErr)
+	default:
+		return fmt.Errorf("failed to look up field: %w", lookupErr)
+	}
+}
+
+// inputFieldPath returns the list of field names for a chain of field accesses
+// rooted at the input data (e.g. `$.user.name` returns ["user", "name"]).
+func inputFieldPath(expr parser.ExprFieldAccess) (path []string, ok bool) {
+	var current parser.Expr = expr
+	for {
+		switch e := current.(type) {
+		case parser.ExprFieldAccess:
+			// Optional fields are resolved by evalFieldAccess, which can
+			// tell a missing field apart from other lookup errors
+			if e.Optional {
+				return nil, false
+			}
+			path = append([]string{e.Field}, path...)
+			current = e.Object
+		case parser.ExprInput:
+			return path, true
+		default:
+			return nil, false
+		}
+	}
+}
+
 // areExpressionsEqual checks if two expressions are equal for map key comparison.
 func areExpressionsEqual(expr1, expr2 parser.Expr) (bool, error) {
 	// If both expressions are of the same type, compare directly
@@ -1949,6 +2639,12 @@ func areExpressionsEqual(expr1, expr2 parser.Expr) (bool, error) {
 			}
 			return bool1.Value == bool2.Value, nil
 
+		case parser.ExprType_Null:
+			return true, nil
+
+		case parser.ExprType_Time, parser.ExprType_Duration:
+			return compareTemporal(expr1, expr2) == 0, nil
+
 		default:
 			// For other types, we don't support them as map keys
 			return false, fmt.Errorf("unsupported map key type: %s", expr1.String())
@@ -1995,34 +2691,117 @@ func areExpressionsEqual(expr1, expr2 parser.Expr) (bool, error) {
 }
 
 // evalFunction evaluates a function call expression.
-func evalFunction(expr parser.Expr, input any) (ret parser.Expr, err error) {
+func evalFunction(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	exprFunction, ok := expr.(parser.ExprFunction)
 	if !ok {
 		err = fmt.Errorf("failed to assert expression as function")
 		return
 	}
 
-	// Look up the function in the registry
-	functionFunc, exists := functionRegistry[exprFunction.Name]
+	return callFunction(exprFunction.Name, exprFunction.Args, ctx)
+}
+
+// evalPipe evaluates a pipe expression by evaluating the piped value and then
+// calling the function on the right with that value as its first argument.
+func evalPipe(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	exprPipe, ok := expr.(parser.ExprPipe)
+	if !ok {
+		err = fmt.Errorf("failed to assert expression as pipe")
+		return
+	}
+
+	value, err := eval(exprPipe.Value, ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to evaluate value piped into %s(): %w", exprPipe.Call.Name, err)
+		return
+	}
+
+	args := make([]parser.Expr, 0, len(exprPipe.Call.Args)+1)
+	args = append(args, value)
+	args = append(args, exprPipe.Call.Args...)
+	return callFunction(exprPipe.Call.Name, args, ctx)
+}
+
+// evalCoalesce evaluates the left expression, falling back to the right
+// expression when it is null. A missing field, key or index anywhere in the
+// chain of accesses on the left counts as null, so `$.order.discount ?? 0`
+// works whether order or discount is null or absent.
+func evalCoalesce(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	exprCoalesce, ok := expr.(parser.ExprCoalesce)
+	if !ok {
+		err = fmt.Errorf("failed to assert expression as coalesce")
+		return
+	}
+
+	left, err := eval(optionalChain(exprCoalesce.Left), ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to evaluate first expression: %w", err)
+		return
+	}
+
+	if left.Type() != parser.ExprType_Null {
+		return left, nil
+	}
+
+	right, err := eval(exprCoalesce.Right, ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to evaluate second expression: %w", err)
+		return
+	}
+
+	return right, nil
+}
+
+// optionalChain returns expr with every field access and index in its chain
+// made optional, as if each had been written with ?. or ?[. Index
+// expressions and slice bounds are left unchanged.
+func optionalChain(expr parser.Expr) parser.Expr {
+	switch e := expr.(type) {
+	case parser.ExprFieldAccess:
+		e.Object = optionalChain(e.Object)
+		e.Optional = true
+		return e
+	case parser.ExprMapIndex:
+		e.Map = optionalChain(e.Map)
+		e.Optional = true
+		return e
+	case parser.ExprListIndex:
+		e.List = optionalChain(e.List)
+		e.Optional = true
+		return e
+	case parser.ExprListSlice:
+		e.List = optionalChain(e.List)
+		return e
+	default:
+		return expr
+	}
+}
+
+// callFunction looks up the named function in the host-registered functions,
+// then the built-in registry, and calls it with the unevaluated arguments.
+func callFunction(name string, args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	functionFunc, exists := ctx.Functions[name]
+	if !exists {
+		functionFunc, exists = functionRegistry[name]
+	}
 	if !exists {
-		err = fmt.Errorf("%w: %s", ErrUndefinedFunction, exprFunction.Name)
+		err = fmt.Errorf("%w: %s", ErrUndefinedFunction, name)
 		return
 	}
 
-	// Call the function with the evaluated arguments
-	return functionFunc(exprFunction.Args, input)
+	return functionFunc(args, ctx)
 }
 
 // evalLenFunction implements the len() built-in function.
 // Returns the length of strings, lists, and maps.
-func evalLenFunction(args []parser.Expr, input any) (ret parser.Expr, err error) {
+func evalLenFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	if len(args) != 1 {
 		err = fmt.Errorf("%w: len() expects exactly 1 argument, got %d", ErrInvalidArgumentCount, len(args))
 		return
 	}
 
 	// Evaluate the argument
-	argExpr, err := Eval(args[0], input)
+	argExpr, err := eval(args[0], ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate len() argument: %w", err)
 		return
@@ -2053,44 +2832,78 @@ func evalLenFunction(args []parser.Expr, input any) (ret parser.Expr, err error)
 		}
 		return parser.ExprNumber{Value: decimal.NewFromInt(int64(len(exprMap.Pairs)))}, nil
 
+	case parser.ExprType_Null:
+		// Null has no elements
+		return parser.ExprNumber{Value: decimal.Zero}, nil
+
 	case parser.ExprType_Number:
 		// For numbers, return error as per ticket specification
-		err = fmt.Errorf("%w: len() cannot be applied to numbers", ErrInvalidArgumentType)
+		err = withTypes(fmt.Errorf("%w: len() cannot be applied to numbers", ErrInvalidArgumentType), argExpr)
 		return
 
 	case parser.ExprType_Boolean:
 		// For booleans, return error as per ticket specification
-		err = fmt.Errorf("%w: len() cannot be applied to booleans", ErrInvalidArgumentType)
+		err = withTypes(fmt.Errorf("%w: len() cannot be applied to booleans", ErrInvalidArgumentType), argExpr)
 		return
 
 	default:
-		err = fmt.Errorf("%w: len() cannot be applied to type %s", ErrInvalidArgumentType, argExpr.String())
+		err = withTypes(fmt.Errorf("%w: len() cannot be applied to type %s", ErrInvalidArgumentType, TypeName(argExpr)), argExpr)
 		return
 	}
 }
 
 // evalContainsFunction implements the contains() built-in function.
 // Checks if a value exists within a list, string, or map.
-func evalContainsFunction(args []parser.Expr, input any) (ret parser.Expr, err error) {
+func evalContainsFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	if len(args) != 2 {
 		err = fmt.Errorf("%w: contains() expects exactly 2 arguments, got %d", ErrInvalidArgumentCount, len(args))
 		return
 	}
 
 	// Evaluate the first argument (the container)
-	containerArg, err := Eval(args[0], input)
+	containerArg, err := eval(args[0], ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate contains() container argument: %w", err)
 		return
 	}
 
 	// Evaluate the second argument (the search value)
-	searchArg, err := Eval(args[1], input)
+	searchArg, err := eval(args[1], ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate contains() search argument: %w", err)
 		return
 	}
 
+	return containsValue("contains()", containerArg, searchArg)
+}
+
+// evalIn evaluates a membership test, with the same semantics as contains()
+// with its arguments swapped.
+func evalIn(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	exprIn, ok := expr.(parser.ExprIn)
+	if !ok {
+		err = fmt.Errorf("failed to assert expression as in")
+		return
+	}
+
+	value, err := eval(exprIn.Value, ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to evaluate first expression: %w", err)
+		return
+	}
+
+	collection, err := eval(exprIn.Collection, ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to evaluate second expression: %w", err)
+		return
+	}
+
+	return containsValue("the in operator", collection, value)
+}
+
+// containsValue reports whether searchArg is an element of a list, a key of a
+// map or a substring of a string. operation names the caller in errors.
+func containsValue(operation string, containerArg, searchArg parser.Expr) (ret parser.Expr, err error) {
 	switch containerArg.Type() {
 	case parser.ExprType_String:
 		containerStr, ok := containerArg.(parser.ExprString)
@@ -2152,18 +2965,22 @@ func evalContainsFunction(args []parser.Expr, input any) (ret parser.Expr, err e
 		}
 		return parser.ExprBoolean{Value: false}, nil
 
+	case parser.ExprType_Null:
+		// Null contains nothing
+		return parser.ExprBoolean{Value: false}, nil
+
 	case parser.ExprType_Number:
 		// For numbers, return error as per ticket specification
-		err = fmt.Errorf("%w: contains() cannot be applied to numbers", ErrInvalidArgumentType)
+		err = withTypes(fmt.Errorf("%w: %s cannot be applied to numbers", ErrInvalidArgumentType, operation), containerArg)
 		return
 
 	case parser.ExprType_Boolean:
 		// For booleans, return error as per ticket specification
-		err = fmt.Errorf("%w: contains() cannot be applied to booleans", ErrInvalidArgumentType)
+		err = withTypes(fmt.Errorf("%w: %s cannot be applied to booleans", ErrInvalidArgumentType, operation), containerArg)
 		return
 
 	default:
-		err = fmt.Errorf("%w: contains() cannot be applied to type %s", ErrInvalidArgumentType, containerArg.String())
+		err = withTypes(fmt.Errorf("%w: %s cannot be applied to type %s", ErrInvalidArgumentType, operation, TypeName(containerArg)), containerArg)
 		return
 	}
 }
@@ -2173,121 +2990,2143 @@ func containsString(s, substr string) bool {
 	return strings.Contains(s, substr)
 }
 
-// evalFilterFunction implements the filter() built-in function.
-// Filters a list based on a boolean expression using `_` as the element placeholder.
-func evalFilterFunction(args []parser.Expr, input any) (ret parser.Expr, err error) {
-	if len(args) != 2 {
-		err = fmt.Errorf("%w: filter() expects exactly 2 arguments, got %d", ErrInvalidArgumentCount, len(args))
+// evalMatch evaluates a `value =~ pattern` expression.
+func evalMatch(expr parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	exprMatch, ok := expr.(parser.ExprMatch)
+	if !ok {
+		err = fmt.Errorf("failed to assert expression as match")
 		return
 	}
 
-	// Evaluate the first argument (the list to filter)
-	listArg, err := Eval(args[0], input)
+	value, err := eval(exprMatch.Value, ctx)
 	if err != nil {
-		err = fmt.Errorf("failed to evaluate filter() list argument: %w", err)
+		err = fmt.Errorf("failed to evaluate first expression: %w", err)
 		return
 	}
 
-	// Check that the first argument is a list
-	if listArg.Type() != parser.ExprType_List {
-		err = fmt.Errorf("%w: filter() first argument must be a list, got %s", ErrInvalidArgumentType, listArg.String())
+	str, ok := value.(parser.ExprString)
+	if !ok {
+		err = withTypes(fmt.Errorf("%w: the =~ operator requires a string, got %s", ErrInvalidArgumentType, TypeName(value)), value)
+		return
+	}
+
+	re, ok := exprMatch.Pattern.(parser.ExprRegex)
+	if ok {
+		return parser.ExprBoolean{Value: re.Regexp.MatchString(str.Value)}, nil
+	}
+
+	pattern, err := eval(exprMatch.Pattern, ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to evaluate second expression: %w", err)
 		return
 	}
 
-	exprList, ok := listArg.(parser.ExprList)
+	patternString, ok := pattern.(parser.ExprString)
 	if !ok {
-		err = fmt.Errorf("failed to assert expression as list")
+		err = withTypes(fmt.Errorf("%w: the =~ operator requires a string pattern, got %s", ErrInvalidArgumentType, TypeName(pattern)), pattern)
 		return
 	}
 
-	// The second argument is the filter expression with `_` as placeholder
-	filterExpr := args[1]
+	regex, err := compileRegex(patternString.Value)
+	if err != nil {
+		return
+	}
 
-	// Create a result list to store filtered elements
-	var filteredValues []parser.Expr
+	return parser.ExprBoolean{Value: regex.MatchString(str.Value)}, nil
+}
 
-	// Iterate through each element in the input list
-	for _, element := range exprList.Values {
-		// Create a custom evaluation context where `_` is treated as the current element
-		// We need to create a mechanism to substitute `_` during evaluation
-		// In this case, we'll need to have a special eval function that can handle `_` as an identifier
-		// For now, let me check if there's an identifier type
+// evalRegex returns the source of a compiled regular expression literal, for
+// patterns that are used as plain strings.
+func evalRegex(expr parser.Expr, _ *Context) (ret parser.Expr, err error) {
+	exprRegex, ok := expr.(parser.ExprRegex)
+	if !ok {
+		err = fmt.Errorf("failed to assert expression as regex")
+		return
+	}
 
-		// For the purpose of this implementation, we'll need to create a custom evaluation
-		// where the underscore `_` is treated as a special variable containing the current element
-		// This could be done by creating a context or by implementing a custom eval that understands `_`
+	return parser.ExprString{Value: exprRegex.Regexp.String()}, nil
+}
 
-		// For now, let me implement a simple approach by wrapping the element in a map
-		// where we could potentially use `$._` for the underscore, but that's not what the requirement says.
-		// The requirement is to replace `_` with the element value during evaluation.
+// evalStringArgument evaluates an argument of a function that requires a
+// string. position names the argument in error messages, such as "first".
+func evalStringArgument(functionName, position string, arg parser.Expr, ctx *Context) (str string, err error) {
+	argExpr, err := eval(arg, ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to evaluate %s() %s argument: %w", functionName, position, err)
+		return
+	}
 
-		// The cleanest approach would be to implement an expression visitor that can substitute `_` with the element
-		// However, for now, let's implement a simplified approach by creating a custom evaluation context
-		// based on the input data, where the underscore is treated specially.
+	exprString, ok := argExpr.(parser.ExprString)
+	if !ok {
+		err = withTypes(fmt.Errorf("%w: %s() %s argument must be a string, got %s", ErrInvalidArgumentType, functionName, position, TypeName(argExpr)), argExpr)
+		return
+	}
 
-		// We can implement this by modifying Eval to recognize a special context where `_` is the current element
-		// For this, I need to first identify what `_` would be parsed as.
-		// Let me implement a basic version that passes the element as input and handles `_` specially.
+	return exprString.Value, nil
+}
 
-		// For this implementation, we need to understand how `_` should be parsed.
-		// Since `_` is typically an identifier, I'll need to create a custom evaluation context.
+// evalRegexArgument returns the regular expression passed to a function.
+// String literals are compiled by the parser; other patterns are compiled
+// each time they are evaluated.
+func evalRegexArgument(functionName string, arg parser.Expr, ctx *Context) (*regexp.Regexp, error) {
+	if exprRegex, ok := arg.(parser.ExprRegex); ok {
+		return exprRegex.Regexp, nil
+	}
 
-		// The approach here is to evaluate the filter expression in a context where `_` refers to the current element
-		// Let me create a custom evaluation function that handles this case
-		result, evalErr := evalFilterExpression(filterExpr, element)
-		if evalErr != nil {
-			err = fmt.Errorf("failed to evaluate filter expression: %w", evalErr)
-			return nil, err
+	pattern, err := evalStringArgument(functionName, "pattern", arg, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return compileRegex(pattern)
+}
+
+// compileRegex compiles a pattern that was not known when the query was
+// parsed, wrapping ErrInvalidRegex on failure.
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRegex, err)
+	}
+
+	return re, nil
+}
+
+// evalMatchesFunction implements the matches() built-in function.
+// Returns whether the regular expression matches any part of the string.
+func evalMatchesFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 2 {
+		err = fmt.Errorf("%w: matches() expects exactly 2 arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	str, err := evalStringArgument("matches", "first", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	re, err := evalRegexArgument("matches", args[1], ctx)
+	if err != nil {
+		return
+	}
+
+	return parser.ExprBoolean{Value: re.MatchString(str)}, nil
+}
+
+// evalFindAllFunction implements the find_all() built-in function.
+// Returns a list of every non-overlapping match of the regular expression.
+func evalFindAllFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 2 {
+		err = fmt.Errorf("%w: find_all() expects exactly 2 arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	str, err := evalStringArgument("find_all", "first", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	re, err := evalRegexArgument("find_all", args[1], ctx)
+	if err != nil {
+		return
+	}
+
+	matches := re.FindAllString(str, -1)
+	values := make([]parser.Expr, len(matches))
+	for i, match := range matches {
+		values[i] = parser.ExprString{Value: match}
+	}
+
+	return parser.ExprList{Values: values}, nil
+}
+
+// evalReplaceReFunction implements the replace_re() built-in function.
+// Replaces every match of the regular expression, expanding $1 and ${name}
+// in the replacement to the text of the corresponding group.
+func evalReplaceReFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 3 {
+		err = fmt.Errorf("%w: replace_re() expects exactly 3 arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	str, err := evalStringArgument("replace_re", "first", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	re, err := evalRegexArgument("replace_re", args[1], ctx)
+	if err != nil {
+		return
+	}
+
+	replacement, err := evalStringArgument("replace_re", "third", args[2], ctx)
+	if err != nil {
+		return
+	}
+
+	return parser.ExprString{Value: re.ReplaceAllString(str, replacement)}, nil
+}
+
+// evalCaptureFunction implements the capture() built-in function.
+// Returns a map of the named groups in the first match of the regular
+// expression, or null if it does not match. Groups that did not take part in
+// the match are null.
+func evalCaptureFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 2 {
+		err = fmt.Errorf("%w: capture() expects exactly 2 arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	str, err := evalStringArgument("capture", "first", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	re, err := evalRegexArgument("capture", args[1], ctx)
+	if err != nil {
+		return
+	}
+
+	match := re.FindStringSubmatchIndex(str)
+	if match == nil {
+		return parser.ExprNull{}, nil
+	}
+
+	var pairs []parser.ExprMapPair
+	for i, name := range re.SubexpNames() {
+		if name == "" {
+			continue
 		}
 
-		// Check that the result is a boolean
-		if result.Type() != parser.ExprType_Boolean {
-			err = fmt.Errorf("%w: filter expression must evaluate to a boolean, got %s", ErrInvalidArgumentType, result.String())
-			return
+		var value parser.Expr = parser.ExprNull{}
+		if start, end := match[2*i], match[2*i+1]; start >= 0 {
+			value = parser.ExprString{Value: str[start:end]}
 		}
+		pairs = append(pairs, parser.ExprMapPair{Key: parser.ExprString{Value: name}, Value: value})
+	}
 
-		// Add element to result if condition is true
-		resultBool, ok := result.(parser.ExprBoolean)
-		if !ok {
-			err = fmt.Errorf("failed to assert result as boolean")
-			return
+	return parser.ExprMap{Pairs: pairs}, nil
+}
+
+// evalIntegerArgument evaluates an argument of a function that requires an
+// integer. position names the argument in error messages, such as "second".
+func evalIntegerArgument(functionName, position string, arg parser.Expr, ctx *Context) (n int, err error) {
+	argExpr, err := eval(arg, ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to evaluate %s() %s argument: %w", functionName, position, err)
+		return
+	}
+
+	exprNumber, ok := argExpr.(parser.ExprNumber)
+	if !ok {
+		err = withTypes(fmt.Errorf("%w: %s() %s argument must be a number, got %s", ErrInvalidArgumentType, functionName, position, TypeName(argExpr)), argExpr)
+		return
+	}
+
+	if !exprNumber.Value.IsInteger() {
+		err = fmt.Errorf("%w: %s() %s argument must be an integer", ErrInvalidArgumentType, functionName, position)
+		return
+	}
+
+	if exprNumber.Value.LessThan(decimal.NewFromInt(math.MinInt)) || exprNumber.Value.GreaterThan(decimal.NewFromInt(math.MaxInt)) {
+		err = fmt.Errorf("%w: %s() %s argument is out of range", ErrInvalidArgumentType, functionName, position)
+		return
+	}
+
+	return int(exprNumber.Value.IntPart()), nil
+}
+
+// evalStringFunction implements a built-in function that maps a string to a
+// string, such as upper().
+func evalStringFunction(functionName string, args []parser.Expr, ctx *Context, f func(string) string) (ret parser.Expr, err error) {
+	if len(args) != 1 {
+		err = fmt.Errorf("%w: %s() expects exactly 1 argument, got %d", ErrInvalidArgumentCount, functionName, len(args))
+		return
+	}
+
+	str, err := evalStringArgument(functionName, "first", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	return parser.ExprString{Value: f(str)}, nil
+}
+
+// evalUpperFunction implements the upper() built-in function.
+func evalUpperFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalStringFunction("upper", args, ctx, strings.ToUpper)
+}
+
+// evalLowerFunction implements the lower() built-in function.
+func evalLowerFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalStringFunction("lower", args, ctx, strings.ToLower)
+}
+
+// evalTitleFunction implements the title() built-in function.
+// Upper-cases the first letter of each whitespace-separated word and
+// lower-cases the rest.
+func evalTitleFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalStringFunction("title", args, ctx, func(s string) string {
+		runes := []rune(s)
+		startOfWord := true
+		for i, r := range runes {
+			if unicode.IsSpace(r) {
+				startOfWord = true
+				continue
+			}
+
+			if startOfWord {
+				runes[i] = unicode.ToTitle(r)
+			} else {
+				runes[i] = unicode.ToLower(r)
+			}
+			startOfWord = false
+		}
+
+		return string(runes)
+	})
+}
+
+// evalReverseFunction implements the reverse() built-in function.
+// Reverses the elements of a list or the runes of a string.
+func evalReverseFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 1 {
+		err = fmt.Errorf("%w: reverse() expects exactly 1 argument, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	argExpr, err := eval(args[0], ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to evaluate reverse() argument: %w", err)
+		return
+	}
+
+	switch value := argExpr.(type) {
+	case parser.ExprList:
+		reversed := make([]parser.Expr, len(value.Values))
+		for i, element := range value.Values {
+			reversed[len(reversed)-1-i] = element
+		}
+		return parser.ExprList{Values: reversed}, nil
+	case parser.ExprString:
+		runes := []rune(value.Value)
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
 		}
+		return parser.ExprString{Value: string(runes)}, nil
+	default:
+		err = withTypes(fmt.Errorf("%w: reverse() can only be applied to lists and strings, got %s", ErrInvalidArgumentType, TypeName(argExpr)), argExpr)
+		return
+	}
+}
+
+// evalTrimFunction implements the trim(), trim_left() and trim_right()
+// built-in functions. With one argument whitespace is removed, and with two
+// any of the runes in the second argument are removed.
+func evalTrimFunction(functionName string, args []parser.Expr, ctx *Context, trimSpace func(string) string, trimCutset func(string, string) string) (ret parser.Expr, err error) {
+	if len(args) < 1 || len(args) > 2 {
+		err = fmt.Errorf("%w: %s() expects 1 or 2 arguments, got %d", ErrInvalidArgumentCount, functionName, len(args))
+		return
+	}
+
+	str, err := evalStringArgument(functionName, "first", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	if len(args) == 1 {
+		return parser.ExprString{Value: trimSpace(str)}, nil
+	}
+
+	cutset, err := evalStringArgument(functionName, "second", args[1], ctx)
+	if err != nil {
+		return
+	}
+
+	return parser.ExprString{Value: trimCutset(str, cutset)}, nil
+}
+
+// evalTrimBothFunction implements the trim() built-in function.
+func evalTrimBothFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalTrimFunction("trim", args, ctx, strings.TrimSpace, strings.Trim)
+}
+
+// evalTrimLeftFunction implements the trim_left() built-in function.
+func evalTrimLeftFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	trimSpace := func(s string) string {
+		return strings.TrimLeftFunc(s, unicode.IsSpace)
+	}
+	return evalTrimFunction("trim_left", args, ctx, trimSpace, strings.TrimLeft)
+}
+
+// evalTrimRightFunction implements the trim_right() built-in function.
+func evalTrimRightFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	trimSpace := func(s string) string {
+		return strings.TrimRightFunc(s, unicode.IsSpace)
+	}
+	return evalTrimFunction("trim_right", args, ctx, trimSpace, strings.TrimRight)
+}
+
+// evalSplitFunction implements the split() built-in function.
+// Splits a string around each occurrence of a separator. An empty separator
+// splits the string into its runes.
+func evalSplitFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 2 {
+		err = fmt.Errorf("%w: split() expects exactly 2 arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	str, err := evalStringArgument("split", "first", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	separator, err := evalStringArgument("split", "second", args[1], ctx)
+	if err != nil {
+		return
+	}
+
+	parts := strings.Split(str, separator)
+	values := make([]parser.Expr, len(parts))
+	for i, part := range parts {
+		values[i] = parser.ExprString{Value: part}
+	}
+
+	return parser.ExprList{Values: values}, nil
+}
+
+// evalJoinFunction implements the join() built-in function.
+// Joins the strings, numbers and booleans in a list with a separator.
+func evalJoinFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 2 {
+		err = fmt.Errorf("%w: join() expects exactly 2 arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	list, err := evalListArgument("join", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	separator, err := evalStringArgument("join", "second", args[1], ctx)
+	if err != nil {
+		return
+	}
+
+	parts := make([]string, len(list.Values))
+	for i, value := range list.Values {
+		switch v := value.(type) {
+		case parser.ExprString:
+			parts[i] = v.Value
+		case parser.ExprNumber:
+			parts[i] = v.Value.String()
+		case parser.ExprBoolean:
+			parts[i] = strconv.FormatBool(v.Value)
+		default:
+			err = withTypes(fmt.Errorf("%w: join() cannot join element %d of type %s", ErrInvalidArgumentType, i, TypeName(value)), value)
+			return
+		}
+	}
+
+	return parser.ExprString{Value: strings.Join(parts, separator)}, nil
+}
+
+// evalAffixFunction implements the starts_with() and ends_with() built-in
+// functions.
+func evalAffixFunction(functionName string, args []parser.Expr, ctx *Context, hasAffix func(string, string) bool) (ret parser.Expr, err error) {
+	if len(args) != 2 {
+		err = fmt.Errorf("%w: %s() expects exactly 2 arguments, got %d", ErrInvalidArgumentCount, functionName, len(args))
+		return
+	}
+
+	str, err := evalStringArgument(functionName, "first", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	affix, err := evalStringArgument(functionName, "second", args[1], ctx)
+	if err != nil {
+		return
+	}
+
+	return parser.ExprBoolean{Value: hasAffix(str, affix)}, nil
+}
+
+// evalStartsWithFunction implements the starts_with() built-in function.
+func evalStartsWithFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalAffixFunction("starts_with", args, ctx, strings.HasPrefix)
+}
+
+// evalEndsWithFunction implements the ends_with() built-in function.
+func evalEndsWithFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalAffixFunction("ends_with", args, ctx, strings.HasSuffix)
+}
+
+// evalReplaceFunction implements the replace() built-in function.
+// Replaces every occurrence of a substring.
+func evalReplaceFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 3 {
+		err = fmt.Errorf("%w: replace() expects exactly 3 arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	str, err := evalStringArgument("replace", "first", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	old, err := evalStringArgument("replace", "second", args[1], ctx)
+	if err != nil {
+		return
+	}
+
+	replacement, err := evalStringArgument("replace", "third", args[2], ctx)
+	if err != nil {
+		return
+	}
+
+	return parser.ExprString{Value: strings.ReplaceAll(str, old, replacement)}, nil
+}
+
+// evalIndexOfFunction implements the index_of() built-in function.
+// Returns the index of the first list element equal to a value, or the rune
+// index of the first occurrence of a substring, or -1.
+func evalIndexOfFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 2 {
+		err = fmt.Errorf("%w: index_of() expects exactly 2 arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	haystack, err := eval(args[0], ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to evaluate index_of() first argument: %w", err)
+		return
+	}
+
+	index := -1
+	switch value := haystack.(type) {
+	case parser.ExprList:
+		needle, evalErr := eval(args[1], ctx)
+		if evalErr != nil {
+			err = fmt.Errorf("failed to evaluate index_of() second argument: %w", evalErr)
+			return
+		}
+
+		for i, element := range value.Values {
+			if isEqual, compareErr := areExpressionsEqual(element, needle); compareErr == nil && isEqual {
+				index = i
+				break
+			}
+		}
+	case parser.ExprString:
+		substr, evalErr := evalStringArgument("index_of", "second", args[1], ctx)
+		if evalErr != nil {
+			return nil, evalErr
+		}
+
+		index = strings.Index(value.Value, substr)
+		if index > 0 {
+			index = utf8.RuneCountInString(value.Value[:index])
+		}
+	default:
+		err = withTypes(fmt.Errorf("%w: index_of() first argument must be a list or string, got %s", ErrInvalidArgumentType, TypeName(haystack)), haystack)
+		return
+	}
+
+	return parser.ExprNumber{Value: decimal.NewFromInt(int64(index))}, nil
+}
+
+// evalPadFunction implements the pad_left() and pad_right() built-in
+// functions. The string is padded to a width in runes by repeating the
+// padding, which defaults to a space.
+func evalPadFunction(functionName string, args []parser.Expr, ctx *Context, left bool) (ret parser.Expr, err error) {
+	if len(args) < 2 || len(args) > 3 {
+		err = fmt.Errorf("%w: %s() expects 2 or 3 arguments, got %d", ErrInvalidArgumentCount, functionName, len(args))
+		return
+	}
+
+	str, err := evalStringArgument(functionName, "first", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	width, err := evalIntegerArgument(functionName, "second", args[1], ctx)
+	if err != nil {
+		return
+	}
+
+	padding := " "
+	if len(args) == 3 {
+		padding, err = evalStringArgument(functionName, "third", args[2], ctx)
+		if err != nil {
+			return
+		}
+
+		if padding == "" {
+			err = fmt.Errorf("%w: %s() third argument must not be empty", ErrInvalidArgumentType, functionName)
+			return
+		}
+	}
+
+	missing := width - utf8.RuneCountInString(str)
+	if missing <= 0 {
+		return parser.ExprString{Value: str}, nil
+	}
+	if width > maxGeneratedLength {
+		err = fmt.Errorf("%w: %s() result would be longer than %d characters", ErrInvalidArgumentType, functionName, maxGeneratedLength)
+		return
+	}
+
+	paddingRunes := []rune(padding)
+	fill := make([]rune, missing)
+	for i := range fill {
+		fill[i] = paddingRunes[i%len(paddingRunes)]
+	}
+
+	if left {
+		return parser.ExprString{Value: string(fill) + str}, nil
+	}
+	return parser.ExprString{Value: str + string(fill)}, nil
+}
+
+// evalPadLeftFunction implements the pad_left() built-in function.
+func evalPadLeftFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalPadFunction("pad_left", args, ctx, true)
+}
+
+// evalPadRightFunction implements the pad_right() built-in function.
+func evalPadRightFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalPadFunction("pad_right", args, ctx, false)
+}
+
+// evalRepeatFunction implements the repeat() built-in function.
+// Repeats a string a non-negative number of times.
+func evalRepeatFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 2 {
+		err = fmt.Errorf("%w: repeat() expects exactly 2 arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	str, err := evalStringArgument("repeat", "first", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	count, err := evalIntegerArgument("repeat", "second", args[1], ctx)
+	if err != nil {
+		return
+	}
+
+	if count < 0 {
+		err = fmt.Errorf("%w: repeat() second argument must be non-negative", ErrInvalidArgumentType)
+		return
+	}
+
+	if length := utf8.RuneCountInString(str); count > 0 && length > maxGeneratedLength/count {
+		err = fmt.Errorf("%w: repeat() result would be longer than %d characters", ErrInvalidArgumentType, maxGeneratedLength)
+		return
+	}
+
+	return parser.ExprString{Value: strings.Repeat(str, count)}, nil
+}
+
+// evalMapArgument evaluates an argument of a function that requires a map.
+// position names the argument in error messages, such as "first".
+func evalMapArgument(functionName, position string, arg parser.Expr, ctx *Context) (exprMap parser.ExprMap, err error) {
+	argExpr, err := eval(arg, ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to evaluate %s() %s argument: %w", functionName, position, err)
+		return
+	}
+
+	exprMap, ok := argExpr.(parser.ExprMap)
+	if !ok {
+		err = withTypes(fmt.Errorf("%w: %s() %s argument must be a map, got %s", ErrInvalidArgumentType, functionName, position, TypeName(argExpr)), argExpr)
+		return
+	}
+
+	return exprMap, nil
+}
+
+// findMapKey returns the index of the pair holding key, or -1.
+func findMapKey(pairs []parser.ExprMapPair, key parser.Expr) (int, error) {
+	for i, pair := range pairs {
+		isEqual, err := areExpressionsEqual(pair.Key, key)
+		if err != nil {
+			return -1, fmt.Errorf("failed to compare map keys: %w", err)
+		}
+
+		if isEqual {
+			return i, nil
+		}
+	}
+
+	return -1, nil
+}
+
+// setMapKey sets key to value, replacing the value of an existing key in
+// place and appending new keys so that key order is preserved.
+func setMapKey(pairs []parser.ExprMapPair, key, value parser.Expr) ([]parser.ExprMapPair, error) {
+	index, err := findMapKey(pairs, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if index >= 0 {
+		pairs[index].Value = value
+		return pairs, nil
+	}
+
+	return append(pairs, parser.ExprMapPair{Key: key, Value: value}), nil
+}
+
+// sortMapPairs orders pairs converted from a Go map by key, since Go's map
+// iteration order is randomized. All keys of converted maps are strings.
+func sortMapPairs(pairs []parser.ExprMapPair) {
+	sort.Slice(pairs, func(i, j int) bool {
+		key1, _ := pairs[i].Key.(parser.ExprString)
+		key2, _ := pairs[j].Key.(parser.ExprString)
+		return key1.Value < key2.Value
+	})
+}
+
+// evalKeysFunction implements the keys() built-in function.
+// Returns the keys of a map in order.
+func evalKeysFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 1 {
+		err = fmt.Errorf("%w: keys() expects exactly 1 argument, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	exprMap, err := evalMapArgument("keys", "first", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	keys := make([]parser.Expr, len(exprMap.Pairs))
+	for i, pair := range exprMap.Pairs {
+		keys[i] = pair.Key
+	}
+
+	return parser.ExprList{Values: keys}, nil
+}
+
+// evalValuesFunction implements the values() built-in function.
+// Returns the values of a map in key order.
+func evalValuesFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 1 {
+		err = fmt.Errorf("%w: values() expects exactly 1 argument, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	exprMap, err := evalMapArgument("values", "first", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	values := make([]parser.Expr, len(exprMap.Pairs))
+	for i, pair := range exprMap.Pairs {
+		values[i] = pair.Value
+	}
+
+	return parser.ExprList{Values: values}, nil
+}
+
+// evalEntriesFunction implements the entries() built-in function.
+// Returns a list of {"key": k, "value": v} maps, one for each pair of a map.
+func evalEntriesFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 1 {
+		err = fmt.Errorf("%w: entries() expects exactly 1 argument, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	exprMap, err := evalMapArgument("entries", "first", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	entries := make([]parser.Expr, len(exprMap.Pairs))
+	for i, pair := range exprMap.Pairs {
+		entries[i] = parser.ExprMap{Pairs: []parser.ExprMapPair{
+			{Key: parser.ExprString{Value: "key"}, Value: pair.Key},
+			{Key: parser.ExprString{Value: "value"}, Value: pair.Value},
+		}}
+	}
+
+	return parser.ExprList{Values: entries}, nil
+}
+
+// evalFromEntriesFunction implements the from_entries() built-in function.
+// Builds a map from a list of {"key": k, "value": v} maps or [k, v] lists.
+// A later entry for the same key replaces the value of an earlier one.
+func evalFromEntriesFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 1 {
+		err = fmt.Errorf("%w: from_entries() expects exactly 1 argument, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	list, err := evalListArgument("from_entries", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	var pairs []parser.ExprMapPair
+	for i, element := range list.Values {
+		var key, value parser.Expr
+		switch entry := element.(type) {
+		case parser.ExprMap:
+			keyIndex, findErr := findMapKey(entry.Pairs, parser.ExprString{Value: "key"})
+			if findErr != nil {
+				return nil, findErr
+			}
+			valueIndex, findErr := findMapKey(entry.Pairs, parser.ExprString{Value: "value"})
+			if findErr != nil {
+				return nil, findErr
+			}
+			if keyIndex < 0 || valueIndex < 0 {
+				err = fmt.Errorf("%w: from_entries() entry %d must have \"key\" and \"value\" fields", ErrInvalidArgumentType, i)
+				return
+			}
+			key, value = entry.Pairs[keyIndex].Value, entry.Pairs[valueIndex].Value
+		case parser.ExprList:
+			if len(entry.Values) != 2 {
+				err = fmt.Errorf("%w: from_entries() entry %d must be a [key, value] list, got %d elements", ErrInvalidArgumentType, i, len(entry.Values))
+				return
+			}
+			key, value = entry.Values[0], entry.Values[1]
+		default:
+			err = withTypes(fmt.Errorf("%w: from_entries() entry %d must be a map or list, got %s", ErrInvalidArgumentType, i, TypeName(element)), element)
+			return
+		}
+
+		pairs, err = setMapKey(pairs, key, value)
+		if err != nil {
+			return
+		}
+	}
+
+	return parser.ExprMap{Pairs: pairs}, nil
+}
+
+// evalMergeArguments evaluates the maps passed to merge() or deep_merge() and
+// merges them from left to right. Null arguments are skipped.
+func evalMergeArguments(functionName string, args []parser.Expr, ctx *Context, deep bool) (ret parser.Expr, err error) {
+	if len(args) == 0 {
+		err = fmt.Errorf("%w: %s() expects at least 1 argument, got 0", ErrInvalidArgumentCount, functionName)
+		return
+	}
+
+	var merged []parser.ExprMapPair
+	for i, arg := range args {
+		argExpr, evalErr := eval(arg, ctx)
+		if evalErr != nil {
+			err = fmt.Errorf("failed to evaluate %s() argument %d: %w", functionName, i+1, evalErr)
+			return
+		}
+
+		if argExpr.Type() == parser.ExprType_Null {
+			continue
+		}
+
+		exprMap, ok := argExpr.(parser.ExprMap)
+		if !ok {
+			err = withTypes(fmt.Errorf("%w: %s() argument %d must be a map, got %s", ErrInvalidArgumentType, functionName, i+1, TypeName(argExpr)), argExpr)
+			return
+		}
+
+		merged, err = mergeMapPairs(merged, exprMap.Pairs, deep)
+		if err != nil {
+			return
+		}
+	}
+
+	return parser.ExprMap{Pairs: merged}, nil
+}
+
+// mergeMapPairs returns a copy of dst with the pairs of src set on it. When
+// deep is set, a map value in src is merged into a map value under the same
+// key in dst instead of replacing it.
+func mergeMapPairs(dst, src []parser.ExprMapPair, deep bool) ([]parser.ExprMapPair, error) {
+	merged := make([]parser.ExprMapPair, len(dst), len(dst)+len(src))
+	copy(merged, dst)
+
+	for _, pair := range src {
+		index, err := findMapKey(merged, pair.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		if index < 0 {
+			merged = append(merged, pair)
+			continue
+		}
+
+		value := pair.Value
+		if deep {
+			dstMap, dstIsMap := merged[index].Value.(parser.ExprMap)
+			srcMap, srcIsMap := pair.Value.(parser.ExprMap)
+			if dstIsMap && srcIsMap {
+				pairs, err := mergeMapPairs(dstMap.Pairs, srcMap.Pairs, true)
+				if err != nil {
+					return nil, err
+				}
+				value = parser.ExprMap{Pairs: pairs}
+			}
+		}
+		merged[index].Value = value
+	}
+
+	return merged, nil
+}
+
+// evalMergeFunction implements the merge() built-in function.
+// Merges maps from left to right, with later values replacing earlier ones.
+func evalMergeFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalMergeArguments("merge", args, ctx, false)
+}
+
+// evalDeepMergeFunction implements the deep_merge() built-in function.
+// Like merge(), but nested maps under the same key are merged recursively.
+func evalDeepMergeFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalMergeArguments("deep_merge", args, ctx, true)
+}
+
+// evalSelectKeys implements the pick() and omit() built-in functions. The
+// pairs of the map whose key is listed in the second argument are kept when
+// keep is set, and dropped otherwise.
+func evalSelectKeys(functionName string, args []parser.Expr, ctx *Context, keep bool) (ret parser.Expr, err error) {
+	if len(args) != 2 {
+		err = fmt.Errorf("%w: %s() expects exactly 2 arguments, got %d", ErrInvalidArgumentCount, functionName, len(args))
+		return
+	}
+
+	exprMap, err := evalMapArgument(functionName, "first", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	keysExpr, err := eval(args[1], ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to evaluate %s() second argument: %w", functionName, err)
+		return
+	}
+
+	keys, ok := keysExpr.(parser.ExprList)
+	if !ok {
+		err = withTypes(fmt.Errorf("%w: %s() second argument must be a list, got %s", ErrInvalidArgumentType, functionName, TypeName(keysExpr)), keysExpr)
+		return
+	}
+
+	var pairs []parser.ExprMapPair
+	for _, pair := range exprMap.Pairs {
+		listed := false
+		for _, key := range keys.Values {
+			if isEqual, compareErr := areExpressionsEqual(pair.Key, key); compareErr == nil && isEqual {
+				listed = true
+				break
+			}
+		}
+
+		if listed == keep {
+			pairs = append(pairs, pair)
+		}
+	}
+
+	return parser.ExprMap{Pairs: pairs}, nil
+}
+
+// evalPickFunction implements the pick() built-in function.
+// Returns a map with only the listed keys.
+func evalPickFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalSelectKeys("pick", args, ctx, true)
+}
+
+// evalOmitFunction implements the omit() built-in function.
+// Returns a map without the listed keys.
+func evalOmitFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalSelectKeys("omit", args, ctx, false)
+}
+
+// evalMapValuesFunction implements the map_values() built-in function.
+// Returns a map with the same keys, holding the result of evaluating the
+// expression against each value, with `_` bound to the value.
+func evalMapValuesFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 2 {
+		err = fmt.Errorf("%w: map_values() expects exactly 2 arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	exprMap, err := evalMapArgument("map_values", "first", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	pairs := make([]parser.ExprMapPair, len(exprMap.Pairs))
+	for i, pair := range exprMap.Pairs {
+		result, evalErr := ctx.Apply(args[1], pair.Value)
+		if evalErr != nil {
+			err = fmt.Errorf("failed to evaluate map_values expression: %w", evalErr)
+			return
+		}
+		pairs[i] = parser.ExprMapPair{Key: pair.Key, Value: result}
+	}
+
+	return parser.ExprMap{Pairs: pairs}, nil
+}
+
+// evalUniqueFunction implements the unique() built-in function.
+// Returns the list without repeated elements, keeping the first occurrence of
+// each.
+func evalUniqueFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 1 {
+		err = fmt.Errorf("%w: unique() expects exactly 1 argument, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	list, err := evalListArgument("unique", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	var uniqueValues []parser.Expr
+	for _, element := range list.Values {
+		seen := false
+		for _, kept := range uniqueValues {
+			if isRepeatedElement(kept, element) {
+				seen = true
+				break
+			}
+		}
+
+		if !seen {
+			uniqueValues = append(uniqueValues, element)
+		}
+	}
+
+	return parser.ExprList{Values: uniqueValues}, nil
+}
+
+// isRepeatedElement reports whether unique() treats two elements as the same.
+// Scalars are compared like contains(), and lists and maps by value.
+func isRepeatedElement(a, b parser.Expr) bool {
+	if isEqual, err := areExpressionsEqual(a, b); err == nil {
+		return isEqual
+	}
+	return compareExpressions(a, b) == 0
+}
+
+// evalFlattenFunction implements the flatten() built-in function.
+// Replaces nested lists with their elements, down to the given depth. Without
+// a depth, lists are flattened completely.
+func evalFlattenFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) < 1 || len(args) > 2 {
+		err = fmt.Errorf("%w: flatten() expects 1 or 2 arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	list, err := evalListArgument("flatten", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	depth := -1
+	if len(args) == 2 {
+		depth, err = evalIntegerArgument("flatten", "second", args[1], ctx)
+		if err != nil {
+			return
+		}
+
+		if depth < 0 {
+			err = fmt.Errorf("%w: flatten() second argument must be non-negative", ErrInvalidArgumentType)
+			return
+		}
+	}
+
+	return parser.ExprList{Values: flattenValues(list.Values, depth)}, nil
+}
+
+// flattenValues replaces nested lists in values with their elements, down to
+// depth levels. A negative depth flattens completely.
+func flattenValues(values []parser.Expr, depth int) []parser.Expr {
+	flattened := make([]parser.Expr, 0, len(values))
+	for _, value := range values {
+		nested, ok := value.(parser.ExprList)
+		if !ok || depth == 0 {
+			flattened = append(flattened, value)
+			continue
+		}
+
+		flattened = append(flattened, flattenValues(nested.Values, depth-1)...)
+	}
+	return flattened
+}
+
+// evalListArguments evaluates the arguments of a function that takes any
+// number of lists, such as concat().
+func evalListArguments(functionName string, args []parser.Expr, ctx *Context) (lists []parser.ExprList, err error) {
+	lists = make([]parser.ExprList, len(args))
+	for i, arg := range args {
+		argExpr, evalErr := eval(arg, ctx)
+		if evalErr != nil {
+			return nil, fmt.Errorf("failed to evaluate %s() argument %d: %w", functionName, i+1, evalErr)
+		}
+
+		list, ok := argExpr.(parser.ExprList)
+		if !ok {
+			return nil, withTypes(fmt.Errorf("%w: %s() argument %d must be a list, got %s", ErrInvalidArgumentType, functionName, i+1, TypeName(argExpr)), argExpr)
+		}
+		lists[i] = list
+	}
+
+	return lists, nil
+}
+
+// evalZipFunction implements the zip() built-in function.
+// Returns a list of lists, where the i-th list holds the i-th element of each
+// argument. The result is as long as the shortest argument.
+func evalZipFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) < 2 {
+		err = fmt.Errorf("%w: zip() expects at least 2 arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	lists, err := evalListArguments("zip", args, ctx)
+	if err != nil {
+		return
+	}
+
+	length := len(lists[0].Values)
+	for _, list := range lists[1:] {
+		length = min(length, len(list.Values))
+	}
+
+	zipped := make([]parser.Expr, length)
+	for i := range zipped {
+		tuple := make([]parser.Expr, len(lists))
+		for j, list := range lists {
+			tuple[j] = list.Values[i]
+		}
+		zipped[i] = parser.ExprList{Values: tuple}
+	}
+
+	return parser.ExprList{Values: zipped}, nil
+}
+
+// evalConcatFunction implements the concat() built-in function.
+// Returns a list holding the elements of every argument in order.
+func evalConcatFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) == 0 {
+		err = fmt.Errorf("%w: concat() expects at least 1 argument, got 0", ErrInvalidArgumentCount)
+		return
+	}
+
+	lists, err := evalListArguments("concat", args, ctx)
+	if err != nil {
+		return
+	}
+
+	var values []parser.Expr
+	for _, list := range lists {
+		values = append(values, list.Values...)
+	}
+
+	return parser.ExprList{Values: values}, nil
+}
+
+// evalChunkFunction implements the chunk() built-in function.
+// Splits a list into lists of n elements. The last list holds the remaining
+// elements and may be shorter.
+func evalChunkFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 2 {
+		err = fmt.Errorf("%w: chunk() expects exactly 2 arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	list, err := evalListArgument("chunk", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	size, err := evalIntegerArgument("chunk", "second", args[1], ctx)
+	if err != nil {
+		return
+	}
+
+	if size <= 0 {
+		err = fmt.Errorf("%w: chunk() second argument must be positive", ErrInvalidArgumentType)
+		return
+	}
+
+	chunks := make([]parser.Expr, 0, (len(list.Values)+size-1)/size)
+	for start := 0; start < len(list.Values); start += size {
+		end := min(start+size, len(list.Values))
+		chunks = append(chunks, parser.ExprList{Values: list.Values[start:end]})
+	}
+
+	return parser.ExprList{Values: chunks}, nil
+}
+
+// evalRangeFunction implements the range() built-in function.
+// range(end) counts from 0 up to but excluding end, and range(start, end) and
+// range(start, end, step) count from start by step, which defaults to 1.
+func evalRangeFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) < 1 || len(args) > 3 {
+		err = fmt.Errorf("%w: range() expects 1 to 3 arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	bounds := make([]decimal.Decimal, len(args))
+	for i, arg := range args {
+		number, evalErr := evalAndValidateNumber(arg, ctx, "range")
+		if evalErr != nil {
+			return nil, evalErr
+		}
+		bounds[i] = number.Value
+	}
+
+	start, end, step := decimal.Zero, bounds[0], decimal.NewFromInt(1)
+	if len(bounds) > 1 {
+		start, end = bounds[0], bounds[1]
+	}
+	if len(bounds) > 2 {
+		step = bounds[2]
+	}
+
+	if step.IsZero() {
+		err = fmt.Errorf("%w: range() step must not be zero", ErrInvalidArgumentType)
+		return
+	}
+
+	// Count the elements up front, so an enormous range fails before it is built
+	count := end.Sub(start).Div(step).Ceil()
+	if count.GreaterThan(decimal.NewFromInt(maxGeneratedLength)) {
+		err = fmt.Errorf("%w: range() would generate more than %d elements", ErrInvalidArgumentType, maxGeneratedLength)
+		return
+	}
+
+	var values []parser.Expr
+	for value := start; (step.IsPositive() && value.LessThan(end)) || (step.IsNegative() && value.GreaterThan(end)); value = value.Add(step) {
+		values = append(values, parser.ExprNumber{Value: value})
+	}
+
+	return parser.ExprList{Values: values}, nil
+}
+
+// evalFirstFunction implements the first() built-in function.
+// Returns the first element of a list, or null for an empty list.
+func evalFirstFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 1 {
+		err = fmt.Errorf("%w: first() expects exactly 1 argument, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	list, err := evalListArgument("first", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	if len(list.Values) == 0 {
+		return parser.ExprNull{}, nil
+	}
+
+	return list.Values[0], nil
+}
+
+// evalLastFunction implements the last() built-in function.
+// Returns the last element of a list, or null for an empty list.
+func evalLastFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 1 {
+		err = fmt.Errorf("%w: last() expects exactly 1 argument, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	list, err := evalListArgument("last", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	if len(list.Values) == 0 {
+		return parser.ExprNull{}, nil
+	}
+
+	return list.Values[len(list.Values)-1], nil
+}
+
+// evalTakeDrop implements the take() and drop() built-in functions, which
+// split a list after its first n elements and return the first or second part.
+func evalTakeDrop(functionName string, args []parser.Expr, ctx *Context, take bool) (ret parser.Expr, err error) {
+	if len(args) != 2 {
+		err = fmt.Errorf("%w: %s() expects exactly 2 arguments, got %d", ErrInvalidArgumentCount, functionName, len(args))
+		return
+	}
+
+	list, err := evalListArgument(functionName, args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	n, err := evalIntegerArgument(functionName, "second", args[1], ctx)
+	if err != nil {
+		return
+	}
+
+	if n < 0 {
+		err = fmt.Errorf("%w: %s() second argument must be non-negative", ErrInvalidArgumentType, functionName)
+		return
+	}
+
+	n = min(n, len(list.Values))
+	if take {
+		return parser.ExprList{Values: list.Values[:n]}, nil
+	}
+	return parser.ExprList{Values: list.Values[n:]}, nil
+}
+
+// evalTakeFunction implements the take() built-in function.
+// Returns the first n elements of a list.
+func evalTakeFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalTakeDrop("take", args, ctx, true)
+}
+
+// evalDropFunction implements the drop() built-in function.
+// Returns the list without its first n elements.
+func evalDropFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalTakeDrop("drop", args, ctx, false)
+}
+
+// evalGroupKeys evaluates the key expression of group_by(), count_by() or
+// index_by() against each element of a list, with `_` bound to the element.
+// Keys must be strings, numbers, booleans or null, and are converted to
+// strings the way keys of Go maps in the input are, so that the resulting map
+// can be returned from a query. Keys of different types that convert to the
+// same string, such as 1 and "1", are an error rather than being merged.
+func evalGroupKeys(functionName string, args []parser.Expr, ctx *Context) (elements, keys []parser.Expr, err error) {
+	if len(args) != 2 {
+		err = fmt.Errorf("%w: %s() expects exactly 2 arguments, got %d", ErrInvalidArgumentCount, functionName, len(args))
+		return
+	}
+
+	list, err := evalListArgument(functionName, args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	keys = make([]parser.Expr, len(list.Values))
+	converted := make(map[string]parser.Expr, len(list.Values))
+	for i, element := range list.Values {
+		key, evalErr := ctx.Apply(args[1], element)
+		if evalErr != nil {
+			err = fmt.Errorf("failed to evaluate %s expression: %w", functionName, evalErr)
+			return
+		}
+
+		var name string
+		switch k := key.(type) {
+		case parser.ExprString:
+			name = k.Value
+		case parser.ExprNumber:
+			name = k.Value.String()
+		case parser.ExprBoolean:
+			name = strconv.FormatBool(k.Value)
+		case parser.ExprNull:
+			name = "null"
+		default:
+			err = withTypes(fmt.Errorf("%w: %s() key must be a string, number, boolean or null, got %s", ErrInvalidArgumentType, functionName, TypeName(key)), key)
+			return
+		}
+
+		if original, ok := converted[name]; ok && original.Type() != key.Type() {
+			err = withTypes(fmt.Errorf("%w: %s() keys of type %s and %s both convert to %q", ErrIncompatibleTypes, functionName, TypeName(original), TypeName(key), name), original, key)
+			return
+		}
+		converted[name] = key
+		keys[i] = parser.ExprString{Value: name}
+	}
+
+	return list.Values, keys, nil
+}
+
+// evalGroupByFunction implements the group_by() built-in function.
+// Returns a map from each key to the list of elements with that key. Keys are
+// in the order they first appear.
+func evalGroupByFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	elements, keys, err := evalGroupKeys("group_by", args, ctx)
+	if err != nil {
+		return
+	}
+
+	var groups []parser.ExprMapPair
+	for i, key := range keys {
+		index, findErr := findMapKey(groups, key)
+		if findErr != nil {
+			return nil, findErr
+		}
+
+		if index < 0 {
+			groups = append(groups, parser.ExprMapPair{Key: key, Value: parser.ExprList{}})
+			index = len(groups) - 1
+		}
+
+		group := groups[index].Value.(parser.ExprList)
+		groups[index].Value = parser.ExprList{Values: append(group.Values, elements[i])}
+	}
+
+	return parser.ExprMap{Pairs: groups}, nil
+}
+
+// evalCountByFunction implements the count_by() built-in function.
+// Returns a map from each key to the number of elements with that key. Keys
+// are in the order they first appear.
+func evalCountByFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	_, keys, err := evalGroupKeys("count_by", args, ctx)
+	if err != nil {
+		return
+	}
+
+	var counts []parser.ExprMapPair
+	for _, key := range keys {
+		index, findErr := findMapKey(counts, key)
+		if findErr != nil {
+			return nil, findErr
+		}
+
+		if index < 0 {
+			counts = append(counts, parser.ExprMapPair{Key: key, Value: parser.ExprNumber{Value: decimal.Zero}})
+			index = len(counts) - 1
+		}
+
+		count := counts[index].Value.(parser.ExprNumber)
+		counts[index].Value = parser.ExprNumber{Value: count.Value.Add(decimal.NewFromInt(1))}
+	}
+
+	return parser.ExprMap{Pairs: counts}, nil
+}
+
+// evalIndexByFunction implements the index_by() built-in function.
+// Returns a map from each key to the element with that key. Two elements with
+// the same key are an error.
+func evalIndexByFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	elements, keys, err := evalGroupKeys("index_by", args, ctx)
+	if err != nil {
+		return
+	}
+
+	pairs := make([]parser.ExprMapPair, 0, len(keys))
+	for i, key := range keys {
+		index, findErr := findMapKey(pairs, key)
+		if findErr != nil {
+			return nil, findErr
+		}
+
+		if index >= 0 {
+			if keyString, ok := key.(parser.ExprString); ok {
+				err = fmt.Errorf("%w: index_by() key %q is shared by elements %d and %d", ErrDuplicateKey, keyString.Value, index, i)
+				return
+			}
+			err = withTypes(fmt.Errorf("%w: index_by() %s key is shared by elements %d and %d", ErrDuplicateKey, TypeName(key), index, i), key)
+			return
+		}
+
+		pairs = append(pairs, parser.ExprMapPair{Key: key, Value: elements[i]})
+	}
+
+	return parser.ExprMap{Pairs: pairs}, nil
+}
+
+// evalPartitionFunction implements the partition() built-in function.
+// Returns a list of two lists: the elements that match a boolean expression
+// and the elements that do not, each in their original order.
+func evalPartitionFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 2 {
+		err = fmt.Errorf("%w: partition() expects exactly 2 arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	exprList, err := evalListArgument("partition", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	matching := []parser.Expr{}
+	rest := []parser.Expr{}
+	for _, element := range exprList.Values {
+		matched, predicateErr := evalPredicate("partition", args[1], ctx, element)
+		if predicateErr != nil {
+			return nil, predicateErr
+		}
+
+		if matched {
+			matching = append(matching, element)
+		} else {
+			rest = append(rest, element)
+		}
+	}
+
+	return parser.ExprList{Values: []parser.Expr{
+		parser.ExprList{Values: matching},
+		parser.ExprList{Values: rest},
+	}}, nil
+}
+
+// isTemporal reports whether expr is a time or a duration.
+func isTemporal(expr parser.Expr) bool {
+	return expr.Type() == parser.ExprType_Time || expr.Type() == parser.ExprType_Duration
+}
+
+// errDurationOverflow is returned when a duration would be too large to
+// represent, which is about 292 years.
+var errDurationOverflow = fmt.Errorf("%w: duration is out of range", ErrInvalidTime)
+
+// evalAddTemporal adds a duration to a time, in either order, or adds two
+// durations together.
+func evalAddTemporal(expr1, expr2 parser.Expr) (result parser.Expr, err error) {
+	switch value1 := expr1.(type) {
+	case parser.ExprTime:
+		if value2, ok := expr2.(parser.ExprDuration); ok {
+			return parser.ExprTime{Value: value1.Value.Add(value2.Value)}, nil
+		}
+	case parser.ExprDuration:
+		switch value2 := expr2.(type) {
+		case parser.ExprTime:
+			return parser.ExprTime{Value: value2.Value.Add(value1.Value)}, nil
+		case parser.ExprDuration:
+			sum := value1.Value + value2.Value
+			if (value2.Value > 0 && sum < value1.Value) || (value2.Value < 0 && sum > value1.Value) {
+				return nil, errDurationOverflow
+			}
+			return parser.ExprDuration{Value: sum}, nil
+		}
+	}
+
+	err = withTypes(fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, TypeName(expr1), TypeName(expr2)), expr1, expr2)
+	return
+}
+
+// evalSubtractTemporal subtracts a duration from a time or from a duration,
+// or subtracts two times to give the duration between them.
+func evalSubtractTemporal(expr1, expr2 parser.Expr) (result parser.Expr, err error) {
+	switch value1 := expr1.(type) {
+	case parser.ExprTime:
+		switch value2 := expr2.(type) {
+		case parser.ExprTime:
+			// Sub saturates rather than overflowing, which adding the
+			// difference back detects
+			difference := value1.Value.Sub(value2.Value)
+			if !value2.Value.Add(difference).Equal(value1.Value) {
+				return nil, errDurationOverflow
+			}
+			return parser.ExprDuration{Value: difference}, nil
+		case parser.ExprDuration:
+			return parser.ExprTime{Value: value1.Value.Add(-value2.Value)}, nil
+		}
+	case parser.ExprDuration:
+		if value2, ok := expr2.(parser.ExprDuration); ok {
+			difference := value1.Value - value2.Value
+			if (value2.Value < 0 && difference < value1.Value) || (value2.Value > 0 && difference > value1.Value) {
+				return nil, errDurationOverflow
+			}
+			return parser.ExprDuration{Value: difference}, nil
+		}
+	}
+
+	err = withTypes(fmt.Errorf("%w: %s and %s", ErrIncompatibleTypes, TypeName(expr1), TypeName(expr2)), expr1, expr2)
+	return
+}
+
+// compareTemporal compares two times or two durations, returning -1, 0 or 1.
+// Times are compared as instants, regardless of their location.
+func compareTemporal(a, b parser.Expr) int {
+	switch aValue := a.(type) {
+	case parser.ExprTime:
+		if bValue, ok := b.(parser.ExprTime); ok {
+			return aValue.Value.Compare(bValue.Value)
+		}
+	case parser.ExprDuration:
+		if bValue, ok := b.(parser.ExprDuration); ok {
+			return cmp.Compare(aValue.Value, bValue.Value)
+		}
+	}
+	return 0
+}
+
+// evalCompareTemporal implements the comparison operators for two times or
+// two durations. test reports whether the result of compareTemporal satisfies
+// the operator.
+func evalCompareTemporal(expr1, expr2 parser.Expr, test func(int) bool) (result parser.Expr, err error) {
+	return parser.ExprBoolean{Value: test(compareTemporal(expr1, expr2))}, nil
+}
+
+// timeLayouts holds the names accepted in place of a layout by parse_time()
+// and format_time(), after the constants of the time package.
+var timeLayouts = map[string]string{
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"RFC822":      time.RFC822,
+	"RFC822Z":     time.RFC822Z,
+	"Kitchen":     time.Kitchen,
+	"DateTime":    time.DateTime,
+	"DateOnly":    time.DateOnly,
+	"TimeOnly":    time.TimeOnly,
+}
+
+// durationUnits holds the units accepted by duration() and date_diff().
+var durationUnits = map[string]time.Duration{
+	"milliseconds": time.Millisecond,
+	"seconds":      time.Second,
+	"minutes":      time.Minute,
+	"hours":        time.Hour,
+	"days":         24 * time.Hour,
+	"weeks":        7 * 24 * time.Hour,
+}
+
+// evalTimeArgument evaluates an argument of a function that requires a time.
+// position names the argument in error messages, such as "first".
+func evalTimeArgument(functionName, position string, arg parser.Expr, ctx *Context) (t time.Time, err error) {
+	argExpr, err := eval(arg, ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to evaluate %s() %s argument: %w", functionName, position, err)
+		return
+	}
+
+	exprTime, ok := argExpr.(parser.ExprTime)
+	if !ok {
+		err = withTypes(fmt.Errorf("%w: %s() %s argument must be a time, got %s", ErrInvalidArgumentType, functionName, position, TypeName(argExpr)), argExpr)
+		return
+	}
+
+	return exprTime.Value, nil
+}
+
+// evalLayoutArgument evaluates the optional layout argument of parse_time()
+// or format_time(), which is a Go layout string or the name of one in
+// timeLayouts. Without the argument, the layout is RFC 3339.
+func evalLayoutArgument(functionName string, args []parser.Expr, ctx *Context) (layout string, err error) {
+	if len(args) < 2 {
+		return time.RFC3339, nil
+	}
+
+	layout, err = evalStringArgument(functionName, "second", args[1], ctx)
+	if err != nil {
+		return
+	}
+
+	if named, ok := timeLayouts[layout]; ok {
+		return named, nil
+	}
+	return layout, nil
+}
+
+// evalUnitArgument evaluates an argument naming a unit in durationUnits.
+func evalUnitArgument(functionName, position string, arg parser.Expr, ctx *Context) (unit time.Duration, err error) {
+	name, err := evalStringArgument(functionName, position, arg, ctx)
+	if err != nil {
+		return
+	}
+
+	unit, ok := durationUnits[name]
+	if !ok {
+		err = fmt.Errorf("%w: %s() %s argument must be a unit such as \"seconds\" or \"days\", got %q", ErrInvalidArgumentType, functionName, position, name)
+		return
+	}
+
+	return unit, nil
+}
+
+// evalNowFunction implements the now() built-in function.
+// Returns the current time from the context's clock.
+func evalNowFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 0 {
+		err = fmt.Errorf("%w: now() expects no arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	now := time.Now
+	if ctx.Now != nil {
+		now = ctx.Now
+	}
+
+	return parser.ExprTime{Value: now()}, nil
+}
+
+// evalParseTimeFunction implements the parse_time() built-in function.
+// Parses a string into a time using a layout, which defaults to RFC 3339.
+func evalParseTimeFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) < 1 || len(args) > 2 {
+		err = fmt.Errorf("%w: parse_time() expects 1 or 2 arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	str, err := evalStringArgument("parse_time", "first", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	layout, err := evalLayoutArgument("parse_time", args, ctx)
+	if err != nil {
+		return
+	}
+
+	t, parseErr := time.Parse(layout, str)
+	if parseErr != nil {
+		err = fmt.Errorf("%w: parse_time() %v", ErrInvalidTime, parseErr)
+		return
+	}
+
+	return parser.ExprTime{Value: t}, nil
+}
+
+// evalFormatTimeFunction implements the format_time() built-in function.
+// Formats a time as a string using a layout, which defaults to RFC 3339.
+func evalFormatTimeFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) < 1 || len(args) > 2 {
+		err = fmt.Errorf("%w: format_time() expects 1 or 2 arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	t, err := evalTimeArgument("format_time", "first", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	layout, err := evalLayoutArgument("format_time", args, ctx)
+	if err != nil {
+		return
+	}
+
+	return parser.ExprString{Value: t.Format(layout)}, nil
+}
+
+// evalDurationFunction implements the duration() built-in function.
+// duration(s) parses a Go duration string such as "1h30m", and
+// duration(n, unit) multiplies a number by a unit such as "days".
+func evalDurationFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) < 1 || len(args) > 2 {
+		err = fmt.Errorf("%w: duration() expects 1 or 2 arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	if len(args) == 1 {
+		str, evalErr := evalStringArgument("duration", "first", args[0], ctx)
+		if evalErr != nil {
+			return nil, evalErr
+		}
+
+		d, parseErr := time.ParseDuration(str)
+		if parseErr != nil {
+			err = fmt.Errorf("%w: duration() %v", ErrInvalidTime, parseErr)
+			return
+		}
+
+		return parser.ExprDuration{Value: d}, nil
+	}
+
+	amount, err := evalAndValidateNumber(args[0], ctx, "duration")
+	if err != nil {
+		return
+	}
+
+	unit, err := evalUnitArgument("duration", "second", args[1], ctx)
+	if err != nil {
+		return
+	}
+
+	nanoseconds := amount.Value.Mul(decimal.NewFromInt(int64(unit)))
+	if nanoseconds.LessThan(decimal.NewFromInt(math.MinInt64)) || nanoseconds.GreaterThan(decimal.NewFromInt(math.MaxInt64)) {
+		err = errDurationOverflow
+		return
+	}
+
+	return parser.ExprDuration{Value: time.Duration(nanoseconds.IntPart())}, nil
+}
+
+// evalDateDiffFunction implements the date_diff() built-in function.
+// Returns the time from the second time to the first as a number of units,
+// which default to seconds. The result is negative when the first time is
+// earlier.
+func evalDateDiffFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) < 2 || len(args) > 3 {
+		err = fmt.Errorf("%w: date_diff() expects 2 or 3 arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	t1, err := evalTimeArgument("date_diff", "first", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	t2, err := evalTimeArgument("date_diff", "second", args[1], ctx)
+	if err != nil {
+		return
+	}
+
+	unit := time.Second
+	if len(args) == 3 {
+		unit, err = evalUnitArgument("date_diff", "third", args[2], ctx)
+		if err != nil {
+			return
+		}
+	}
+
+	// Compute the difference in nanoseconds as a decimal, since it can be too
+	// large for a time.Duration
+	seconds := decimal.NewFromInt(t1.Unix()).Sub(decimal.NewFromInt(t2.Unix()))
+	nanoseconds := seconds.Mul(decimal.NewFromInt(int64(time.Second))).Add(decimal.NewFromInt(int64(t1.Nanosecond() - t2.Nanosecond())))
+	return parser.ExprNumber{Value: nanoseconds.Div(decimal.NewFromInt(int64(unit)))}, nil
+}
+
+// evalTimeComponentFunction implements a built-in function that returns a
+// component of a time, such as year(). Components are read in the time's own
+// location.
+func evalTimeComponentFunction(functionName string, args []parser.Expr, ctx *Context, component func(time.Time) int64) (ret parser.Expr, err error) {
+	if len(args) != 1 {
+		err = fmt.Errorf("%w: %s() expects exactly 1 argument, got %d", ErrInvalidArgumentCount, functionName, len(args))
+		return
+	}
+
+	t, err := evalTimeArgument(functionName, "first", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	return parser.ExprNumber{Value: decimal.NewFromInt(component(t))}, nil
+}
+
+// evalYearFunction implements the year() built-in function.
+func evalYearFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalTimeComponentFunction("year", args, ctx, func(t time.Time) int64 { return int64(t.Year()) })
+}
+
+// evalMonthFunction implements the month() built-in function.
+// Months are numbered from 1 for January.
+func evalMonthFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalTimeComponentFunction("month", args, ctx, func(t time.Time) int64 { return int64(t.Month()) })
+}
+
+// evalDayFunction implements the day() built-in function.
+// Returns the day of the month.
+func evalDayFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalTimeComponentFunction("day", args, ctx, func(t time.Time) int64 { return int64(t.Day()) })
+}
+
+// evalHourFunction implements the hour() built-in function.
+func evalHourFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalTimeComponentFunction("hour", args, ctx, func(t time.Time) int64 { return int64(t.Hour()) })
+}
+
+// evalMinuteFunction implements the minute() built-in function.
+func evalMinuteFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalTimeComponentFunction("minute", args, ctx, func(t time.Time) int64 { return int64(t.Minute()) })
+}
+
+// evalSecondFunction implements the second() built-in function.
+func evalSecondFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalTimeComponentFunction("second", args, ctx, func(t time.Time) int64 { return int64(t.Second()) })
+}
+
+// evalWeekdayFunction implements the weekday() built-in function.
+// Days are numbered from 0 for Sunday.
+func evalWeekdayFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalTimeComponentFunction("weekday", args, ctx, func(t time.Time) int64 { return int64(t.Weekday()) })
+}
+
+// evalUnixFunction implements the unix() built-in function.
+// Returns the number of seconds since January 1, 1970 UTC.
+func evalUnixFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalTimeComponentFunction("unix", args, ctx, func(t time.Time) int64 { return t.Unix() })
+}
+
+// evalFilterFunction implements the filter() built-in function.
+// Filters a list based on a boolean expression using `_` as the element placeholder.
+func evalFilterFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 2 {
+		err = fmt.Errorf("%w: filter() expects exactly 2 arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	exprList, err := evalListArgument("filter", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	// The second argument is the filter expression with `_` as placeholder
+	filterExpr := args[1]
+
+	// Create a result list to store filtered elements
+	var filteredValues []parser.Expr
+
+	// Iterate through each element in the input list
+	for _, element := range exprList.Values {
+		matched, predicateErr := evalPredicate("filter", filterExpr, ctx, element)
+		if predicateErr != nil {
+			return nil, predicateErr
+		}
+
+		if matched {
+			filteredValues = append(filteredValues, element)
+		}
+	}
+
+	// Return the filtered list
+	return parser.ExprList{Values: filteredValues}, nil
+}
+
+// evalMapFunction implements the map() built-in function.
+// Returns a list holding the result of evaluating the expression against each
+// element, with `_` bound to the element.
+func evalMapFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 2 {
+		err = fmt.Errorf("%w: map() expects exactly 2 arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	exprList, err := evalListArgument("map", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	mappedValues := make([]parser.Expr, 0, len(exprList.Values))
+	for _, element := range exprList.Values {
+		result, evalErr := ctx.Apply(args[1], element)
+		if evalErr != nil {
+			err = fmt.Errorf("failed to evaluate map expression: %w", evalErr)
+			return
+		}
+		mappedValues = append(mappedValues, result)
+	}
+
+	return parser.ExprList{Values: mappedValues}, nil
+}
+
+// evalReduceFunction implements the reduce() built-in function.
+// Folds the list into a single value, starting from the initial value and
+// evaluating the expression for each element with `_` bound to the element and
+// `acc` bound to the value accumulated so far. A lambda expression receives the
+// accumulated value and the element as its two parameters.
+func evalReduceFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 3 {
+		err = fmt.Errorf("%w: reduce() expects exactly 3 arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	exprList, err := evalListArgument("reduce", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	// Variables declared when compiling are checked by the parser, but those
+	// only supplied for evaluation are not
+	_, isLambda := args[2].(parser.ExprLambda)
+	if _, ok := ctx.Variables[parser.AccumulatorVariable]; ok && !isLambda {
+		err = fmt.Errorf("%w: the reduce() accumulator %s hides the variable of the same name, use a lambda such as (total, x) => total + x instead", ErrShadowedVariable, parser.AccumulatorVariable)
+		return
+	}
+
+	accumulator, err := eval(args[1], ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to evaluate reduce() initial value: %w", err)
+		return
+	}
+
+	for _, element := range exprList.Values {
+		if isLambda {
+			accumulator, err = ctx.Apply(args[2], accumulator, element)
+		} else {
+			elementCtx := ctx.withBinding(parser.AccumulatorVariable, accumulator).WithElement(element)
+			accumulator, err = eval(args[2], elementCtx)
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to evaluate reduce expression: %w", err)
+			return
+		}
+	}
+
+	return accumulator, nil
+}
+
+// evalAnyFunction implements the any() built-in function.
+// Returns true if the predicate holds for at least one element of the list.
+func evalAnyFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	_, index, err := evalFind("any", args, ctx)
+	if err != nil {
+		return
+	}
+
+	return parser.ExprBoolean{Value: index >= 0}, nil
+}
+
+// evalAllFunction implements the all() built-in function.
+// Returns true if the predicate holds for every element of the list, including
+// when the list is empty.
+func evalAllFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 2 {
+		err = fmt.Errorf("%w: all() expects exactly 2 arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	exprList, err := evalListArgument("all", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	for _, element := range exprList.Values {
+		matched, predicateErr := evalPredicate("all", args[1], ctx, element)
+		if predicateErr != nil {
+			return nil, predicateErr
+		}
+
+		if !matched {
+			return parser.ExprBoolean{Value: false}, nil
+		}
+	}
+
+	return parser.ExprBoolean{Value: true}, nil
+}
+
+// evalFindFunction implements the find() built-in function.
+// Returns the first element of the list the predicate holds for, or null if
+// there is none.
+func evalFindFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	exprList, index, err := evalFind("find", args, ctx)
+	if err != nil {
+		return
+	}
+
+	if index < 0 {
+		return parser.ExprNull{}, nil
+	}
+
+	return exprList.Values[index], nil
+}
+
+// evalFindIndexFunction implements the findIndex() built-in function.
+// Returns the index of the first element of the list the predicate holds for,
+// or -1 if there is none.
+func evalFindIndexFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	_, index, err := evalFind("findIndex", args, ctx)
+	if err != nil {
+		return
+	}
+
+	return parser.ExprNumber{Value: decimal.NewFromInt(int64(index))}, nil
+}
+
+// evalCountFunction implements the count() built-in function.
+// Returns the number of elements of the list the predicate holds for.
+func evalCountFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 2 {
+		err = fmt.Errorf("%w: count() expects exactly 2 arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	exprList, err := evalListArgument("count", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	var count int64
+	for _, element := range exprList.Values {
+		matched, predicateErr := evalPredicate("count", args[1], ctx, element)
+		if predicateErr != nil {
+			return nil, predicateErr
+		}
+
+		if matched {
+			count++
+		}
+	}
+
+	return parser.ExprNumber{Value: decimal.NewFromInt(count)}, nil
+}
+
+// evalFind evaluates the list argument and returns it along with the index of
+// the first element the predicate argument holds for, or -1 if there is none.
+// functionName is used in error messages.
+func evalFind(functionName string, args []parser.Expr, ctx *Context) (exprList parser.ExprList, index int, err error) {
+	if len(args) != 2 {
+		err = fmt.Errorf("%w: %s() expects exactly 2 arguments, got %d", ErrInvalidArgumentCount, functionName, len(args))
+		return
+	}
+
+	exprList, err = evalListArgument(functionName, args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	for i, element := range exprList.Values {
+		matched, predicateErr := evalPredicate(functionName, args[1], ctx, element)
+		if predicateErr != nil {
+			return exprList, 0, predicateErr
+		}
+
+		if matched {
+			return exprList, i, nil
+		}
+	}
+
+	return exprList, -1, nil
+}
+
+// evalListArgument evaluates the list argument of a higher-order function such
+// as filter(). functionName is used in error messages.
+func evalListArgument(functionName string, arg parser.Expr, ctx *Context) (list parser.ExprList, err error) {
+	listArg, err := eval(arg, ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to evaluate %s() list argument: %w", functionName, err)
+		return
+	}
 
-		if resultBool.Value {
-			filteredValues = append(filteredValues, element)
-		}
+	// Check that the argument is a list
+	if listArg.Type() != parser.ExprType_List {
+		err = withTypes(fmt.Errorf("%w: %s() first argument must be a list, got %s", ErrInvalidArgumentType, functionName, TypeName(listArg)), listArg)
+		return
 	}
 
-	// Return the filtered list
-	return parser.ExprList{Values: filteredValues}, nil
+	list, ok := listArg.(parser.ExprList)
+	if !ok {
+		err = fmt.Errorf("failed to assert expression as list")
+		return
+	}
+
+	return list, nil
 }
 
-// evalFilterExpression evaluates the filter expression with the given element as the value for `_`.
-// This function evaluates the expression by using the element as the input context, so that
-// when the variable `_` is encountered during evaluation, it returns the element.
-func evalFilterExpression(expr parser.Expr, element parser.Expr) (parser.Expr, error) {
-	// Convert the element back to its original data type for use as input
-	elementData, err := element.Decode()
+// evalPredicate evaluates a predicate, such as the condition passed to
+// filter(), for a single list element. The predicate must evaluate to a
+// boolean. functionName is used in error messages.
+func evalPredicate(functionName string, predicate parser.Expr, ctx *Context, element parser.Expr) (matched bool, err error) {
+	result, err := ctx.Apply(predicate, element)
 	if err != nil {
-		// If we can't decode the element, use the element expression directly
-		elementData = element
+		err = fmt.Errorf("failed to evaluate %s expression: %w", functionName, err)
+		return
+	}
+
+	// Check that the result is a boolean
+	if result.Type() != parser.ExprType_Boolean {
+		err = withTypes(fmt.Errorf("%w: %s expression must evaluate to a boolean, got %s", ErrInvalidArgumentType, functionName, TypeName(result)), result)
+		return
+	}
+
+	resultBool, ok := result.(parser.ExprBoolean)
+	if !ok {
+		err = fmt.Errorf("failed to assert result as boolean")
+		return
 	}
 
-	// Evaluate the filter expression with the element as input context
-	// This allows the variable `_` to resolve to the current element during evaluation
-	return Eval(expr, elementData)
+	return resultBool.Value, nil
 }
 
 // evalAbsFunction implements the abs() built-in function.
 // Returns the absolute value of a number.
-func evalAbsFunction(args []parser.Expr, input any) (ret parser.Expr, err error) {
+func evalAbsFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	if len(args) != 1 {
 		err = fmt.Errorf("%w: abs() expects exactly 1 argument, got %d", ErrInvalidArgumentCount, len(args))
 		return
 	}
 
 	// Evaluate the argument
-	argExpr, err := Eval(args[0], input)
+	argExpr, err := eval(args[0], ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate abs() argument: %w", err)
 		return
@@ -2295,7 +5134,7 @@ func evalAbsFunction(args []parser.Expr, input any) (ret parser.Expr, err error)
 
 	// Check that the argument is a number
 	if argExpr.Type() != parser.ExprType_Number {
-		err = fmt.Errorf("%w: abs() can only be applied to numbers, got %s", ErrInvalidArgumentType, argExpr.String())
+		err = withTypes(fmt.Errorf("%w: abs() can only be applied to numbers, got %s", ErrInvalidArgumentType, TypeName(argExpr)), argExpr)
 		return
 	}
 
@@ -2314,14 +5153,14 @@ func evalAbsFunction(args []parser.Expr, input any) (ret parser.Expr, err error)
 // for negative half values (.5) and away from zero for positive half values when no
 // decimal places parameter is provided. If a second parameter is provided, it specifies
 // the number of decimal places to round to.
-func evalRoundFunction(args []parser.Expr, input any) (ret parser.Expr, err error) {
+func evalRoundFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	if len(args) != 1 && len(args) != 2 {
 		err = fmt.Errorf("%w: round() expects 1 or 2 arguments, got %d", ErrInvalidArgumentCount, len(args))
 		return
 	}
 
 	// Evaluate first argument
-	argExpr, err := Eval(args[0], input)
+	argExpr, err := eval(args[0], ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate round() first argument: %w", err)
 		return
@@ -2329,21 +5168,21 @@ func evalRoundFunction(args []parser.Expr, input any) (ret parser.Expr, err erro
 
 	// Check that first argument is a number
 	if argExpr.Type() != parser.ExprType_Number {
-		err = fmt.Errorf("%w: round() can only be applied to numbers, got %s", ErrInvalidArgumentType, argExpr.String())
+		err = withTypes(fmt.Errorf("%w: round() can only be applied to numbers, got %s", ErrInvalidArgumentType, TypeName(argExpr)), argExpr)
 		return
 	}
 
 	// Process the number of decimal places to round to (0 by default)
 	decimalPlaces := int32(0)
 	if len(args) == 2 {
-		roundToExpr, err := Eval(args[1], input)
+		roundToExpr, err := eval(args[1], ctx)
 		if err != nil {
 			err = fmt.Errorf("failed to evaluate round() second argument: %w", err)
 			return nil, err
 		}
 
 		if roundToExpr.Type() != parser.ExprType_Number {
-			err = fmt.Errorf("%w: round() second argument must be a number, got %s", ErrInvalidArgumentType, roundToExpr.String())
+			err = withTypes(fmt.Errorf("%w: round() second argument must be a number, got %s", ErrInvalidArgumentType, TypeName(roundToExpr)), roundToExpr)
 			return nil, err
 		}
 
@@ -2419,14 +5258,14 @@ func evalRoundFunction(args []parser.Expr, input any) (ret parser.Expr, err erro
 
 // evalFloorFunction implements floor() built-in function.
 // Returns the largest integer less than or equal to the input number (always rounds down).
-func evalFloorFunction(args []parser.Expr, input any) (ret parser.Expr, err error) {
+func evalFloorFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	if len(args) != 1 {
 		err = fmt.Errorf("%w: floor() expects exactly 1 argument, got %d", ErrInvalidArgumentCount, len(args))
 		return
 	}
 
 	// Evaluate first argument
-	argExpr, err := Eval(args[0], input)
+	argExpr, err := eval(args[0], ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate floor() argument: %w", err)
 		return
@@ -2434,7 +5273,7 @@ func evalFloorFunction(args []parser.Expr, input any) (ret parser.Expr, err erro
 
 	// Check that argument is a number
 	if argExpr.Type() != parser.ExprType_Number {
-		err = fmt.Errorf("%w: floor() can only be applied to numbers, got %s", ErrInvalidArgumentType, argExpr.String())
+		err = withTypes(fmt.Errorf("%w: floor() can only be applied to numbers, got %s", ErrInvalidArgumentType, TypeName(argExpr)), argExpr)
 		return
 	}
 
@@ -2450,14 +5289,14 @@ func evalFloorFunction(args []parser.Expr, input any) (ret parser.Expr, err erro
 
 // evalCeilFunction implements ceil() built-in function.
 // Returns the smallest integer greater than or equal to the input number (always rounds up).
-func evalCeilFunction(args []parser.Expr, input any) (ret parser.Expr, err error) {
+func evalCeilFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	if len(args) != 1 {
 		err = fmt.Errorf("%w: ceil() expects exactly 1 argument, got %d", ErrInvalidArgumentCount, len(args))
 		return
 	}
 
 	// Evaluate first argument
-	argExpr, err := Eval(args[0], input)
+	argExpr, err := eval(args[0], ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to evaluate ceil() argument: %w", err)
 		return
@@ -2465,7 +5304,7 @@ func evalCeilFunction(args []parser.Expr, input any) (ret parser.Expr, err error
 
 	// Check that argument is a number
 	if argExpr.Type() != parser.ExprType_Number {
-		err = fmt.Errorf("%w: ceil() can only be applied to numbers, got %s", ErrInvalidArgumentType, argExpr.String())
+		err = withTypes(fmt.Errorf("%w: ceil() can only be applied to numbers, got %s", ErrInvalidArgumentType, TypeName(argExpr)), argExpr)
 		return
 	}
 
@@ -2480,13 +5319,15 @@ func evalCeilFunction(args []parser.Expr, input any) (ret parser.Expr, err error
 }
 
 // evalMinFunction implements the min() built-in function.
-// Returns the smallest value from two or more numeric arguments.
-// List arguments are expanded into their individual elements.
-func evalMinFunction(args []parser.Expr, input any) (ret parser.Expr, err error) {
+// Returns the smallest value from two or more numeric arguments, or from a
+// single list of numbers. List arguments are expanded into their individual
+// elements.
+func evalMinFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	// Expand all arguments, flattening any lists into their elements
 	var allArgs []parser.Expr
+	singleList := false
 	for _, arg := range args {
-		evaluatedArg, err := Eval(arg, input)
+		evaluatedArg, err := eval(arg, ctx)
 		if err != nil {
 			err = fmt.Errorf("failed to evaluate min() argument: %w", err)
 			return nil, err
@@ -2499,14 +5340,20 @@ func evalMinFunction(args []parser.Expr, input any) (ret parser.Expr, err error)
 				return nil, fmt.Errorf("failed to assert expression as list")
 			}
 			allArgs = append(allArgs, exprList.Values...)
+			singleList = len(args) == 1
 		} else {
 			// Add non-list arguments as-is
 			allArgs = append(allArgs, evaluatedArg)
 		}
 	}
 
-	// Check that we have at least 2 arguments after expansion
-	if len(allArgs) < 2 {
+	// Check that we have at least 2 arguments after expansion, or at least
+	// one value in a single list argument
+	if singleList && len(allArgs) == 0 {
+		err = fmt.Errorf("%w: min() expects a non-empty list", ErrInvalidArgumentCount)
+		return
+	}
+	if len(allArgs) == 0 || (!singleList && len(allArgs) < 2) {
 		err = fmt.Errorf("%w: min() expects at least 2 arguments, got %d", ErrInvalidArgumentCount, len(allArgs))
 		return
 	}
@@ -2533,13 +5380,15 @@ func evalMinFunction(args []parser.Expr, input any) (ret parser.Expr, err error)
 }
 
 // evalMaxFunction implements the max() built-in function.
-// Returns the largest value from two or more numeric arguments.
-// List arguments are expanded into their individual elements.
-func evalMaxFunction(args []parser.Expr, input any) (ret parser.Expr, err error) {
+// Returns the largest value from two or more numeric arguments, or from a
+// single list of numbers. List arguments are expanded into their individual
+// elements.
+func evalMaxFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
 	// Expand all arguments, flattening any lists into their elements
 	var allArgs []parser.Expr
+	singleList := false
 	for _, arg := range args {
-		evaluatedArg, err := Eval(arg, input)
+		evaluatedArg, err := eval(arg, ctx)
 		if err != nil {
 			err = fmt.Errorf("failed to evaluate max() argument: %w", err)
 			return nil, err
@@ -2552,14 +5401,20 @@ func evalMaxFunction(args []parser.Expr, input any) (ret parser.Expr, err error)
 				return nil, fmt.Errorf("failed to assert expression as list")
 			}
 			allArgs = append(allArgs, exprList.Values...)
+			singleList = len(args) == 1
 		} else {
 			// Add non-list arguments as-is
 			allArgs = append(allArgs, evaluatedArg)
 		}
 	}
 
-	// Check that we have at least 2 arguments after expansion
-	if len(allArgs) < 2 {
+	// Check that we have at least 2 arguments after expansion, or at least
+	// one value in a single list argument
+	if singleList && len(allArgs) == 0 {
+		err = fmt.Errorf("%w: max() expects a non-empty list", ErrInvalidArgumentCount)
+		return
+	}
+	if len(allArgs) == 0 || (!singleList && len(allArgs) < 2) {
 		err = fmt.Errorf("%w: max() expects at least 2 arguments, got %d", ErrInvalidArgumentCount, len(allArgs))
 		return
 	}
@@ -2585,10 +5440,250 @@ func evalMaxFunction(args []parser.Expr, input any) (ret parser.Expr, err error)
 	return maxValue, nil
 }
 
+// evalNumberListArgument evaluates the list argument of a statistics function
+// such as sum(). Every element must be a number.
+func evalNumberListArgument(functionName string, arg parser.Expr, ctx *Context) (numbers []decimal.Decimal, err error) {
+	list, err := evalListArgument(functionName, arg, ctx)
+	if err != nil {
+		return
+	}
+
+	numbers = make([]decimal.Decimal, len(list.Values))
+	for i, element := range list.Values {
+		exprNumber, validateErr := validateNumber(element, functionName)
+		if validateErr != nil {
+			return nil, validateErr
+		}
+		numbers[i] = exprNumber.Value
+	}
+
+	return numbers, nil
+}
+
+// evalStatisticFunction implements a built-in function that reduces a
+// non-empty list of numbers to a single number, such as avg().
+func evalStatisticFunction(functionName string, args []parser.Expr, ctx *Context, f func([]decimal.Decimal) decimal.Decimal) (ret parser.Expr, err error) {
+	if len(args) != 1 {
+		err = fmt.Errorf("%w: %s() expects exactly 1 argument, got %d", ErrInvalidArgumentCount, functionName, len(args))
+		return
+	}
+
+	numbers, err := evalNumberListArgument(functionName, args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	if len(numbers) == 0 {
+		err = fmt.Errorf("%w: %s() expects a non-empty list", ErrInvalidArgumentCount, functionName)
+		return
+	}
+
+	return parser.ExprNumber{Value: f(numbers)}, nil
+}
+
+// evalSumFunction implements the sum() built-in function.
+// Returns the sum of a list of numbers, or 0 for an empty list.
+func evalSumFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 1 {
+		err = fmt.Errorf("%w: sum() expects exactly 1 argument, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	numbers, err := evalNumberListArgument("sum", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	return parser.ExprNumber{Value: sumDecimals(numbers)}, nil
+}
+
+// evalProductFunction implements the product() built-in function.
+// Returns the product of a list of numbers, or 1 for an empty list.
+func evalProductFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 1 {
+		err = fmt.Errorf("%w: product() expects exactly 1 argument, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	numbers, err := evalNumberListArgument("product", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	product := decimal.NewFromInt(1)
+	for _, number := range numbers {
+		product = product.Mul(number)
+	}
+
+	return parser.ExprNumber{Value: product}, nil
+}
+
+// evalAvgFunction implements the avg() built-in function.
+// Returns the arithmetic mean of a list of numbers.
+func evalAvgFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalStatisticFunction("avg", args, ctx, meanDecimals)
+}
+
+// evalMedianFunction implements the median() built-in function.
+// Returns the middle value of a list of numbers, or the mean of the two middle
+// values when the list has an even length.
+func evalMedianFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalStatisticFunction("median", args, ctx, func(numbers []decimal.Decimal) decimal.Decimal {
+		return percentileDecimals(numbers, decimal.NewFromInt(50))
+	})
+}
+
+// evalModeFunction implements the mode() built-in function.
+// Returns the most frequent value in a list of numbers. Ties are broken in
+// favour of the value that appears first.
+func evalModeFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalStatisticFunction("mode", args, ctx, func(numbers []decimal.Decimal) decimal.Decimal {
+		// Counts are keyed by the canonical string form, so that 1 and 1.0
+		// are counted as the same value
+		counts := make(map[string]int, len(numbers))
+		for _, number := range numbers {
+			counts[number.String()]++
+		}
+
+		mode, modeCount := numbers[0], 0
+		for _, number := range numbers {
+			if count := counts[number.String()]; count > modeCount {
+				mode, modeCount = number, count
+			}
+		}
+
+		return mode
+	})
+}
+
+// evalVarianceFunction implements the variance() built-in function.
+// Returns the population variance of a list of numbers.
+func evalVarianceFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalStatisticFunction("variance", args, ctx, varianceDecimals)
+}
+
+// evalStddevFunction implements the stddev() built-in function.
+// Returns the population standard deviation of a list of numbers.
+func evalStddevFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalStatisticFunction("stddev", args, ctx, func(numbers []decimal.Decimal) decimal.Decimal {
+		return sqrtDecimal(varianceDecimals(numbers))
+	})
+}
+
+// evalPercentileFunction implements the percentile() built-in function.
+// Returns the p-th percentile of a list of numbers, for p between 0 and 100,
+// interpolating linearly between the two closest values.
+func evalPercentileFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) != 2 {
+		err = fmt.Errorf("%w: percentile() expects exactly 2 arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	numbers, err := evalNumberListArgument("percentile", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	p, err := evalAndValidateNumber(args[1], ctx, "percentile")
+	if err != nil {
+		return
+	}
+
+	if p.Value.IsNegative() || p.Value.GreaterThan(decimal.NewFromInt(100)) {
+		err = fmt.Errorf("%w: percentile() second argument must be between 0 and 100, got %s", ErrInvalidArgumentType, p.Value)
+		return
+	}
+
+	if len(numbers) == 0 {
+		err = fmt.Errorf("%w: percentile() expects a non-empty list", ErrInvalidArgumentCount)
+		return
+	}
+
+	return parser.ExprNumber{Value: percentileDecimals(numbers, p.Value)}, nil
+}
+
+// sumDecimals returns the sum of numbers.
+func sumDecimals(numbers []decimal.Decimal) decimal.Decimal {
+	sum := decimal.Zero
+	for _, number := range numbers {
+		sum = sum.Add(number)
+	}
+	return sum
+}
+
+// meanDecimals returns the arithmetic mean of a non-empty list of numbers.
+func meanDecimals(numbers []decimal.Decimal) decimal.Decimal {
+	return sumDecimals(numbers).Div(decimal.NewFromInt(int64(len(numbers))))
+}
+
+// varianceDecimals returns the population variance of a non-empty list of
+// numbers.
+func varianceDecimals(numbers []decimal.Decimal) decimal.Decimal {
+	mean := meanDecimals(numbers)
+	squares := make([]decimal.Decimal, len(numbers))
+	for i, number := range numbers {
+		deviation := number.Sub(mean)
+		squares[i] = deviation.Mul(deviation)
+	}
+	return meanDecimals(squares)
+}
+
+// percentileDecimals returns the p-th percentile of a non-empty list of
+// numbers, interpolating linearly between the closest ranks. numbers is not
+// modified.
+func percentileDecimals(numbers []decimal.Decimal, p decimal.Decimal) decimal.Decimal {
+	sorted := make([]decimal.Decimal, len(numbers))
+	copy(sorted, numbers)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].LessThan(sorted[j])
+	})
+
+	// The rank is the fractional index of the percentile in the sorted list
+	rank := p.Mul(decimal.NewFromInt(int64(len(sorted) - 1))).Div(decimal.NewFromInt(100))
+	lower := rank.Floor()
+	index := int(lower.IntPart())
+	if index >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+
+	fraction := rank.Sub(lower)
+	return sorted[index].Add(sorted[index+1].Sub(sorted[index]).Mul(fraction))
+}
+
+// sqrtDecimal returns the square root of a non-negative number to
+// decimal.DivisionPrecision decimal places, using Newton's method.
+func sqrtDecimal(d decimal.Decimal) decimal.Decimal {
+	if !d.IsPositive() {
+		return decimal.Zero
+	}
+
+	precision := int32(decimal.DivisionPrecision)
+	two := decimal.NewFromInt(2)
+
+	// A float64 estimate leaves only a few iterations to reach full precision.
+	// Outside the range of float64, start from a power of ten of about half
+	// the magnitude instead
+	var x decimal.Decimal
+	if estimate := math.Sqrt(d.InexactFloat64()); estimate > 0 && !math.IsInf(estimate, 0) {
+		x = decimal.NewFromFloat(estimate)
+	} else {
+		x = decimal.New(1, (int32(d.NumDigits())+d.Exponent())/2)
+	}
+	for i := 0; i < 100; i++ {
+		next := x.Add(d.DivRound(x, precision+2)).DivRound(two, precision+2)
+		if next.Equal(x) {
+			break
+		}
+		x = next
+	}
+
+	return x.Round(precision)
+}
+
 // evalAndValidateNumber evaluates an expression and validates it's a number.
 // This is a helper function shared by numeric functions.
-func evalAndValidateNumber(arg parser.Expr, input any, funcName string) (parser.ExprNumber, error) {
-	argExpr, err := Eval(arg, input)
+func evalAndValidateNumber(arg parser.Expr, ctx *Context, funcName string) (parser.ExprNumber, error) {
+	argExpr, err := eval(arg, ctx)
 	if err != nil {
 		return parser.ExprNumber{}, fmt.Errorf("failed to evaluate %s() argument: %w", funcName, err)
 	}
@@ -2600,7 +5695,7 @@ func evalAndValidateNumber(arg parser.Expr, input any, funcName string) (parser.
 // This helper function works with already-evaluated expressions.
 func validateNumber(argExpr parser.Expr, funcName string) (parser.ExprNumber, error) {
 	if argExpr.Type() != parser.ExprType_Number {
-		return parser.ExprNumber{}, fmt.Errorf("%w: %s() can only be applied to numbers, got %s", ErrInvalidArgumentType, funcName, argExpr.String())
+		return parser.ExprNumber{}, withTypes(fmt.Errorf("%w: %s() can only be applied to numbers, got %s", ErrInvalidArgumentType, funcName, TypeName(argExpr)), argExpr)
 	}
 
 	exprNumber, ok := argExpr.(parser.ExprNumber)
@@ -2613,16 +5708,28 @@ func validateNumber(argExpr parser.Expr, funcName string) (parser.ExprNumber, er
 
 // evalSortFunction implements sort() built-in function.
 // Sorts lists and strings in ascending order.
-func evalSortFunction(args []parser.Expr, input any) (ret parser.Expr, err error) {
+func evalSortFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalSort("sort", args, ctx, false)
+}
+
+// evalSortDescFunction implements sort_desc() built-in function.
+// Sorts lists and strings in descending order.
+func evalSortDescFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	return evalSort("sort_desc", args, ctx, true)
+}
+
+// evalSort implements the sort() and sort_desc() built-in functions. Lists are
+// sorted stably, so equal elements keep their order.
+func evalSort(functionName string, args []parser.Expr, ctx *Context, descending bool) (ret parser.Expr, err error) {
 	if len(args) != 1 {
-		err = fmt.Errorf("%w: sort() expects exactly 1 argument, got %d", ErrInvalidArgumentCount, len(args))
+		err = fmt.Errorf("%w: %s() expects exactly 1 argument, got %d", ErrInvalidArgumentCount, functionName, len(args))
 		return
 	}
 
 	// Evaluate argument
-	argExpr, err := Eval(args[0], input)
+	argExpr, err := eval(args[0], ctx)
 	if err != nil {
-		err = fmt.Errorf("failed to evaluate sort() argument: %w", err)
+		err = fmt.Errorf("failed to evaluate %s() argument: %w", functionName, err)
 		return
 	}
 
@@ -2639,8 +5746,8 @@ func evalSortFunction(args []parser.Expr, input any) (ret parser.Expr, err error
 		copy(sortedValues, exprList.Values)
 
 		// Sort the list using our custom comparison function
-		sort.Slice(sortedValues, func(i, j int) bool {
-			return compareExpressions(sortedValues[i], sortedValues[j]) < 0
+		sort.SliceStable(sortedValues, func(i, j int) bool {
+			return orderedBefore(compareExpressions(sortedValues[i], sortedValues[j]), descending)
 		})
 
 		return parser.ExprList{Values: sortedValues}, nil
@@ -2655,6 +5762,9 @@ func evalSortFunction(args []parser.Expr, input any) (ret parser.Expr, err error
 		// Convert string to rune slice for proper sorting
 		runes := []rune(exprString.Value)
 		sort.Slice(runes, func(i, j int) bool {
+			if descending {
+				return runes[i] > runes[j]
+			}
 			return runes[i] < runes[j]
 		})
 
@@ -2662,27 +5772,102 @@ func evalSortFunction(args []parser.Expr, input any) (ret parser.Expr, err error
 		sortedString := string(runes)
 		return parser.ExprString{Value: sortedString}, nil
 
+	case parser.ExprType_Null:
+		// Sorting null yields null
+		return argExpr, nil
+
 	case parser.ExprType_Number:
-		err = fmt.Errorf("%w: sort() cannot be applied to numbers", ErrInvalidArgumentType)
+		err = withTypes(fmt.Errorf("%w: %s() cannot be applied to numbers", ErrInvalidArgumentType, functionName), argExpr)
 		return
 
 	case parser.ExprType_Boolean:
-		err = fmt.Errorf("%w: sort() cannot be applied to booleans", ErrInvalidArgumentType)
+		err = withTypes(fmt.Errorf("%w: %s() cannot be applied to booleans", ErrInvalidArgumentType, functionName), argExpr)
 		return
 
 	case parser.ExprType_Map:
-		err = fmt.Errorf("%w: sort() cannot be applied to maps", ErrInvalidArgumentType)
+		err = withTypes(fmt.Errorf("%w: %s() cannot be applied to maps", ErrInvalidArgumentType, functionName), argExpr)
 		return
 
 	default:
-		err = fmt.Errorf("%w: sort() cannot be applied to type %s", ErrInvalidArgumentType, argExpr.String())
+		err = withTypes(fmt.Errorf("%w: %s() cannot be applied to type %s", ErrInvalidArgumentType, functionName, TypeName(argExpr)), argExpr)
 		return
 	}
 }
 
+// evalSortByFunction implements the sort_by() built-in function.
+// Sorts a list stably by the key that an expression evaluates to for each
+// element, with `_` bound to the element. A key expression that evaluates to a
+// list, such as [_.last, _.first], sorts by each key in turn. An optional
+// third argument sorts in descending order when true.
+func evalSortByFunction(args []parser.Expr, ctx *Context) (ret parser.Expr, err error) {
+	if len(args) < 2 || len(args) > 3 {
+		err = fmt.Errorf("%w: sort_by() expects 2 or 3 arguments, got %d", ErrInvalidArgumentCount, len(args))
+		return
+	}
+
+	list, err := evalListArgument("sort_by", args[0], ctx)
+	if err != nil {
+		return
+	}
+
+	descending := false
+	if len(args) == 3 {
+		descendingExpr, evalErr := eval(args[2], ctx)
+		if evalErr != nil {
+			err = fmt.Errorf("failed to evaluate sort_by() third argument: %w", evalErr)
+			return
+		}
+
+		descendingBoolean, ok := descendingExpr.(parser.ExprBoolean)
+		if !ok {
+			err = withTypes(fmt.Errorf("%w: sort_by() third argument must be a boolean, got %s", ErrInvalidArgumentType, TypeName(descendingExpr)), descendingExpr)
+			return
+		}
+		descending = descendingBoolean.Value
+	}
+
+	// Keys are evaluated once per element, and sorted together with the
+	// elements they belong to
+	type keyedElement struct {
+		key     parser.Expr
+		element parser.Expr
+	}
+
+	keyed := make([]keyedElement, len(list.Values))
+	for i, element := range list.Values {
+		key, evalErr := ctx.Apply(args[1], element)
+		if evalErr != nil {
+			err = fmt.Errorf("failed to evaluate sort_by expression: %w", evalErr)
+			return
+		}
+		keyed[i] = keyedElement{key: key, element: element}
+	}
+
+	sort.SliceStable(keyed, func(i, j int) bool {
+		return orderedBefore(compareExpressions(keyed[i].key, keyed[j].key), descending)
+	})
+
+	sortedValues := make([]parser.Expr, len(keyed))
+	for i, k := range keyed {
+		sortedValues[i] = k.element
+	}
+
+	return parser.ExprList{Values: sortedValues}, nil
+}
+
+// orderedBefore reports whether a value that compared to another as
+// comparison sorts before it.
+func orderedBefore(comparison int, descending bool) bool {
+	if descending {
+		return comparison > 0
+	}
+	return comparison < 0
+}
+
 // compareExpressions compares two expressions for sorting.
 // Returns -1 if a < b, 0 if a == b, 1 if a > b
-// Uses type hierarchy: numbers < strings < booleans
+// Uses type hierarchy: null < numbers < strings < booleans < times <
+// durations < lists < maps
 func compareExpressions(a, b parser.Expr) int {
 	// If types are different, use type hierarchy
 	if a.Type() != b.Type() {
@@ -2726,18 +5911,71 @@ func compareExpressions(a, b parser.Expr) int {
 		}
 		return 0
 
+	case parser.ExprType_Time, parser.ExprType_Duration:
+		return compareTemporal(a, b)
+
+	case parser.ExprType_List:
+		aList, ok1 := a.(parser.ExprList)
+		bList, ok2 := b.(parser.ExprList)
+		if !ok1 || !ok2 {
+			return 0
+		}
+		// Lists compare element by element, and a list sorts before any
+		// longer list that it is a prefix of
+		for i := 0; i < len(aList.Values) && i < len(bList.Values); i++ {
+			if c := compareExpressions(aList.Values[i], bList.Values[i]); c != 0 {
+				return c
+			}
+		}
+		return cmp.Compare(len(aList.Values), len(bList.Values))
+
+	case parser.ExprType_Map:
+		aMap, ok1 := a.(parser.ExprMap)
+		bMap, ok2 := b.(parser.ExprMap)
+		if !ok1 || !ok2 {
+			return 0
+		}
+		// Maps compare pair by pair in key order, by key and then by value,
+		// so the order of their keys does not matter
+		aPairs, bPairs := sortedMapPairs(aMap.Pairs), sortedMapPairs(bMap.Pairs)
+		for i := 0; i < len(aPairs) && i < len(bPairs); i++ {
+			if c := compareExpressions(aPairs[i].Key, bPairs[i].Key); c != 0 {
+				return c
+			}
+			if c := compareExpressions(aPairs[i].Value, bPairs[i].Value); c != 0 {
+				return c
+			}
+		}
+		return cmp.Compare(len(aPairs), len(bPairs))
+
 	default:
 		return 0
 	}
 }
 
+// sortedMapPairs returns a copy of pairs ordered by key.
+func sortedMapPairs(pairs []parser.ExprMapPair) []parser.ExprMapPair {
+	sorted := make([]parser.ExprMapPair, len(pairs))
+	copy(sorted, pairs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return compareExpressions(sorted[i].Key, sorted[j].Key) < 0
+	})
+	return sorted
+}
+
 // compareTypes compares expression types for sorting.
-// Uses hierarchy: numbers < strings < booleans
+// Uses hierarchy: null < numbers < strings < booleans < times < durations <
+// lists < maps
 func compareTypes(typeA, typeB int) int {
 	typeOrder := map[int]int{
-		parser.ExprType_Number:  0,
-		parser.ExprType_String:  1,
-		parser.ExprType_Boolean: 2,
+		parser.ExprType_Null:     0,
+		parser.ExprType_Number:   1,
+		parser.ExprType_String:   2,
+		parser.ExprType_Boolean:  3,
+		parser.ExprType_Time:     4,
+		parser.ExprType_Duration: 5,
+		parser.ExprType_List:     6,
+		parser.ExprType_Map:      7,
 	}
 
 	orderA, existsA := typeOrder[typeA]
@@ -2757,13 +5995,52 @@ func compareTypes(typeA, typeB int) int {
 
 // convertInputToExpr converts input data to appropriate expression types.
 func convertInputToExpr(input any) (parser.Expr, error) {
+	return convertValue(input, make(map[visit]bool))
+}
+
+// visit identifies a map, slice or pointer whose contents are being
+// converted.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+// enterValue records that the contents of val are being converted, failing if
+// they already are, which means the value contains itself. leave removes the
+// record once the contents have been converted.
+func enterValue(visiting map[visit]bool, val reflect.Value) (leave func(), err error) {
+	key := visit{ptr: val.Pointer(), typ: val.Type()}
+	if val.Kind() == reflect.Slice {
+		key.len = val.Len()
+	}
+
+	if visiting[key] {
+		return nil, fmt.Errorf("%w: input contains itself through a %s", ErrIncompatibleTypes, val.Type())
+	}
+
+	visiting[key] = true
+	return func() { delete(visiting, key) }, nil
+}
+
+// convertValue converts input as convertInputToExpr does. visiting holds the
+// maps, slices and pointers that enclose input.
+func convertValue(input any, visiting map[visit]bool) (parser.Expr, error) {
 	if input == nil {
-		return nil, fmt.Errorf("%w: input data cannot be nil", ErrIncompatibleTypes)
+		return parser.ExprNull{}, nil
 	}
 
 	switch v := input.(type) {
+	case parser.Expr:
+		// Already-evaluated expressions (e.g. list elements bound to `_`) are
+		// returned as-is
+		return v, nil
 	case string:
 		return parser.ExprString{Value: v}, nil
+	case time.Time:
+		return parser.ExprTime{Value: v}, nil
+	case time.Duration:
+		return parser.ExprDuration{Value: v}, nil
 	case int:
 		return parser.ExprNumber{Value: decimal.NewFromInt(int64(v))}, nil
 	case int8:
@@ -2775,7 +6052,7 @@ func convertInputToExpr(input any) (parser.Expr, error) {
 	case int64:
 		return parser.ExprNumber{Value: decimal.NewFromInt(v)}, nil
 	case uint:
-		return parser.ExprNumber{Value: decimal.NewFromInt(int64(v))}, nil
+		return parser.ExprNumber{Value: decimal.NewFromUint64(uint64(v))}, nil
 	case uint8:
 		return parser.ExprNumber{Value: decimal.NewFromInt(int64(v))}, nil
 	case uint16:
@@ -2783,7 +6060,7 @@ func convertInputToExpr(input any) (parser.Expr, error) {
 	case uint32:
 		return parser.ExprNumber{Value: decimal.NewFromInt(int64(v))}, nil
 	case uint64:
-		return parser.ExprNumber{Value: decimal.NewFromInt(int64(v))}, nil
+		return parser.ExprNumber{Value: decimal.NewFromUint64(v)}, nil
 	case float32:
 		return parser.ExprNumber{Value: decimal.NewFromFloat32(v)}, nil
 	case float64:
@@ -2791,9 +6068,17 @@ func convertInputToExpr(input any) (parser.Expr, error) {
 	case bool:
 		return parser.ExprBoolean{Value: v}, nil
 	case []any:
+		if len(v) > 0 {
+			leave, err := enterValue(visiting, reflect.ValueOf(v))
+			if err != nil {
+				return nil, err
+			}
+			defer leave()
+		}
+
 		var values []parser.Expr
 		for _, item := range v {
-			expr, err := convertInputToExpr(item)
+			expr, err := convertValue(item, visiting)
 			if err != nil {
 				return nil, fmt.Errorf("failed to convert list item: %w", err)
 			}
@@ -2825,9 +6110,15 @@ func convertInputToExpr(input any) (parser.Expr, error) {
 		}
 		return parser.ExprList{Values: values}, nil
 	case map[string]any:
+		leave, err := enterValue(visiting, reflect.ValueOf(v))
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+
 		var pairs []parser.ExprMapPair
 		for key, value := range v {
-			valueExpr, err := convertInputToExpr(value)
+			valueExpr, err := convertValue(value, visiting)
 			if err != nil {
 				return nil, fmt.Errorf("failed to convert map value for key %q: %w", key, err)
 			}
@@ -2836,8 +6127,15 @@ func convertInputToExpr(input any) (parser.Expr, error) {
 				Value: valueExpr,
 			})
 		}
+		sortMapPairs(pairs)
 		return parser.ExprMap{Pairs: pairs}, nil
 	case map[any]any:
+		leave, err := enterValue(visiting, reflect.ValueOf(v))
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+
 		var pairs []parser.ExprMapPair
 		for key, value := range v {
 			// Convert key to string
@@ -2852,7 +6150,7 @@ func convertInputToExpr(input any) (parser.Expr, error) {
 				return nil, fmt.Errorf("unsupported map key type: %T", key)
 			}
 
-			valueExpr, err := convertInputToExpr(value)
+			valueExpr, err := convertValue(value, visiting)
 			if err != nil {
 				return nil, fmt.Errorf("failed to convert map value for key %v: %w", key, err)
 			}
@@ -2861,8 +6159,135 @@ func convertInputToExpr(input any) (parser.Expr, error) {
 				Value: valueExpr,
 			})
 		}
+		sortMapPairs(pairs)
+		return parser.ExprMap{Pairs: pairs}, nil
+	default:
+		return convertReflectValueToExpr(reflect.ValueOf(input), visiting)
+	}
+}
+
+// convertReflectValueToExpr converts arbitrary Go values (structs, pointers,
+// typed slices, arrays and maps, and named primitive types) to appropriate
+// expression types using reflection.
+func convertReflectValueToExpr(val reflect.Value, visiting map[visit]bool) (parser.Expr, error) {
+	// Times and durations are a struct and an integer underneath, so they are
+	// matched by type before their kind
+	if val.IsValid() && val.CanInterface() {
+		switch v := val.Interface().(type) {
+		case time.Time:
+			return parser.ExprTime{Value: v}, nil
+		case time.Duration:
+			return parser.ExprDuration{Value: v}, nil
+		}
+	}
+
+	switch val.Kind() {
+	case reflect.Invalid:
+		return parser.ExprNull{}, nil
+	case reflect.Ptr, reflect.Interface:
+		if val.IsNil() {
+			return parser.ExprNull{}, nil
+		}
+		if val.Kind() == reflect.Ptr {
+			leave, err := enterValue(visiting, val)
+			if err != nil {
+				return nil, err
+			}
+			defer leave()
+		}
+		return convertReflectValueToExpr(val.Elem(), visiting)
+	case reflect.String:
+		return parser.ExprString{Value: val.String()}, nil
+	case reflect.Bool:
+		return parser.ExprBoolean{Value: val.Bool()}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return parser.ExprNumber{Value: decimal.NewFromInt(val.Int())}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return parser.ExprNumber{Value: decimal.NewFromUint64(val.Uint())}, nil
+	case reflect.Float32:
+		return parser.ExprNumber{Value: decimal.NewFromFloat32(float32(val.Float()))}, nil
+	case reflect.Float64:
+		return parser.ExprNumber{Value: decimal.NewFromFloat(val.Float())}, nil
+	case reflect.Slice, reflect.Array:
+		if val.Kind() == reflect.Slice && val.Len() > 0 {
+			leave, err := enterValue(visiting, val)
+			if err != nil {
+				return nil, err
+			}
+			defer leave()
+		}
+
+		var values []parser.Expr
+		for i := range val.Len() {
+			expr, err := convertReflectValueToExpr(val.Index(i), visiting)
+			if err != nil {
+				return nil, fmt.Errorf("failed to convert list item: %w", err)
+			}
+			values = append(values, expr)
+		}
+		return parser.ExprList{Values: values}, nil
+	case reflect.Map:
+		if !val.IsNil() {
+			leave, err := enterValue(visiting, val)
+			if err != nil {
+				return nil, err
+			}
+			defer leave()
+		}
+
+		var pairs []parser.ExprMapPair
+		iter := val.MapRange()
+		for iter.Next() {
+			keyExpr, err := convertReflectMapKeyToExpr(iter.Key())
+			if err != nil {
+				return nil, err
+			}
+
+			valueExpr, err := convertReflectValueToExpr(iter.Value(), visiting)
+			if err != nil {
+				return nil, fmt.Errorf("failed to convert map value for key %v: %w", iter.Key(), err)
+			}
+			pairs = append(pairs, parser.ExprMapPair{
+				Key:   keyExpr,
+				Value: valueExpr,
+			})
+		}
+		sortMapPairs(pairs)
+		return parser.ExprMap{Pairs: pairs}, nil
+	case reflect.Struct:
+		var pairs []parser.ExprMapPair
+		for _, field := range internal.StructFields(val) {
+			valueExpr, err := convertReflectValueToExpr(field.Value, visiting)
+			if err != nil {
+				return nil, fmt.Errorf("failed to convert struct field %q: %w", field.Name, err)
+			}
+			pairs = append(pairs, parser.ExprMapPair{
+				Key:   parser.ExprString{Value: field.Name},
+				Value: valueExpr,
+			})
+		}
 		return parser.ExprMap{Pairs: pairs}, nil
 	default:
-		return nil, fmt.Errorf("%w: unsupported input type: %T", ErrIncompatibleTypes, input)
+		return nil, fmt.Errorf("%w: unsupported input type: %s", ErrIncompatibleTypes, val.Type())
+	}
+}
+
+// convertReflectMapKeyToExpr converts a map key to a string expression.
+func convertReflectMapKeyToExpr(key reflect.Value) (parser.Expr, error) {
+	for key.Kind() == reflect.Interface && !key.IsNil() {
+		key = key.Elem()
+	}
+
+	switch key.Kind() {
+	case reflect.String:
+		return parser.ExprString{Value: key.String()}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return parser.ExprString{Value: strconv.FormatInt(key.Int(), 10)}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return parser.ExprString{Value: strconv.FormatUint(key.Uint(), 10)}, nil
+	case reflect.Float32, reflect.Float64:
+		return parser.ExprString{Value: strconv.FormatFloat(key.Float(), 'g', -1, key.Type().Bits())}, nil
+	default:
+		return nil, fmt.Errorf("unsupported map key type: %s", key.Type())
 	}
 }
@@ -1,9 +1,24 @@
 package internal
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
+)
+
+// Errors wrapped by the errors LookupPath returns, identifying why a lookup
+// failed.
+var (
+	// ErrNotFound is wrapped when a map has no such key or a struct no such
+	// field.
+	ErrNotFound = errors.New("not found")
+	// ErrOutOfBounds is wrapped when a slice or array index is out of range.
+	ErrOutOfBounds = errors.New("out of bounds")
+	// ErrCannotLookup is wrapped when the value cannot be looked into by key,
+	// such as nil or a string.
+	ErrCannotLookup = errors.New("cannot lookup")
 )
 
 // LookupPath retrieves a value from a nested data structure at the location
@@ -22,11 +37,11 @@ func LookupPath(data any, path []string) (result any, err error) {
 	case []any:
 		result, err = lookupPathSlice(d, key)
 	default:
-		result, err = lookupPathStruct(d, key)
+		result, err = lookupPathReflect(d, key)
 	}
 
 	if err != nil {
-		err = fmt.Errorf("failed to get value at %q", key)
+		err = fmt.Errorf("failed to get value at %q: %w", key, err)
 		return
 	}
 
@@ -40,7 +55,7 @@ func LookupPath(data any, path []string) (result any, err error) {
 func lookupPathMap(data map[string]any, key string) (result any, err error) {
 	result, ok := data[key]
 	if !ok {
-		err = fmt.Errorf("key %q not found", key)
+		err = fmt.Errorf("key %q %w", key, ErrNotFound)
 		return
 	}
 
@@ -50,49 +65,277 @@ func lookupPathMap(data map[string]any, key string) (result any, err error) {
 func lookupPathSlice(data []any, key string) (result any, err error) {
 	index, err := strconv.Atoi(key)
 	if err != nil {
-		err = fmt.Errorf("failed to parse %q as int", key)
+		err = fmt.Errorf("%w %q in a slice", ErrCannotLookup, key)
 		return
 	}
 
-	if len(data) < index+1 {
-		err = fmt.Errorf("index %d out of bounds", index)
+	if index < 0 || len(data) < index+1 {
+		err = fmt.Errorf("index %d %w", index, ErrOutOfBounds)
 		return
 	}
 
 	return data[index], nil
 }
 
+// lookupPathReflect retrieves a value from typed slices, arrays and maps that
+// aren't covered by the fast paths in LookupPath, falling back to struct
+// field lookup for everything else.
+func lookupPathReflect(data any, key string) (result any, err error) {
+	val := reflect.ValueOf(data)
+
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			err = fmt.Errorf("%w %q in nil value", ErrCannotLookup, key)
+			return
+		}
+		val = val.Elem()
+	}
+
+	if !val.IsValid() {
+		err = fmt.Errorf("%w %q in nil value", ErrCannotLookup, key)
+		return
+	}
+
+	switch val.Kind() {
+	case reflect.Slice, reflect.Array:
+		index, convErr := strconv.Atoi(key)
+		if convErr != nil {
+			err = fmt.Errorf("%w %q in a slice", ErrCannotLookup, key)
+			return
+		}
+
+		if index < 0 || val.Len() < index+1 {
+			err = fmt.Errorf("index %d %w", index, ErrOutOfBounds)
+			return
+		}
+
+		elem := val.Index(index)
+		if !elem.CanInterface() {
+			err = fmt.Errorf("failed to assert data to interface")
+			return
+		}
+
+		return elem.Interface(), nil
+
+	case reflect.Map:
+		if val.Type().Key().Kind() != reflect.String {
+			err = fmt.Errorf("%w %q in a map with %s keys", ErrCannotLookup, key, val.Type().Key())
+			return
+		}
+
+		elem := val.MapIndex(reflect.ValueOf(key).Convert(val.Type().Key()))
+		if !elem.IsValid() {
+			err = fmt.Errorf("key %q %w", key, ErrNotFound)
+			return
+		}
+
+		return elem.Interface(), nil
+
+	default:
+		return lookupPathStruct(val.Interface(), key)
+	}
+}
+
 func lookupPathStruct(data any, key string) (result any, err error) {
 	val := reflect.ValueOf(data)
-	typ := reflect.TypeOf(data)
 
 	if val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			err = fmt.Errorf("%w %q in nil value", ErrCannotLookup, key)
+			return
+		}
 		val = val.Elem()
-		typ = typ.Elem()
 	}
 
 	if val.Kind() != reflect.Struct {
-		err = fmt.Errorf("unsupported type %T", data)
+		err = fmt.Errorf("%w %q in %T", ErrCannotLookup, key, data)
 		return
 	}
 
-	for i := range val.NumField() {
-		fieldType := typ.Field(i)
-		fieldName := fieldType.Name
-
-		if fieldName != key {
+	for _, field := range StructFields(val) {
+		if field.Name != key {
 			continue
 		}
 
-		field := val.Field(i)
-		if !field.CanInterface() {
+		if !field.Value.CanInterface() {
 			err = fmt.Errorf("failed to assert data to interface")
 			return
 		}
 
-		return field.Interface(), nil
+		return field.Value.Interface(), nil
 	}
 
-	err = fmt.Errorf("field %q not found", key)
+	err = fmt.Errorf("field %q %w", key, ErrNotFound)
 	return
 }
+
+// StructField is a struct field as seen by fpath queries.
+type StructField struct {
+	Name  string
+	Value reflect.Value
+}
+
+// StructFields returns the fields of the provided struct value that are
+// visible to fpath queries, in declaration order.
+// Field names are taken from the `fpath` tag, then the `json` tag, then the Go
+// field name. Unexported fields and fields tagged "-" are skipped, fields
+// tagged "omitempty" are skipped when they hold their zero value, and the
+// fields of untagged embedded structs are promoted into the parent, after the
+// parent's own fields.
+// As in encoding/json, when several fields share a name the shallowest one
+// wins, then the only tagged one at that depth; if there is still more than
+// one, none of them is visible. Fields of nil embedded pointers are skipped.
+func StructFields(val reflect.Value) (fields []StructField) {
+	candidates := structFieldCandidates(val, 0, map[reflect.Type]bool{})
+
+	byName := make(map[string][]int)
+	for i, candidate := range candidates {
+		byName[candidate.Name] = append(byName[candidate.Name], i)
+	}
+
+	for i, candidate := range candidates {
+		if dominantField(candidates, byName[candidate.Name]) != i {
+			continue
+		}
+
+		if candidate.omitEmpty && candidate.Value.IsZero() {
+			continue
+		}
+
+		fields = append(fields, candidate.StructField)
+	}
+
+	return fields
+}
+
+// structFieldCandidate is a field that StructFields may return, before
+// fields hidden by another field with the same name are removed.
+type structFieldCandidate struct {
+	StructField
+	depth     int
+	tagged    bool
+	omitEmpty bool
+}
+
+// structFieldCandidates returns the fields of a struct value followed by the
+// fields promoted from its embedded structs. path holds the types of the
+// enclosing structs, so that a type embedding itself is not followed forever.
+func structFieldCandidates(val reflect.Value, depth int, path map[reflect.Type]bool) (candidates []structFieldCandidate) {
+	typ := val.Type()
+	path[typ] = true
+	defer delete(path, typ)
+
+	var embedded []reflect.Value
+
+	for i := range val.NumField() {
+		fieldType := typ.Field(i)
+		field := val.Field(i)
+
+		name, omitEmpty, skip := parseStructTag(fieldType)
+		if skip {
+			continue
+		}
+
+		if fieldType.Anonymous && name == "" {
+			embeddedVal := field
+			if embeddedVal.Kind() == reflect.Ptr {
+				if embeddedVal.IsNil() {
+					continue
+				}
+				embeddedVal = embeddedVal.Elem()
+			}
+
+			if embeddedVal.Kind() == reflect.Struct {
+				if !path[embeddedVal.Type()] {
+					embedded = append(embedded, embeddedVal)
+				}
+				continue
+			}
+		}
+
+		if !fieldType.IsExported() {
+			continue
+		}
+
+		tagged := name != ""
+		if !tagged {
+			name = fieldType.Name
+		}
+
+		candidates = append(candidates, structFieldCandidate{
+			StructField: StructField{Name: name, Value: field},
+			depth:       depth,
+			tagged:      tagged,
+			omitEmpty:   omitEmpty,
+		})
+	}
+
+	for _, embeddedVal := range embedded {
+		candidates = append(candidates, structFieldCandidates(embeddedVal, depth+1, path)...)
+	}
+
+	return candidates
+}
+
+// dominantField returns the index of the candidate that a field name refers
+// to, given the indexes of the candidates with that name, or -1 when the name
+// is ambiguous.
+func dominantField(candidates []structFieldCandidate, indexes []int) int {
+	depth := candidates[indexes[0]].depth
+	for _, i := range indexes {
+		depth = min(depth, candidates[i].depth)
+	}
+
+	shallowest, tagged := -1, -1
+	shallowestCount, taggedCount := 0, 0
+	for _, i := range indexes {
+		if candidates[i].depth != depth {
+			continue
+		}
+
+		shallowest = i
+		shallowestCount++
+		if candidates[i].tagged {
+			tagged = i
+			taggedCount++
+		}
+	}
+
+	switch {
+	case shallowestCount == 1:
+		return shallowest
+	case taggedCount == 1:
+		return tagged
+	default:
+		return -1
+	}
+}
+
+// parseStructTag returns the name and options declared for a struct field by
+// its `fpath` tag, falling back to its `json` tag.
+func parseStructTag(field reflect.StructField) (name string, omitEmpty bool, skip bool) {
+	tag, ok := field.Tag.Lookup("fpath")
+	if !ok {
+		tag, ok = field.Tag.Lookup("json")
+	}
+
+	if !ok {
+		return
+	}
+
+	if tag == "-" {
+		skip = true
+		return
+	}
+
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+
+	for _, option := range parts[1:] {
+		if option == "omitempty" {
+			omitEmpty = true
+		}
+	}
+
+	return name, omitEmpty, false
+}
@@ -1,6 +1,7 @@
 package internal_test
 
 import (
+	"errors"
 	"reflect"
 	"testing"
 
@@ -60,3 +61,224 @@ func Test_LookupPath(t *testing.T) {
 		})
 	}
 }
+
+func Test_LookupPath_Reflect(t *testing.T) {
+	type item struct {
+		Price int
+	}
+
+	type order struct {
+		Items []item
+	}
+
+	testCases := map[string]struct {
+		data     any
+		path     []string
+		expected any
+	}{
+		"typed slice": {
+			data:     []string{"hello", "world"},
+			path:     []string{"1"},
+			expected: "world",
+		},
+		"typed map": {
+			data:     map[string]int{"hello": 1},
+			path:     []string{"hello"},
+			expected: 1,
+		},
+		"array": {
+			data:     [2]int{1, 2},
+			path:     []string{"0"},
+			expected: 1,
+		},
+		"struct pointer": {
+			data:     &item{Price: 5},
+			path:     []string{"Price"},
+			expected: 5,
+		},
+		"struct with slice of structs": {
+			data:     order{Items: []item{{Price: 1}, {Price: 2}}},
+			path:     []string{"Items", "1", "Price"},
+			expected: 2,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			result, err := internal.LookupPath(tc.data, tc.path)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Fatalf("Unexpected result\nExpected: %v\nActual: %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func Test_LookupPath_Errors(t *testing.T) {
+	testCases := map[string]struct {
+		data     any
+		path     []string
+		expected error
+	}{
+		"missing map key": {
+			data:     map[string]any{"hello": "world"},
+			path:     []string{"missing"},
+			expected: internal.ErrNotFound,
+		},
+		"missing struct field": {
+			data:     struct{ Field string }{},
+			path:     []string{"Other"},
+			expected: internal.ErrNotFound,
+		},
+		"slice out of bounds": {
+			data:     []int{1},
+			path:     []string{"3"},
+			expected: internal.ErrOutOfBounds,
+		},
+		"negative slice index": {
+			data:     []any{1},
+			path:     []string{"-1"},
+			expected: internal.ErrOutOfBounds,
+		},
+		"slice with non-integer key": {
+			data:     []any{1},
+			path:     []string{"first"},
+			expected: internal.ErrCannotLookup,
+		},
+		"string": {
+			data:     map[string]any{"name": "Alice"},
+			path:     []string{"name", "first"},
+			expected: internal.ErrCannotLookup,
+		},
+		"nil pointer": {
+			data:     (*struct{ Field string })(nil),
+			path:     []string{"Field"},
+			expected: internal.ErrCannotLookup,
+		},
+		"nil data": {
+			data:     nil,
+			path:     []string{"Field"},
+			expected: internal.ErrCannotLookup,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := internal.LookupPath(tc.data, tc.path)
+			if !errors.Is(err, tc.expected) {
+				t.Fatalf("Unexpected error\nExpected: %v\nActual: %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func Test_StructFields(t *testing.T) {
+	type embedded struct {
+		ID   int
+		Name string
+	}
+
+	type testStruct struct {
+		embedded
+		Name     string `json:"name"`
+		Alias    string `fpath:"alias" json:"ignored"`
+		Optional string `json:"optional,omitempty"`
+		Skipped  string `json:"-"`
+		private  string
+	}
+
+	data := testStruct{
+		embedded: embedded{ID: 1, Name: "shadowed"},
+		Name:     "hello",
+		Alias:    "world",
+		Skipped:  "skipped",
+		private:  "private",
+	}
+
+	var names []string
+	for _, field := range internal.StructFields(reflect.ValueOf(data)) {
+		names = append(names, field.Name)
+	}
+
+	expected := []string{"name", "alias", "ID", "Name"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("Unexpected result\nExpected: %v\nActual: %v", expected, names)
+	}
+
+	result, err := internal.LookupPath(data, []string{"alias"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if result != "world" {
+		t.Fatalf("Unexpected result\nExpected: %v\nActual: %v", "world", result)
+	}
+}
+
+func Test_StructFields_Embedding(t *testing.T) {
+	type first struct {
+		ID     int
+		Shared string
+		Label  string `fpath:"label"`
+	}
+
+	type second struct {
+		ID     int
+		Shared string `fpath:"Shared"`
+		Label  string `fpath:"label"`
+		Deep   first
+	}
+
+	type inner struct {
+		first
+		Inner string
+	}
+
+	type node struct {
+		*node
+		Value int
+	}
+
+	self := &node{Value: 1}
+	self.node = self
+
+	testCases := map[string]struct {
+		data     any
+		expected []string
+	}{
+		"ambiguous fields at the same depth are hidden": {
+			data: struct {
+				first
+				second
+			}{},
+			expected: []string{"Shared", "Deep"},
+		},
+		"shallower field wins": {
+			data: struct {
+				inner
+				ID string
+			}{},
+			expected: []string{"ID", "Inner", "Shared", "label"},
+		},
+		"embedded type containing itself": {
+			data:     *self,
+			expected: []string{"Value"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var names []string
+			for _, field := range internal.StructFields(reflect.ValueOf(tc.data)) {
+				names = append(names, field.Name)
+			}
+
+			if !reflect.DeepEqual(names, tc.expected) {
+				t.Fatalf("Unexpected result\nExpected: %v\nActual: %v", tc.expected, names)
+			}
+		})
+	}
+}